@@ -0,0 +1,415 @@
+// bgp-dnsctl controls a running bgp-dnsd through the admin API on its control socket, Admin.Socket.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+const defaultSocket = "/run/bgp-dns/bgp-dnsd.sock"
+
+var (
+	version = "dev"
+	commit  = "none"
+	date    = "unknown"
+)
+
+var EUsage = errors.New("usage")
+
+type entry struct {
+	Fqdn        string    `json:"fqdn"`
+	Ips         []string  `json:"ips"`
+	Ttl         uint32    `json:"ttl"`
+	Expiration  time.Time `json:"expiration"`
+	Rule        string    `json:"rule"`
+	Lists       []string  `json:"lists"`
+	Communities []string  `json:"communities"`
+}
+
+type domain struct {
+	Rule        string   `json:"rule"`
+	Kind        string   `json:"kind"`
+	Lists       []string `json:"lists"`
+	Communities []string `json:"communities"`
+}
+
+type holder struct {
+	Name  string   `json:"name"`
+	Rule  string   `json:"rule"`
+	Lists []string `json:"lists"`
+}
+
+type route struct {
+	Prefix      string   `json:"prefix"`
+	Refs        uint64   `json:"refs"`
+	Holders     []holder `json:"holders"`
+	Communities []string `json:"communities"`
+}
+
+type peer struct {
+	Address    string    `json:"address"`
+	Asn        uint32    `json:"asn"`
+	State      string    `json:"state"`
+	Since      time.Time `json:"since"`
+	Advertised uint64    `json:"advertised"`
+}
+
+type corrections struct {
+	Reindexed []string `json:"reindexed"`
+	Announced []string `json:"announced"`
+	Updated   []string `json:"updated"`
+	Removed   []string `json:"removed"`
+}
+
+type why struct {
+	Ip     string  `json:"ip"`
+	Routes []route `json:"routes"`
+}
+
+// client sends the requests to the daemon, over the control socket unless base is a TCP URL.
+type client struct {
+	http *http.Client
+	base string
+	raw  bool
+}
+
+func newClient(socket, base string, timeout time.Duration, raw bool) *client {
+	c := &client{http: &http.Client{Timeout: timeout}, base: strings.TrimRight(base, "/"), raw: raw}
+	if c.base == "" {
+		c.base = "http://bgp-dnsd"
+		c.http.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+	}
+	return c
+}
+
+// call sends a request to path and decodes the JSON reply into v unless it is nil. With --json the reply is
+// printed as is instead.
+func (c *client) call(method, path string, body any, v any) error {
+	var rd io.Reader
+	if body != nil {
+		b, e := json.Marshal(body)
+		if e != nil {
+			return e
+		}
+		rd = bytes.NewReader(b)
+	}
+	req, e := http.NewRequest(method, c.base+path, rd)
+	if e != nil {
+		return e
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, e := c.http.Do(req)
+	if e != nil {
+		return e
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	b, e := io.ReadAll(res.Body)
+	if e != nil {
+		return e
+	}
+	if res.StatusCode >= http.StatusBadRequest {
+		var msg struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(b, &msg) == nil && msg.Error != "" {
+			return errors.New(msg.Error)
+		}
+		return errors.New(res.Status)
+	}
+	if c.raw {
+		if len(b) > 0 {
+			_, _ = os.Stdout.Write(b)
+		}
+		return nil
+	}
+	if v == nil || len(b) == 0 {
+		return nil
+	}
+	return json.Unmarshal(b, v)
+}
+
+func usage() {
+	_, _ = fmt.Fprintf(os.Stderr, `Usage: %s [options] <command>
+
+Commands:
+  domains list                 Rules of every list and the ones added here
+  domains add <fqdn>...        Track a domain, or announce an address or prefix
+  domains remove <fqdn>...     Stop tracking a domain and withdraw its prefixes
+  cache show [fqdn]            Cache entries, the one of fqdn only when given
+  cache refresh                Resolve every cached name again
+  routes list                  Announced prefixes, their reference counts and holders
+  routes show <prefix>         Holders of an announced address or prefix
+  peers                        BGP peers and their session state
+  reconcile                    Correct the prefixes and the RIB drifting from the cache now
+  reload                       Read the configuration again and apply it
+  why <ip>                     Domains and lists the prefixes covering ip are announced for
+
+Options:
+`, os.Args[0])
+	pflag.PrintDefaults()
+}
+
+func main() {
+	socket := pflag.StringP("socket", "s", defaultSocket, "Path to the control socket of the daemon, Admin.Socket.")
+	base := pflag.StringP("url", "u", "", "URL of the admin API, e.g. http://127.0.0.1:8080, instead of the socket.")
+	timeout := pflag.DurationP("timeout", "t", 30*time.Second, "Time to wait for the daemon.")
+	raw := pflag.BoolP("json", "j", false, "Print the replies of the daemon as JSON.")
+	ver := pflag.BoolP("version", "v", false, "Print the version and exit.")
+	pflag.Usage = usage
+	pflag.Parse()
+
+	if *ver {
+		fmt.Printf("%s (%s) built on %s\n", version, commit, date)
+		return
+	}
+	c := newClient(*socket, *base, *timeout, *raw)
+	if e := run(c, pflag.Args()); e != nil {
+		if errors.Is(e, EUsage) {
+			usage()
+			os.Exit(2)
+		}
+		_, _ = fmt.Fprintf(os.Stderr, "%v\n", e)
+		os.Exit(1)
+	}
+}
+
+func run(c *client, args []string) error {
+	if len(args) == 0 {
+		return EUsage
+	}
+	cmd, sub := args[0], ""
+	if len(args) > 1 {
+		sub = args[1]
+	}
+	switch {
+	case cmd == "domains" && (sub == "list" || sub == ""):
+		return c.domains()
+	case cmd == "domains" && sub == "add" && len(args) > 2:
+		return c.each(http.MethodPost, args[2:], "Added")
+	case cmd == "domains" && sub == "remove" && len(args) > 2:
+		return c.each(http.MethodDelete, args[2:], "Removed")
+	case cmd == "cache" && (sub == "show" || sub == ""):
+		if len(args) > 2 {
+			return c.entry(args[2])
+		}
+		return c.entries()
+	case cmd == "cache" && sub == "refresh":
+		return c.refresh()
+	case cmd == "routes" && (sub == "list" || sub == ""):
+		return c.routes()
+	case cmd == "routes" && sub == "show" && len(args) > 2:
+		return c.route(args[2])
+	case cmd == "peers":
+		return c.peers()
+	case cmd == "reconcile":
+		return c.reconcile()
+	case cmd == "reload":
+		if e := c.call(http.MethodPost, "/reload", nil, nil); e != nil {
+			return e
+		}
+		if !c.raw {
+			fmt.Println("Reloaded")
+		}
+		return nil
+	case cmd == "why" && sub != "":
+		return c.why(sub)
+	}
+	return EUsage
+}
+
+func table() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+}
+
+func join(l []string) string {
+	if len(l) == 0 {
+		return "-"
+	}
+	return strings.Join(l, ",")
+}
+
+func (c *client) domains() error {
+	var ds []domain
+	if e := c.call(http.MethodGet, "/domains", nil, &ds); e != nil || c.raw {
+		return e
+	}
+	t := table()
+	_, _ = fmt.Fprintln(t, "RULE\tKIND\tLISTS\tCOMMUNITIES")
+	for _, d := range ds {
+		_, _ = fmt.Fprintf(t, "%s\t%s\t%s\t%s\n", d.Rule, d.Kind, join(d.Lists), join(d.Communities))
+	}
+	return t.Flush()
+}
+
+// each adds or removes every name, stopping at the first failure.
+func (c *client) each(method string, names []string, done string) error {
+	for _, n := range names {
+		if e := c.call(method, "/domains/"+url.PathEscape(n), nil, nil); e != nil {
+			return fmt.Errorf("%s: %w", n, e)
+		}
+		if !c.raw {
+			fmt.Printf("%s %s\n", done, n)
+		}
+	}
+	return nil
+}
+
+func (c *client) entries() error {
+	var es []entry
+	if e := c.call(http.MethodGet, "/cache", nil, &es); e != nil || c.raw {
+		return e
+	}
+	t := table()
+	_, _ = fmt.Fprintln(t, "FQDN\tIPS\tTTL\tEXPIRES\tRULE\tLISTS")
+	for _, en := range es {
+		_, _ = fmt.Fprintf(t, "%s\t%s\t%d\t%s\t%s\t%s\n", en.Fqdn, join(en.Ips), en.Ttl,
+			en.Expiration.Local().Format(time.RFC3339), en.Rule, join(en.Lists))
+	}
+	return t.Flush()
+}
+
+func (c *client) entry(fqdn string) error {
+	var en entry
+	if e := c.call(http.MethodGet, "/cache/"+url.PathEscape(fqdn), nil, &en); e != nil || c.raw {
+		return e
+	}
+	t := table()
+	_, _ = fmt.Fprintf(t, "Name:\t%s\n", en.Fqdn)
+	_, _ = fmt.Fprintf(t, "Addresses:\t%s\n", join(en.Ips))
+	_, _ = fmt.Fprintf(t, "TTL:\t%d\n", en.Ttl)
+	_, _ = fmt.Fprintf(t, "Expires:\t%s\n", en.Expiration.Local().Format(time.RFC3339))
+	_, _ = fmt.Fprintf(t, "Rule:\t%s\n", en.Rule)
+	_, _ = fmt.Fprintf(t, "Lists:\t%s\n", join(en.Lists))
+	_, _ = fmt.Fprintf(t, "Communities:\t%s\n", join(en.Communities))
+	return t.Flush()
+}
+
+func (c *client) refresh() error {
+	var r struct {
+		Refreshed int `json:"refreshed"`
+	}
+	if e := c.call(http.MethodPost, "/cache/refresh", nil, &r); e != nil || c.raw {
+		return e
+	}
+	fmt.Printf("Resolving %d names again\n", r.Refreshed)
+	return nil
+}
+
+func (c *client) routes() error {
+	var rs []route
+	if e := c.call(http.MethodGet, "/routes", nil, &rs); e != nil || c.raw {
+		return e
+	}
+	t := table()
+	_, _ = fmt.Fprintln(t, "PREFIX\tREFS\tHELD BY\tCOMMUNITIES")
+	for _, r := range rs {
+		names := make([]string, 0, len(r.Holders))
+		for _, h := range r.Holders {
+			names = append(names, h.Name)
+		}
+		_, _ = fmt.Fprintf(t, "%s\t%d\t%s\t%s\n", r.Prefix, r.Refs, join(names), join(r.Communities))
+	}
+	return t.Flush()
+}
+
+func (c *client) route(prefix string) error {
+	var r route
+	if e := c.call(http.MethodGet, "/routes/"+url.PathEscape(prefix), nil, &r); e != nil || c.raw {
+		return e
+	}
+	fmt.Printf("%s: %d holds, communities %s\n", r.Prefix, r.Refs, join(r.Communities))
+	return holders([]route{r})
+}
+
+func (c *client) peers() error {
+	var ps []peer
+	if e := c.call(http.MethodGet, "/peers", nil, &ps); e != nil || c.raw {
+		return e
+	}
+	t := table()
+	_, _ = fmt.Fprintln(t, "PEER\tASN\tSTATE\tFOR\tADVERTISED")
+	for _, p := range ps {
+		since := "never"
+		if !p.Since.IsZero() {
+			since = time.Since(p.Since).Truncate(time.Second).String()
+		}
+		_, _ = fmt.Fprintf(t, "%s\t%d\t%s\t%s\t%d\n", p.Address, p.Asn, p.State, since, p.Advertised)
+	}
+	return t.Flush()
+}
+
+func (c *client) reconcile() error {
+	var r corrections
+	if e := c.call(http.MethodPost, "/reconcile", nil, &r); e != nil || c.raw {
+		return e
+	}
+	if len(r.Reindexed)+len(r.Announced)+len(r.Updated)+len(r.Removed) == 0 {
+		fmt.Println("Nothing to correct")
+		return nil
+	}
+	t := table()
+	_, _ = fmt.Fprintln(t, "PREFIX\tCORRECTION")
+	for _, l := range []struct {
+		prefixes []string
+		what     string
+	}{
+		{r.Reindexed, "holders set from the cache"},
+		{r.Announced, "announced again"},
+		{r.Updated, "communities updated"},
+		{r.Removed, "removed"},
+	} {
+		for _, p := range l.prefixes {
+			_, _ = fmt.Fprintf(t, "%s\t%s\n", p, l.what)
+		}
+	}
+	return t.Flush()
+}
+
+func (c *client) why(ip string) error {
+	var w why
+	if e := c.call(http.MethodGet, "/why/"+url.PathEscape(ip), nil, &w); e != nil || c.raw {
+		return e
+	}
+	if len(w.Routes) == 0 {
+		fmt.Printf("%s is not announced\n", w.Ip)
+		return nil
+	}
+	return holders(w.Routes)
+}
+
+// holders prints a line for every holder of the routes.
+func holders(rs []route) error {
+	t := table()
+	_, _ = fmt.Fprintln(t, "PREFIX\tREFS\tHELD BY\tRULE\tLISTS")
+	for _, r := range rs {
+		if len(r.Holders) == 0 {
+			_, _ = fmt.Fprintf(t, "%s\t%d\t-\t-\t-\n", r.Prefix, r.Refs)
+		}
+		for _, h := range r.Holders {
+			_, _ = fmt.Fprintf(t, "%s\t%d\t%s\t%s\t%s\n", r.Prefix, r.Refs, h.Name, h.Rule, join(h.Lists))
+		}
+	}
+	return t.Flush()
+}
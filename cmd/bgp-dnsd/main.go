@@ -3,16 +3,16 @@ import (
     "context"
     "errors"
     "fmt"
-    "github.com/red55/bgp-dns/internal/bgp"
     "github.com/red55/bgp-dns/internal/config"
-    "github.com/red55/bgp-dns/internal/dns"
     "github.com/red55/bgp-dns/internal/fswatcher"
     "github.com/red55/bgp-dns/internal/log"
+    "github.com/red55/bgp-dns/pkg/bgpdns"
     "github.com/rs/zerolog"
     "github.com/spf13/pflag"
     "os"
     "os/signal"
     "path/filepath"
+    "syscall"
 )
 type app struct {
     log.Log
@@ -27,8 +27,8 @@ var (
 
 func (a *app) stdErr(e error, s string,  v ...interface{}) {
     s = fmt.Sprintf(s, v...)
-    a.L().Error().Err(e).Msgf(s, v...)
-    if a.L().GetLevel() > zerolog.ErrorLevel {
+    a.L().Error().Err(e).Msg(s)
+    if a.Level() > zerolog.ErrorLevel {
         _, _ = fmt.Fprintf(os.Stderr, "%s - %v\n", s, e)
     }
 
@@ -36,14 +36,53 @@ func (a *app) stdErr(e error, s string,  v ...interface{}) {
 
 func (a *app) stdOut(s string,  v ...interface{}) {
     s = fmt.Sprintf(s, v...)
-    a.L().Info().Msgf(s, v...)
-    if a.L().GetLevel() > zerolog.InfoLevel {
+    a.L().Info().Msg(s)
+    if a.Level() > zerolog.InfoLevel {
         _, _ = fmt.Fprintf(os.Stdout, "%s\n", s)
     }
 }
 
+// configLoader reloads the configuration file into the daemon when it changes or on SIGHUP. A file which
+// does not parse or validate leaves the running configuration in place.
+type configLoader struct {
+    d    *bgpdns.Daemon
+    logs *log.Logs
+}
+
+func (l *configLoader) Load(fn string) error {
+    cfg, e := config.InitWith(fn, pflag.CommandLine)
+    if e != nil {
+        return e
+    }
+    if e = l.d.Reload(cfg); errors.Is(e, bgpdns.EInvalidConfig) {
+        return e
+    }
+    if err := log.Init(cfg); err != nil {
+        _app.stdErr(err, "Log outputs of %s not applied", fn)
+    }
+    l.logs.Configure(&cfg.Log)
+    _app.stdOut("Reloaded %s", fn)
+    return e
+}
+
+// checkConfig reports every problem of the configuration file fn on stderr and returns the exit code.
+func checkConfig(fn string) int {
+    cfg, e := config.InitWith(fn, pflag.CommandLine)
+    if e == nil {
+        e = cfg.Validate()
+    }
+    if e != nil {
+        _, _ = fmt.Fprintf(os.Stderr, "%s is invalid:\n%v\n", fn, e)
+        return 1
+    }
+    _, _ = fmt.Fprintf(os.Stdout, "%s is valid\n", fn)
+    return 0
+}
+
 func main() {
     pflag.StringP("config", "c", "appsettings.yml", "Path to configuration file.")
+    config.Flags(pflag.CommandLine)
+    pflag.Bool("check-config", false, "Validate the configuration file and exit, non-zero when it is invalid.")
     pflag.Parse()
 
     fn := pflag.Lookup("config")
@@ -51,14 +90,21 @@ func main() {
     if e != nil {
         panic(errors.New("wrong path to configuration file"))
     }
+    if check, _ := pflag.CommandLine.GetBool("check-config"); check {
+        os.Exit(checkConfig(configPath))
+    }
     var cfg *config.AppCfg
-    if cfg, e = config.Init(configPath); e != nil {
+    if cfg, e = config.InitWith(configPath, pflag.CommandLine); e != nil {
         panic(e)
     }
 
-    log.Init(cfg)
+    if e = log.Init(cfg); e != nil {
+        panic(e)
+    }
+    logs := log.NewLogs(log.L())
+    logs.Configure(&cfg.Log)
     _app = &app{
-        Log: log.NewLog(log.L(), ""),
+        Log: logs.NewLog("main"),
     }
 
     _app.stdOut("Starting up %s (%s) built on %s...", version, commit, date )
@@ -66,53 +112,70 @@ func main() {
         _app.stdOut("Shutdown complete.")
     }()
 
-    ctx := context.Background()
-    ctx = context.WithValue(ctx, "cfg", cfg)
-    ctx, cancel := context.WithCancel(ctx)
+    ctx, cancel := context.WithCancel(context.Background())
     defer cancel()
 
     c := make (chan os.Signal, 1)
-    signal.Notify(c, os.Interrupt)
+    signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+    hup := make(chan os.Signal, 1)
+    signal.Notify(hup, syscall.SIGHUP)
+    usr := make(chan os.Signal, 1)
+    if traceSignal != nil {
+        signal.Notify(usr, traceSignal, resetSignal)
+    }
 
-    if e = bgp.Serve(ctx); e != nil {
+    var d *bgpdns.Daemon
+    if d, e = bgpdns.New(cfg); e != nil {
         panic(e)
     }
-    defer func() {
-        if e = bgp.Shutdown(ctx); e != nil {
-            _app.stdErr(e, "BGP Shutdown failed ")
-        }
-    }()
-
-    if e = dns.Serve(ctx); e != nil {
+    d.SetLogger(*log.L())
+    if e = d.Start(ctx); e != nil {
         panic(e)
     }
     defer func() {
-        if e = dns.Shutdown(ctx); e != nil {
-            _app.stdErr(e, "DNS Shutdown failed ")
+        if e = d.Stop(ctx); e != nil {
+            _app.stdErr(e, "Shutdown failed ")
         }
     }()
 
-    if e = dns.Load(cfg.Dns.List.File); e != nil {
-        panic(e)
-    }
-
-    if e = fswatcher.Serve(ctx); e != nil {
-        panic(e)
+    loader := &configLoader{d: d, logs: logs}
+    d.OnReload(func() error {
+        return loader.Load(configPath)
+    })
+    w := fswatcher.New([]string{configPath}, loader, logs)
+    if e = w.Serve(ctx); e != nil {
+        _app.stdErr(e, "Not watching %s", configPath)
+    } else {
+        defer func() {
+            _ = w.Shutdown(ctx)
+        }()
     }
-    defer func() {
-        if e = fswatcher.Shutdown(ctx); e != nil {
-            _app.stdErr(e, "FSWatcher Shutdown failed ")
-        }
-    }()
 
     _app.stdOut("Startup complete.")
-    select {
-    case <-c :
-        _app.stdOut("Gracefully shutting down...")
-        break
-    case <-ctx.Done():
-        if ctx.Err() != nil {
-            _app.stdErr(e, "Ctrl+C failed ")
+    for {
+        select {
+        case <-c :
+            _app.stdOut("Gracefully shutting down...")
+            return
+        case sig := <-usr:
+            if sig == traceSignal {
+                logs.SetLevels(zerolog.TraceLevel)
+                d.SetLogLevels(zerolog.TraceLevel)
+                _app.stdOut("Tracing every module")
+            } else {
+                logs.ResetLevels()
+                d.ResetLogLevels()
+                _app.stdOut("Logging at the configured levels")
+            }
+        case <-hup:
+            if e = loader.Load(configPath); e != nil {
+                _app.stdErr(e, "Failed to reload %s", configPath)
+            }
+        case <-ctx.Done():
+            if ctx.Err() != nil {
+                _app.stdErr(e, "Ctrl+C failed ")
+            }
+            return
         }
     }
 }
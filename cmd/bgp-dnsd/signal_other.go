@@ -0,0 +1,13 @@
+//go:build windows || plan9
+
+package main
+
+import (
+    "os"
+)
+
+// there are no user signals, levels change through the admin API only
+var (
+    traceSignal os.Signal
+    resetSignal os.Signal
+)
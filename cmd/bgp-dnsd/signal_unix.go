@@ -0,0 +1,14 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+    "os"
+    "syscall"
+)
+
+// traceSignal makes every module trace, resetSignal gives them back their configured levels.
+var (
+    traceSignal os.Signal = syscall.SIGUSR1
+    resetSignal os.Signal = syscall.SIGUSR2
+)
@@ -0,0 +1,16 @@
+//go:build !windows && !plan9
+
+package admin
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenPrivate listens on the socket at path created with mode 0660. The umask is the process one, files
+// created meanwhile by other goroutines get no more than 0660 either.
+func listenPrivate(path string) (net.Listener, error) {
+	old := syscall.Umask(0o117)
+	defer syscall.Umask(old)
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,12 @@
+//go:build windows || plan9
+
+package admin
+
+import (
+	"net"
+)
+
+// listenPrivate listens on the socket at path, which is only guarded by the ACL of its directory here.
+func listenPrivate(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
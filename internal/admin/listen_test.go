@@ -0,0 +1,44 @@
+//go:build !windows && !plan9
+
+package admin
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bgp-dnsd.sock")
+	l, e := listenUnix(path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	inf, e := os.Stat(path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if m := inf.Mode().Perm(); m != 0o660 {
+		t.Errorf("socket created with mode %o, want 660", m)
+	}
+
+	// the socket of a running daemon is left alone
+	if _, e = listenUnix(path); !errors.Is(e, ESocketInUse) {
+		t.Fatalf("listening on a socket in use gave %v", e)
+	}
+	if c, e := net.Dial("unix", path); e != nil {
+		t.Fatalf("the socket in use was replaced: %v", e)
+	} else {
+		_ = c.Close()
+	}
+
+	// a stale one is replaced
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = l.Close()
+	if l, e = listenUnix(path); e != nil {
+		t.Fatalf("a stale socket was not replaced: %v", e)
+	}
+	_ = l.Close()
+}
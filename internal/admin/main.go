@@ -0,0 +1,452 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/red55/bgp-dns/internal/bgp"
+	"github.com/red55/bgp-dns/internal/config"
+	"github.com/red55/bgp-dns/internal/dns"
+	"github.com/red55/bgp-dns/internal/log"
+	"github.com/red55/bgp-dns/internal/metrics"
+	"github.com/red55/bgp-dns/internal/reconciler"
+	"github.com/red55/bgp-dns/internal/utils"
+	"github.com/rs/zerolog"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Server is the HTTP admin API used to inspect and change the tracked domains at runtime. It is served on
+// the TCP address and the unix socket of the configuration, bgp-dnsctl talks to the latter.
+type Server interface {
+	Serve(ctx context.Context) error
+	Shutdown(ctx context.Context) error
+	// SetReload sets what POST /reload runs, the API answers 501 without it
+	SetReload(f func() error)
+}
+
+type adminSrv struct {
+	log.Log
+	addr   *net.TCPAddr
+	socket string
+	dns    dns.Service
+	bgp    bgp.Speaker
+	rc     reconciler.Reconciler
+	logs   *log.Logs
+	mux    *http.ServeMux
+	srv    *http.Server
+	wg     sync.WaitGroup
+	m      sync.Mutex
+	reload func() error
+}
+
+var (
+	EInvalidLevel = errors.New("invalid log level")
+	EInvalidIP    = errors.New("invalid IP address")
+	ENoReload     = errors.New("reload is not supported")
+	ESocketInUse  = errors.New("control socket is in use")
+)
+
+type route struct {
+	Prefix      string   `json:"prefix"`
+	Refs        uint64   `json:"refs"`
+	Holders     []holder `json:"holders"`
+	Communities []string `json:"communities"`
+}
+
+// holder is a cache entry or a static rule holding an announced prefix, with the rule and the lists it comes
+// from. A restored entry no list has taken over yet has neither.
+type holder struct {
+	Name  string   `json:"name"`
+	Rule  string   `json:"rule"`
+	Lists []string `json:"lists"`
+}
+
+// why explains the announcement of IP: the announced prefixes covering it and what holds them.
+type why struct {
+	Ip     string  `json:"ip"`
+	Routes []route `json:"routes"`
+}
+
+func New(cfg *config.AdminCfg, d dns.Service, b bgp.Speaker, rc reconciler.Reconciler, m *metrics.Metrics,
+	logs *log.Logs) Server {
+	s := &adminSrv{
+		Log:    logs.NewLog("admin"),
+		addr:   cfg.Listen,
+		socket: cfg.Socket,
+		dns:    d,
+		bgp:    b,
+		rc:     rc,
+		logs:   logs,
+		mux:    http.NewServeMux(),
+	}
+	s.mux.HandleFunc("GET /cache", s.listCache)
+	s.mux.HandleFunc("GET /cache/{fqdn...}", s.showCache)
+	s.mux.HandleFunc("POST /cache/refresh", s.refreshCache)
+	s.mux.HandleFunc("GET /domains", s.listDomains)
+	s.mux.HandleFunc("POST /domains/{fqdn...}", s.register)
+	s.mux.HandleFunc("DELETE /domains/{fqdn...}", s.unregister)
+	s.mux.HandleFunc("GET /routes", s.listRoutes)
+	s.mux.HandleFunc("GET /routes/{prefix...}", s.showRoute)
+	s.mux.HandleFunc("GET /peers", s.listPeers)
+	s.mux.HandleFunc("POST /reconcile", s.reconcile)
+	s.mux.HandleFunc("POST /reload", s.reloadConfig)
+	s.mux.HandleFunc("GET /why/{ip}", s.explain)
+	s.mux.Handle("GET /metrics", m.Handler())
+	s.mux.HandleFunc("GET /log/levels", s.listLevels)
+	s.mux.HandleFunc("PUT /log/levels/{module}", s.setLevel)
+	s.mux.HandleFunc("DELETE /log/levels/{module}", s.resetLevel)
+
+	return s
+}
+
+func (s *adminSrv) Serve(ctx context.Context) error {
+	var ls []net.Listener
+	if s.addr != nil {
+		l, e := net.Listen("tcp", s.addr.String())
+		if e != nil {
+			return fmt.Errorf("failed to bind admin API: %w", e)
+		}
+		ls = append(ls, l)
+	}
+	if s.socket != "" {
+		l, e := listenUnix(s.socket)
+		if e != nil {
+			for _, l := range ls {
+				_ = l.Close()
+			}
+			return fmt.Errorf("failed to bind admin API: %w", e)
+		}
+		ls = append(ls, l)
+	}
+	s.srv = &http.Server{
+		Handler:           s.mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
+	}
+
+	for _, l := range ls {
+		s.wg.Add(1)
+		go func(l net.Listener) {
+			defer s.wg.Done()
+			if e := s.srv.Serve(l); e != nil && !errors.Is(e, http.ErrServerClosed) {
+				s.L().Error().Err(e).Msg("Admin API stopped")
+			}
+		}(l)
+		s.L().Info().Msgf("Admin API is listening on %s", l.Addr().String())
+	}
+
+	return nil
+}
+
+// listenUnix binds the control socket at path, replacing the one a previous run left behind but not the one of
+// a daemon still running. Only the owner and the group of the process may connect.
+func listenUnix(path string) (net.Listener, error) {
+	if inf, e := os.Lstat(path); e == nil && inf.Mode()&os.ModeSocket != 0 {
+		if c, e := net.DialTimeout("unix", path, time.Second); e == nil {
+			_ = c.Close()
+			return nil, fmt.Errorf("%w: %s", ESocketInUse, path)
+		}
+		_ = os.Remove(path)
+	}
+	return listenPrivate(path)
+}
+
+func (s *adminSrv) SetReload(f func() error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.reload = f
+}
+
+func (s *adminSrv) Shutdown(ctx context.Context) error {
+	if s.srv == nil {
+		return nil
+	}
+	e := s.srv.Shutdown(ctx)
+	s.wg.Wait()
+	s.srv = nil
+
+	return e
+}
+
+func (s *adminSrv) reply(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if v == nil {
+		return
+	}
+	if e := json.NewEncoder(w).Encode(v); e != nil {
+		s.L().Error().Err(e).Msg("Failed to write response")
+	}
+}
+
+func (s *adminSrv) fail(w http.ResponseWriter, e error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(e, dns.EInvalidFQDN):
+		status = http.StatusBadRequest
+	case errors.Is(e, dns.ENotInitialized):
+		status = http.StatusServiceUnavailable
+	case errors.Is(e, log.EUnknownModule):
+		status = http.StatusNotFound
+	case errors.Is(e, EInvalidLevel), errors.Is(e, EInvalidIP):
+		status = http.StatusBadRequest
+	case errors.Is(e, bgp.EInvalidPrefix):
+		status = http.StatusBadRequest
+	case errors.Is(e, dns.ENotCached), errors.Is(e, bgp.ENotAnnounced):
+		status = http.StatusNotFound
+	case errors.Is(e, ENoReload):
+		status = http.StatusNotImplemented
+	}
+	s.reply(w, status, map[string]string{"error": e.Error()})
+}
+
+func (s *adminSrv) listCache(w http.ResponseWriter, _ *http.Request) {
+	if entries, e := s.dns.Entries(); e != nil {
+		s.fail(w, e)
+	} else {
+		s.reply(w, http.StatusOK, entries)
+	}
+}
+
+func (s *adminSrv) showCache(w http.ResponseWriter, r *http.Request) {
+	if en, e := s.dns.Entry(r.PathValue("fqdn")); e != nil {
+		s.fail(w, e)
+	} else {
+		s.reply(w, http.StatusOK, en)
+	}
+}
+
+// refreshCache resolves every cached name again, the reply tells how many there were.
+func (s *adminSrv) refreshCache(w http.ResponseWriter, r *http.Request) {
+	s.L().Info().Msgf("Refreshing the cache from %s", from(r))
+	if n, e := s.dns.Refresh(); e != nil {
+		s.fail(w, e)
+	} else {
+		s.reply(w, http.StatusOK, map[string]int{"refreshed": n})
+	}
+}
+
+func (s *adminSrv) listDomains(w http.ResponseWriter, _ *http.Request) {
+	if domains, e := s.dns.Domains(); e != nil {
+		s.fail(w, e)
+	} else {
+		s.reply(w, http.StatusOK, domains)
+	}
+}
+
+func (s *adminSrv) register(w http.ResponseWriter, r *http.Request) {
+	fqdn := r.PathValue("fqdn")
+	s.L().Info().Msgf("Registering %s from %s", fqdn, from(r))
+	if e := s.dns.Register(fqdn); e != nil {
+		s.fail(w, e)
+	} else {
+		s.reply(w, http.StatusNoContent, nil)
+	}
+}
+
+func (s *adminSrv) unregister(w http.ResponseWriter, r *http.Request) {
+	fqdn := r.PathValue("fqdn")
+	s.L().Info().Msgf("Unregistering %s from %s", fqdn, from(r))
+	if e := s.dns.Unregister(fqdn); e != nil {
+		s.fail(w, e)
+	} else {
+		s.reply(w, http.StatusNoContent, nil)
+	}
+}
+
+func (s *adminSrv) listRoutes(w http.ResponseWriter, _ *http.Request) {
+	if routes, e := s.routes(); e != nil {
+		s.fail(w, e)
+	} else {
+		s.reply(w, http.StatusOK, routes)
+	}
+}
+
+func (s *adminSrv) showRoute(w http.ResponseWriter, r *http.Request) {
+	rt, e := s.bgp.Route(r.PathValue("prefix"))
+	if e != nil {
+		s.fail(w, e)
+		return
+	}
+	holders, e := s.holders()
+	if e != nil {
+		s.fail(w, e)
+		return
+	}
+	s.reply(w, http.StatusOK, holders.route(rt))
+}
+
+// routes returns the announced prefixes ordered by prefix, each with the cache entries and static rules
+// holding it.
+func (s *adminSrv) routes() ([]route, error) {
+	rts, e := s.bgp.Routes()
+	if e != nil {
+		return nil, e
+	}
+	holders, e := s.holders()
+	if e != nil {
+		return nil, e
+	}
+	routes := make([]route, 0, len(rts))
+	for _, rt := range rts {
+		routes = append(routes, holders.route(rt))
+	}
+	return routes, nil
+}
+
+// holders are the cache entries and the rules by the name they hold prefixes with.
+type holders map[string]holder
+
+func (s *adminSrv) holders() (holders, error) {
+	entries, e := s.dns.Entries()
+	if e != nil {
+		return nil, e
+	}
+	domains, e := s.dns.Domains()
+	if e != nil {
+		return nil, e
+	}
+	r := make(holders, len(entries)+len(domains))
+	for _, d := range domains {
+		r[d.Rule] = holder{Name: d.Rule, Rule: d.Rule, Lists: d.Lists}
+	}
+	for _, en := range entries {
+		r[en.Fqdn] = holder{Name: en.Fqdn, Rule: en.Rule, Lists: en.Lists}
+	}
+	return r, nil
+}
+
+// route adds the rules and the lists of the holders of rt.
+func (h holders) route(rt bgp.Route) route {
+	r := route{Prefix: rt.Prefix, Refs: rt.Refs, Communities: rt.Communities, Holders: make([]holder, 0, len(rt.Holders))}
+	for _, n := range rt.Holders {
+		if x, ok := h[n]; ok {
+			r.Holders = append(r.Holders, x)
+		} else {
+			r.Holders = append(r.Holders, holder{Name: n})
+		}
+	}
+	return r
+}
+
+func (s *adminSrv) listPeers(w http.ResponseWriter, r *http.Request) {
+	if peers, e := s.bgp.Peers(r.Context()); e != nil {
+		s.fail(w, e)
+	} else {
+		s.reply(w, http.StatusOK, peers)
+	}
+}
+
+// reloadConfig reads the configuration again and applies it, as SIGHUP does.
+func (s *adminSrv) reloadConfig(w http.ResponseWriter, r *http.Request) {
+	s.m.Lock()
+	f := s.reload
+	s.m.Unlock()
+	if f == nil {
+		s.fail(w, ENoReload)
+		return
+	}
+	s.L().Info().Msgf("Reloading the configuration from %s", from(r))
+	if e := f(); e != nil {
+		s.fail(w, e)
+	} else {
+		s.reply(w, http.StatusNoContent, nil)
+	}
+}
+
+// reconcile corrects the held prefixes and the RIB which drift from the cache, the reply tells what was corrected.
+func (s *adminSrv) reconcile(w http.ResponseWriter, r *http.Request) {
+	s.L().Info().Msgf("Reconciling the RIB with the cache from %s", from(r))
+	if c, e := s.rc.Reconcile(r.Context()); e != nil {
+		s.fail(w, e)
+	} else {
+		s.reply(w, http.StatusOK, c)
+	}
+}
+
+// explain returns the announced prefixes covering the IP with what holds them, host routes first.
+func (s *adminSrv) explain(w http.ResponseWriter, r *http.Request) {
+	a, e := netip.ParseAddr(r.PathValue("ip"))
+	if e != nil {
+		s.fail(w, fmt.Errorf("%w: %q", EInvalidIP, r.PathValue("ip")))
+		return
+	}
+	a = a.Unmap()
+	routes, e := s.routes()
+	if e != nil {
+		s.fail(w, e)
+		return
+	}
+	res := why{Ip: a.String(), Routes: make([]route, 0)}
+	for _, rt := range routes {
+		if p, _, err := utils.ParsePrefix(rt.Prefix); err == nil && p.Contains(a) {
+			res.Routes = append(res.Routes, rt)
+		}
+	}
+	sort.SliceStable(res.Routes, func(i, j int) bool {
+		pi, _, _ := utils.ParsePrefix(res.Routes[i].Prefix)
+		pj, _, _ := utils.ParsePrefix(res.Routes[j].Prefix)
+		return pi.Bits() > pj.Bits()
+	})
+	s.reply(w, http.StatusOK, res)
+}
+
+// from names the client of r, requests over the control socket have no address.
+func from(r *http.Request) string {
+	if r.RemoteAddr == "" || r.RemoteAddr == "@" {
+		return "the control socket"
+	}
+	return r.RemoteAddr
+}
+
+func (s *adminSrv) listLevels(w http.ResponseWriter, _ *http.Request) {
+	levels := make(map[string]string)
+	for m, lvl := range s.logs.Levels() {
+		levels[m] = lvl.String()
+	}
+	s.reply(w, http.StatusOK, levels)
+}
+
+// setLevel makes a module log at the level in the body, {"level": "trace"}, until it is reset or the
+// configuration is reloaded.
+func (s *adminSrv) setLevel(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Level string `json:"level"`
+	}
+	if e := json.NewDecoder(r.Body).Decode(&body); e != nil {
+		s.fail(w, fmt.Errorf("%w: %w", EInvalidLevel, e))
+		return
+	}
+	lvl, e := zerolog.ParseLevel(body.Level)
+	if e != nil || body.Level == "" {
+		s.fail(w, fmt.Errorf("%w: %q", EInvalidLevel, body.Level))
+		return
+	}
+	module := r.PathValue("module")
+	if e = s.logs.SetModuleLevel(module, lvl); e != nil {
+		s.fail(w, e)
+		return
+	}
+	s.L().Info().Msgf("Module %s logs at %s, set from %s", module, lvl, from(r))
+	s.reply(w, http.StatusNoContent, nil)
+}
+
+func (s *adminSrv) resetLevel(w http.ResponseWriter, r *http.Request) {
+	module := r.PathValue("module")
+	if e := s.logs.ResetModuleLevel(module); e != nil {
+		s.fail(w, e)
+		return
+	}
+	s.L().Info().Msgf("Module %s logs at its configured level again, reset from %s", module, from(r))
+	s.reply(w, http.StatusNoContent, nil)
+}
@@ -0,0 +1,168 @@
+package bgp
+
+import (
+	"math"
+	"net/netip"
+	"slices"
+
+	"github.com/red55/bgp-dns/internal/config"
+	"github.com/red55/bgp-dns/internal/utils"
+)
+
+const (
+	defaultMinPrefixLen  = 24
+	defaultMinPrefixLen6 = 64
+	defaultDensity       = 0.5
+)
+
+// aggregator collapses host routes into the shortest covering prefixes dense enough to be announced instead.
+// Static prefixes are announced as they are.
+type aggregator struct {
+	min4    int
+	min6    int
+	density float64
+}
+
+// newAggregator returns nil when aggregation is not configured.
+func newAggregator(c *config.AggregationCfg) *aggregator {
+	if c == nil {
+		return nil
+	}
+	a := &aggregator{
+		min4:    c.MinPrefixLen,
+		min6:    c.MinPrefixLen6,
+		density: c.Density,
+	}
+	if a.min4 <= 0 || a.min4 > 32 {
+		a.min4 = defaultMinPrefixLen
+	}
+	if a.min6 <= 0 || a.min6 > 128 {
+		a.min6 = defaultMinPrefixLen6
+	}
+	if a.density <= 0 || a.density > 1 {
+		a.density = defaultDensity
+	}
+	return a
+}
+
+// aggregate returns the prefixes to export for the reference counted keys together with the keys each of
+// them covers. Host routes of a prefix chosen for aggregation are left out, chosen prefixes never overlap
+// each other.
+func (a *aggregator) aggregate(keys []string) map[netip.Prefix][]string {
+	r := make(map[netip.Prefix][]string, len(keys))
+	var v4, v6 []netip.Addr
+	for _, k := range keys {
+		p, _, e := utils.ParsePrefix(k)
+		if e != nil {
+			continue
+		}
+		switch {
+		case !p.IsSingleIP():
+			r[p] = append(r[p], k)
+		case p.Addr().Is4():
+			v4 = append(v4, p.Addr())
+		default:
+			v6 = append(v6, p.Addr())
+		}
+	}
+	a.collapse(v4, a.min4, r)
+	a.collapse(v6, a.min6, r)
+	return r
+}
+
+// collapse aggregates host addresses of one family into r. For every address the shortest prefix of at least
+// min bits holding two or more addresses at the configured density is chosen; the addresses it covers follow
+// the address in sorted order and are skipped.
+func (a *aggregator) collapse(hosts []netip.Addr, min int, r map[netip.Prefix][]string) {
+	slices.SortFunc(hosts, func(x, y netip.Addr) int {
+		return x.Compare(y)
+	})
+	hosts = slices.Compact(hosts)
+
+	for i := 0; i < len(hosts); {
+		h := hosts[i]
+		bits := h.BitLen()
+		chosen := netip.PrefixFrom(h, bits)
+		n := 1
+		for l := min; l < bits; l++ {
+			if bits-l >= 64 {
+				// far too sparse for any density
+				continue
+			}
+			p, _ := h.Prefix(l)
+			c := countIn(hosts[i:], p)
+			if c > 1 && float64(c)/math.Ldexp(1, bits-l) >= a.density {
+				chosen, n = p, c
+				break
+			}
+		}
+		for _, m := range hosts[i : i+n] {
+			r[chosen] = append(r[chosen], m.String())
+		}
+		i += n
+	}
+}
+
+// countIn counts the leading addresses of the sorted hosts which p contains.
+func countIn(hosts []netip.Addr, p netip.Prefix) int {
+	n := 0
+	for n < len(hosts) && p.Contains(hosts[n]) {
+		n++
+	}
+	return n
+}
+
+// exportedRoute is a prefix put into the RIB by reaggregate with the communities it carries.
+type exportedRoute struct {
+	prefix      netip.Prefix
+	communities []string
+}
+
+// reaggregate brings the RIB in line with the aggregated reference counted prefixes, an aggregate carries the
+// union of the communities of its members. New and changed prefixes are announced before the ones they
+// replace are removed, so a merge or a split never leaves a gap. Runs on the loop.
+func (s *bgpSrv) reaggregate() (e error) {
+	keys := make([]string, 0, len(s.index))
+	for k := range s.index {
+		keys = append(keys, k)
+	}
+	want := make(map[string]*exportedRoute, len(keys))
+	for p, members := range s.agg.aggregate(keys) {
+		r := &exportedRoute{prefix: p}
+		for _, m := range members {
+			r.communities = mergeCommunities(r.communities, s.index[m].union())
+		}
+		want[p.String()] = r
+	}
+
+	for k, r := range want {
+		if x, ok := s.exported[k]; ok && slices.Equal(x.communities, r.communities) {
+			continue
+		}
+		prefix, family, _, err := parsePrefix(r.prefix.String())
+		if err == nil {
+			err = s.add(prefix, family, s.asn, r.communities)
+		}
+		if err != nil {
+			e = err
+			continue
+		}
+		s.exported[k] = r
+	}
+	for k, r := range s.exported {
+		if _, ok := want[k]; ok {
+			continue
+		}
+		prefix, family, _, err := parsePrefix(r.prefix.String())
+		if err == nil {
+			err = s.remove(prefix, family, s.asn)
+		}
+		if err != nil {
+			s.L().Error().Err(err).Msgf("Failed to remove %s", k)
+		}
+		delete(s.exported, k)
+	}
+	s.metrics.Prefixes.Set(float64(len(s.exported)))
+
+	return
+}
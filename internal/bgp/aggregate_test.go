@@ -0,0 +1,113 @@
+package bgp
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/red55/bgp-dns/internal/config"
+)
+
+// aggregated renders what aggregate chose, the members of every prefix sorted.
+func aggregated(a *aggregator, keys ...string) map[string][]string {
+	r := make(map[string][]string)
+	for p, members := range a.aggregate(keys) {
+		slices.Sort(members)
+		r[p.String()] = members
+	}
+	return r
+}
+
+func TestAggregate(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.AggregationCfg
+		keys []string
+		want map[string][]string
+	}{
+		{"single host", config.AggregationCfg{}, []string{"192.0.2.1"},
+			map[string][]string{"192.0.2.1/32": {"192.0.2.1"}}},
+		{"adjacent hosts", config.AggregationCfg{Density: 1}, []string{"192.0.2.1", "192.0.2.0"},
+			map[string][]string{"192.0.2.0/31": {"192.0.2.0", "192.0.2.1"}}},
+		{"adjacent hosts at half density", config.AggregationCfg{}, []string{"192.0.2.1", "192.0.2.0"},
+			map[string][]string{"192.0.2.0/30": {"192.0.2.0", "192.0.2.1"}}},
+		{"adjacent across a boundary", config.AggregationCfg{}, []string{"192.0.2.1", "192.0.2.2"},
+			map[string][]string{"192.0.2.0/30": {"192.0.2.1", "192.0.2.2"}}},
+		{"density exactly met", config.AggregationCfg{Density: 0.5}, []string{"192.0.2.0", "192.0.2.3"},
+			map[string][]string{"192.0.2.0/30": {"192.0.2.0", "192.0.2.3"}}},
+		{"density just missed", config.AggregationCfg{Density: 0.51}, []string{"192.0.2.0", "192.0.2.3"},
+			map[string][]string{"192.0.2.0/32": {"192.0.2.0"}, "192.0.2.3/32": {"192.0.2.3"}}},
+		{"full density", config.AggregationCfg{Density: 1}, []string{"192.0.2.0", "192.0.2.1", "192.0.2.2"},
+			map[string][]string{"192.0.2.0/31": {"192.0.2.0", "192.0.2.1"}, "192.0.2.2/32": {"192.0.2.2"}}},
+		{"shortest prefix at MinPrefixLen", config.AggregationCfg{MinPrefixLen: 30},
+			[]string{"192.0.2.0", "192.0.2.1", "192.0.2.2", "192.0.2.3"},
+			map[string][]string{"192.0.2.0/30": {"192.0.2.0", "192.0.2.1", "192.0.2.2", "192.0.2.3"}}},
+		{"no shorter than MinPrefixLen", config.AggregationCfg{MinPrefixLen: 31},
+			[]string{"192.0.2.0", "192.0.2.1", "192.0.2.2", "192.0.2.3"},
+			map[string][]string{"192.0.2.0/31": {"192.0.2.0", "192.0.2.1"},
+				"192.0.2.2/31": {"192.0.2.2", "192.0.2.3"}}},
+		{"dense block and a straggler", config.AggregationCfg{},
+			[]string{"192.0.2.0", "192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.8"},
+			map[string][]string{"192.0.2.0/29": {"192.0.2.0", "192.0.2.1", "192.0.2.2", "192.0.2.3"},
+				"192.0.2.8/32": {"192.0.2.8"}}},
+		{"blocks in distant networks", config.AggregationCfg{},
+			[]string{"192.0.2.0", "192.0.2.1", "198.51.100.4", "198.51.100.5"},
+			map[string][]string{"192.0.2.0/30": {"192.0.2.0", "192.0.2.1"},
+				"198.51.100.4/30": {"198.51.100.4", "198.51.100.5"}}},
+		{"host inside a static prefix", config.AggregationCfg{}, []string{"192.0.2.0/24", "192.0.2.1"},
+			map[string][]string{"192.0.2.0/24": {"192.0.2.0/24"}, "192.0.2.1/32": {"192.0.2.1"}}},
+		{"mixed families", config.AggregationCfg{},
+			[]string{"192.0.2.0", "192.0.2.1", "2001:db8::1", "2001:db8::2", "2001:db8:1::1"},
+			map[string][]string{"192.0.2.0/30": {"192.0.2.0", "192.0.2.1"},
+				"2001:db8::/126": {"2001:db8::1", "2001:db8::2"}, "2001:db8:1::1/128": {"2001:db8:1::1"}}},
+		{"IPv6 no shorter than MinPrefixLen6", config.AggregationCfg{MinPrefixLen6: 127},
+			[]string{"2001:db8::1", "2001:db8::2"},
+			map[string][]string{"2001:db8::1/128": {"2001:db8::1"}, "2001:db8::2/128": {"2001:db8::2"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := aggregated(newAggregator(&tt.cfg), tt.keys...)
+			if !maps.EqualFunc(got, tt.want, slices.Equal) {
+				t.Errorf("aggregated into %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReaggregate(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	s := newSpeaker(t, ctx)
+	s.agg = newAggregator(&config.AggregationCfg{})
+
+	steps := []struct {
+		name    string
+		advance bool
+		holder  string
+		ips     []string
+		want    []string
+	}{
+		{"first host", true, "a.example.", []string{"192.0.2.1"}, []string{"192.0.2.1/32"}},
+		{"merged with its neighbour", true, "b.example.", []string{"192.0.2.0"}, []string{"192.0.2.0/30"}},
+		{"widened", true, "c.example.", []string{"192.0.2.2", "192.0.2.3"}, []string{"192.0.2.0/29"}},
+		{"still dense after a withdraw", false, "a.example.", []string{"192.0.2.1"}, []string{"192.0.2.0/30"}},
+		{"split", false, "c.example.", []string{"192.0.2.2", "192.0.2.3"}, []string{"192.0.2.0/32"}},
+		{"last member gone", false, "b.example.", []string{"192.0.2.0"}, nil},
+	}
+	for _, st := range steps {
+		var e error
+		if st.advance {
+			e = s.Advance(st.holder, st.ips, nil)
+		} else {
+			e = s.Withdraw(st.holder, st.ips, nil)
+		}
+		if e != nil {
+			t.Fatalf("%s: %v", st.name, e)
+		}
+		if got := ribPrefixes(t, ctx, s); !slices.Equal(got, st.want) {
+			t.Errorf("%s: RIB holds %v, want %v", st.name, got, st.want)
+		}
+	}
+}
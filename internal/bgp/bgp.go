@@ -5,11 +5,13 @@ import (
 	bgpapi "github.com/osrg/gobgp/v3/api"
 	"google.golang.org/protobuf/types/known/anypb"
 	"errors"
+	"github.com/red55/bgp-dns/internal/utils"
 	"net/netip"
 	"slices"
 )
 
-func newBgpPath(prefix *bgpapi.IPAddressPrefix, asn uint32, nh string) *bgpapi.Path {
+func newBgpPath(prefix *bgpapi.IPAddressPrefix, family *bgpapi.Family, asn uint32, nh string,
+	communities []string) *bgpapi.Path {
 	nlri, _ := anypb.New(prefix)
 
 	a1, _ := anypb.New(&bgpapi.OriginAttribute{
@@ -26,15 +28,42 @@ func newBgpPath(prefix *bgpapi.IPAddressPrefix, asn uint32, nh string) *bgpapi.P
 			},
 		},
 	})
-	attrs := []*anypb.Any{a1, a2, a3}
+	attrs := append([]*anypb.Any{a1, a2, a3}, communityAttrs(communities)...)
 	return &bgpapi.Path{
-		Family: _v4Family,
+		Family: family,
 		Nlri:   nlri,
 		Pattrs: attrs,
 	}
 }
 
-func (s *bgpSrv) add(prefix *bgpapi.IPAddressPrefix, asn uint32) error {
+// parsePrefix converts a resolved address into a host route (/32 or /128), or a static CIDR into its masked
+// prefix, and returns the family it belongs to together with the key it is reference counted by.
+func parsePrefix(s string) (*bgpapi.IPAddressPrefix, *bgpapi.Family, string, error) {
+	p, key, e := utils.ParsePrefix(s)
+	if e != nil {
+		return nil, nil, "", fmt.Errorf("invalid IP address or prefix %s: %w", s, e)
+	}
+
+	prefix := &bgpapi.IPAddressPrefix{
+		PrefixLen: uint32(p.Bits()),
+		Prefix:    p.Addr().String(),
+	}
+	if p.Addr().Is4() {
+		return prefix, _v4Family, key, nil
+	}
+	return prefix, _v6Family, key, nil
+}
+
+func (s *bgpSrv) nextHop(family *bgpapi.Family) string {
+	if family.Afi == bgpapi.Family_AFI_IP6 {
+		return s.nh6.String()
+	}
+	return s.id.String()
+}
+
+// add announces prefix with the global communities and the given ones, announcing it again replaces
+// the communities it carried.
+func (s *bgpSrv) add(prefix *bgpapi.IPAddressPrefix, family *bgpapi.Family, asn uint32, communities []string) error {
 	if prefix == nil {
 		return fmt.Errorf("prefix is nil")
 	}
@@ -42,15 +71,16 @@ func (s *bgpSrv) add(prefix *bgpapi.IPAddressPrefix, asn uint32) error {
 	s.L().Info().Msgf("Adding prefix: %s", prefix.String())
 	//TODO: pass context
 	if _, e := s.bgp.AddPath(context.Background(), &bgpapi.AddPathRequest{
-		Path: newBgpPath(prefix, asn, s.id.String()),
+		Path: newBgpPath(prefix, family, asn, s.nextHop(family), mergeCommunities(s.communities, communities)),
 	}); e != nil {
 		return fmt.Errorf("unable to add path: %v, %w", prefix, e)
 	}
+	s.metrics.Announced.Inc()
 
 	return nil
 }
 
-func (s *bgpSrv) find(prefixes []*bgpapi.IPAddressPrefix) (found *bgpapi.IPAddressPrefix, e error) {
+func (s *bgpSrv) find(prefixes []*bgpapi.IPAddressPrefix, family *bgpapi.Family) (found *bgpapi.IPAddressPrefix, e error) {
 	found = nil
 	if prefixes == nil {
 		e = errors.New("prefix is nil")
@@ -61,26 +91,23 @@ func (s *bgpSrv) find(prefixes []*bgpapi.IPAddressPrefix) (found *bgpapi.IPAddre
 	for i, p := range prefixes {
 		tl[i] = &bgpapi.TableLookupPrefix{
 			Prefix: fmt.Sprintf("%s/%d", p.Prefix, p.PrefixLen),
-			Type:   bgpapi.TableLookupPrefix_SHORTER,
+			Type:   bgpapi.TableLookupPrefix_EXACT,
 		}
 	}
 
 	if e = s.bgp.ListPath(context.Background(), &bgpapi.ListPathRequest{
 		TableType: bgpapi.TableType_GLOBAL,
-		Family:    _v4Family,
+		Family:    family,
 		Prefixes:  tl,
 	}, func(dst *bgpapi.Destination) {
 		p1, _ := netip.ParsePrefix(dst.Prefix)
-		slices.IndexFunc(prefixes, func(prefix *bgpapi.IPAddressPrefix) bool {
+		if i := slices.IndexFunc(prefixes, func(prefix *bgpapi.IPAddressPrefix) bool {
 			p2, _ := netip.ParsePrefix(fmt.Sprintf("%s/%d", prefix.Prefix, prefix.PrefixLen))
-
-			if p1.Overlaps(p2) {
-				found = prefix
-				return true
-			} else {
-				return false
-			}
-		})
+			// a covering static prefix is a route of its own, only the exact prefix counts
+			return p1.Masked() == p2.Masked()
+		}); i > -1 {
+			found = prefixes[i]
+		}
 	}); e != nil {
 		return
 	}
@@ -88,18 +115,21 @@ func (s *bgpSrv) find(prefixes []*bgpapi.IPAddressPrefix) (found *bgpapi.IPAddre
 	return
 }
 
-func (s *bgpSrv) remove(prefix *bgpapi.IPAddressPrefix, asn uint32) error {
+func (s *bgpSrv) remove(prefix *bgpapi.IPAddressPrefix, family *bgpapi.Family, asn uint32) error {
 	if prefix == nil {
 		return fmt.Errorf("prefix is nil")
 	}
 
-	found, _ := s.find([]*bgpapi.IPAddressPrefix{prefix})
+	found, _ := s.find([]*bgpapi.IPAddressPrefix{prefix}, family)
 	s.L().Info().Msgf("Removing prefix: %s, found: %t", prefix.String(), found != nil)
 
 	if found != nil {
 		e := s.bgp.DeletePath(context.Background(), &bgpapi.DeletePathRequest{
-			Path: newBgpPath(prefix, asn, s.id.String()),
+			Path: newBgpPath(prefix, family, asn, s.nextHop(family), nil),
 		})
+		if e == nil {
+			s.metrics.Withdrawn.Inc()
+		}
 		return e
 	}
 	return fmt.Errorf("prefix %s is not found", prefix.String())
@@ -0,0 +1,72 @@
+package bgp
+
+import (
+	"slices"
+
+	bgpapi "github.com/osrg/gobgp/v3/api"
+	"github.com/red55/bgp-dns/internal/utils"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// mergeCommunities returns the sorted union of community sets.
+func mergeCommunities(sets ...[]string) []string {
+	var u []string
+	for _, s := range sets {
+		u = append(u, s...)
+	}
+	slices.Sort(u)
+	return slices.Compact(u)
+}
+
+// communityAttrs converts communities into the COMMUNITIES, EXTENDED_COMMUNITIES and LARGE_COMMUNITY
+// path attributes, empty ones are left out. Communities are validated when configured, invalid ones are
+// skipped here.
+func communityAttrs(communities []string) []*anypb.Any {
+	var std []uint32
+	var ext []*anypb.Any
+	var large []*bgpapi.LargeCommunity
+	for _, s := range communities {
+		c, e := utils.ParseCommunity(s)
+		if e != nil {
+			continue
+		}
+		switch c.Kind {
+		case utils.CommunityStandard:
+			std = append(std, c.Value)
+		case utils.CommunityLarge:
+			large = append(large, &bgpapi.LargeCommunity{GlobalAdmin: c.Global, LocalData1: c.Local1, LocalData2: c.Local2})
+		case utils.CommunityExtended:
+			var a *anypb.Any
+			switch {
+			case c.Ip.IsValid():
+				a, _ = anypb.New(&bgpapi.IPv4AddressSpecificExtended{
+					IsTransitive: true, SubType: c.SubType, Address: c.Ip.String(), LocalAdmin: c.Local1,
+				})
+			case c.Global > 0xFFFF:
+				a, _ = anypb.New(&bgpapi.FourOctetAsSpecificExtended{
+					IsTransitive: true, SubType: c.SubType, Asn: c.Global, LocalAdmin: c.Local1,
+				})
+			default:
+				a, _ = anypb.New(&bgpapi.TwoOctetAsSpecificExtended{
+					IsTransitive: true, SubType: c.SubType, Asn: c.Global, LocalAdmin: c.Local1,
+				})
+			}
+			ext = append(ext, a)
+		}
+	}
+
+	var attrs []*anypb.Any
+	if len(std) > 0 {
+		a, _ := anypb.New(&bgpapi.CommunitiesAttribute{Communities: std})
+		attrs = append(attrs, a)
+	}
+	if len(ext) > 0 {
+		a, _ := anypb.New(&bgpapi.ExtendedCommunitiesAttribute{Communities: ext})
+		attrs = append(attrs, a)
+	}
+	if len(large) > 0 {
+		a, _ := anypb.New(&bgpapi.LargeCommunitiesAttribute{Communities: large})
+		attrs = append(attrs, a)
+	}
+	return attrs
+}
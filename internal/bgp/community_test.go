@@ -0,0 +1,76 @@
+package bgp
+
+import (
+	"slices"
+	"testing"
+
+	bgpapi "github.com/osrg/gobgp/v3/api"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// attrs packs messages the way communityAttrs does.
+func attrs(t *testing.T, ms ...proto.Message) []*anypb.Any {
+	var r []*anypb.Any
+	for _, m := range ms {
+		a, e := anypb.New(m)
+		if e != nil {
+			t.Fatal(e)
+		}
+		r = append(r, a)
+	}
+	return r
+}
+
+func TestCommunityAttrs(t *testing.T) {
+	ext := func(m proto.Message) *anypb.Any {
+		a, _ := anypb.New(m)
+		return a
+	}
+	tests := []struct {
+		name        string
+		communities []string
+		want        []*anypb.Any
+	}{
+		{"none", nil, nil},
+		{"standard", []string{"65000:100", "no-export"},
+			attrs(t, &bgpapi.CommunitiesAttribute{Communities: []uint32{65000<<16 | 100, 0xFFFFFF01}})},
+		{"large", []string{"65000:1:2"}, attrs(t, &bgpapi.LargeCommunitiesAttribute{
+			Communities: []*bgpapi.LargeCommunity{{GlobalAdmin: 65000, LocalData1: 1, LocalData2: 2}},
+		})},
+		{"extended", []string{"rt:65000:100", "rt:4200000000:7", "soo:192.0.2.1:8"},
+			attrs(t, &bgpapi.ExtendedCommunitiesAttribute{Communities: []*anypb.Any{
+				ext(&bgpapi.TwoOctetAsSpecificExtended{IsTransitive: true, SubType: 0x02, Asn: 65000, LocalAdmin: 100}),
+				ext(&bgpapi.FourOctetAsSpecificExtended{IsTransitive: true, SubType: 0x02, Asn: 4200000000,
+					LocalAdmin: 7}),
+				ext(&bgpapi.IPv4AddressSpecificExtended{IsTransitive: true, SubType: 0x03, Address: "192.0.2.1",
+					LocalAdmin: 8}),
+			}})},
+		{"every kind, one attribute each", []string{"65000:1:2", "rt:65000:100", "65000:100"},
+			attrs(t,
+				&bgpapi.CommunitiesAttribute{Communities: []uint32{65000<<16 | 100}},
+				&bgpapi.ExtendedCommunitiesAttribute{Communities: []*anypb.Any{
+					ext(&bgpapi.TwoOctetAsSpecificExtended{IsTransitive: true, SubType: 0x02, Asn: 65000,
+						LocalAdmin: 100}),
+				}},
+				&bgpapi.LargeCommunitiesAttribute{
+					Communities: []*bgpapi.LargeCommunity{{GlobalAdmin: 65000, LocalData1: 1, LocalData2: 2}},
+				})},
+		{"invalid ones skipped", []string{"65536:1", "65000:100", "1:2:4294967296"},
+			attrs(t, &bgpapi.CommunitiesAttribute{Communities: []uint32{65000<<16 | 100}})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := communityAttrs(tt.communities); !equalAttrs(got, tt.want) {
+				t.Errorf("%v converted into %v, want %v", tt.communities, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeCommunities(t *testing.T) {
+	got := mergeCommunities([]string{"65000:2", "65000:1"}, nil, []string{"65000:1", "65000:1:1"})
+	if want := []string{"65000:1", "65000:1:1", "65000:2"}; !slices.Equal(got, want) {
+		t.Errorf("merged into %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,136 @@
+package bgp
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/red55/bgp-dns/internal/utils"
+)
+
+var (
+	ENotAnnounced  = errors.New("not announced")
+	EInvalidPrefix = errors.New("invalid IP address or prefix")
+)
+
+// refs are the holders of an announced prefix, each with the number of times it holds the prefix, and for
+// each community the number of holds attaching it. A holder is the cache entry or the static rule the prefix
+// was announced for, so a prefix left announced tells who still holds it.
+type refs struct {
+	n           uint64
+	holders     map[string]uint64
+	communities map[string]uint64
+}
+
+func newRefs() *refs {
+	return &refs{holders: make(map[string]uint64), communities: make(map[string]uint64)}
+}
+
+// hold adds a hold of holder attaching communities and returns the number of holds.
+func (r *refs) hold(holder string, communities []string) uint64 {
+	r.n++
+	r.holders[holder]++
+	for _, c := range communities {
+		r.communities[c]++
+	}
+	return r.n
+}
+
+// release removes a hold of holder which attached communities and returns the number of holds left. A
+// holder which does not hold the prefix changes nothing, ok is false then.
+func (r *refs) release(holder string, communities []string) (n uint64, ok bool) {
+	if r.holders[holder] == 0 {
+		return r.n, false
+	}
+	if r.holders[holder]--; r.holders[holder] == 0 {
+		delete(r.holders, holder)
+	}
+	r.n--
+	for _, c := range communities {
+		if r.communities[c] > 1 {
+			r.communities[c]--
+		} else {
+			delete(r.communities, c)
+		}
+	}
+	return r.n, true
+}
+
+// union returns the sorted communities attached by any holder.
+func (r *refs) union() []string {
+	u := make([]string, 0, len(r.communities))
+	for c := range r.communities {
+		u = append(u, c)
+	}
+	slices.Sort(u)
+	return u
+}
+
+// names returns the sorted holders.
+func (r *refs) names() []string {
+	h := make([]string, 0, len(r.holders))
+	for k := range r.holders {
+		h = append(h, k)
+	}
+	slices.Sort(h)
+	return h
+}
+
+// Route is a point in time copy of a held prefix: the number of holds, the holders and the communities they
+// attach.
+type Route struct {
+	Prefix      string   `json:"prefix"`
+	Refs        uint64   `json:"refs"`
+	Holders     []string `json:"holders"`
+	Communities []string `json:"communities"`
+}
+
+func (r *refs) route(prefix string) Route {
+	return Route{Prefix: prefix, Refs: r.n, Holders: r.names(), Communities: r.union()}
+}
+
+// Routes returns every held prefix ordered by prefix.
+func (s *bgpSrv) Routes() (r []Route, e error) {
+	e = s.Operation(func() error {
+		r = make([]Route, 0, len(s.index))
+		for k, refs := range s.index {
+			r = append(r, refs.route(k))
+		}
+		return nil
+	}, true)
+	slices.SortFunc(r, func(a, b Route) int {
+		return strings.Compare(a.Prefix, b.Prefix)
+	})
+
+	return
+}
+
+// Route returns the longest held prefix covering prefix, an address or a CIDR prefix, so an address of a static
+// prefix finds the prefix.
+func (s *bgpSrv) Route(prefix string) (r Route, e error) {
+	p, key, e := utils.ParsePrefix(prefix)
+	if e != nil {
+		return r, fmt.Errorf("%w %s: %w", EInvalidPrefix, prefix, e)
+	}
+	e = s.Operation(func() error {
+		if refs, ok := s.index[key]; ok {
+			r = refs.route(key)
+			return nil
+		}
+		best := -1
+		for k, refs := range s.index {
+			q, _, err := utils.ParsePrefix(k)
+			if err != nil || q.Bits() <= best || q.Bits() > p.Bits() || !q.Contains(p.Addr()) {
+				continue
+			}
+			best, r = q.Bits(), refs.route(k)
+		}
+		if best < 0 {
+			return fmt.Errorf("%s is %w", key, ENotAnnounced)
+		}
+		return nil
+	}, true)
+
+	return
+}
@@ -0,0 +1,60 @@
+package bgp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRouteLongestMatch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	s := newSpeaker(t, ctx)
+
+	for _, a := range []struct {
+		holder string
+		ips    []string
+	}{
+		{"198.51.100.0/24", []string{"198.51.100.0/24"}},
+		{"198.51.100.128/25", []string{"198.51.100.128/25"}},
+		{"a.example.", []string{"198.51.100.200"}},
+	} {
+		if e := s.Advance(a.holder, a.ips, nil); e != nil {
+			t.Fatal(e)
+		}
+	}
+
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{"198.51.100.200", "198.51.100.200"},
+		{"198.51.100.200/32", "198.51.100.200"},
+		{"198.51.100.201", "198.51.100.128/25"},
+		{"198.51.100.1", "198.51.100.0/24"},
+		{"198.51.100.0/26", "198.51.100.0/24"},
+		{"198.51.100.128/25", "198.51.100.128/25"},
+		{"::ffff:198.51.100.1", "198.51.100.0/24"},
+	}
+	for _, tt := range tests {
+		r, e := s.Route(tt.prefix)
+		if e != nil {
+			t.Errorf("%s: %v", tt.prefix, e)
+			continue
+		}
+		if r.Prefix != tt.want {
+			t.Errorf("%s matched %s, want %s", tt.prefix, r.Prefix, tt.want)
+		}
+	}
+
+	// a shorter prefix is not covered by a longer one, nor is another family
+	for _, prefix := range []string{"198.51.100.0/23", "192.0.2.1", "2001:db8::1"} {
+		if r, e := s.Route(prefix); !errors.Is(e, ENotAnnounced) {
+			t.Errorf("%s matched %+v %v", prefix, r, e)
+		}
+	}
+	if _, e := s.Route("198.51.100"); !errors.Is(e, EInvalidPrefix) {
+		t.Errorf("an invalid prefix gave %v", e)
+	}
+}
@@ -2,59 +2,142 @@ package bgp
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	bgpapi "github.com/osrg/gobgp/v3/api"
+	bgplog "github.com/osrg/gobgp/v3/pkg/log"
 	bgpsrv "github.com/osrg/gobgp/v3/pkg/server"
 	"github.com/red55/bgp-dns/internal/config"
+	"github.com/red55/bgp-dns/internal/dns"
 	"github.com/red55/bgp-dns/internal/log"
 	"github.com/red55/bgp-dns/internal/loop"
+	"github.com/red55/bgp-dns/internal/metrics"
+	"github.com/red55/bgp-dns/internal/utils"
 	"net"
+	"slices"
+	"strings"
 	"sync"
-	"sync/atomic"
+	"time"
 )
 
+// Speaker is a BGP speaker announcing resolved addresses as host routes, and static prefixes as they are,
+// to the configured peers. Advance and Withdraw take the holder, the cache entry or rule announcing them,
+// addresses or CIDR prefixes and the communities the holder attaches to them. A prefix is announced while
+// any holder holds it and carries the union of the communities of its holders. Drift and Reconcile compare
+// the holders with what the cache holds and repair them and the RIB.
+type Speaker interface {
+	Serve(ctx context.Context) error
+	Connect(ctx context.Context) error
+	Reload(ctx context.Context, cfg *config.AppCfg) error
+	Shutdown(ctx context.Context) error
+	Advance(holder string, ips []string, communities []string) error
+	Withdraw(holder string, ips []string, communities []string) error
+	Routes() ([]Route, error)
+	Route(prefix string) (Route, error)
+	Peers(ctx context.Context) ([]Peer, error)
+	Drift(holds map[string]dns.Hold) (map[string]string, error)
+	Reconcile(ctx context.Context, holds map[string]dns.Hold, prefixes []string) (Corrections, error)
+}
+
+// Peer is a point in time copy of the state of a configured peer, Since is when the session last went up or
+// down and Advertised the number of prefixes sent to it.
+type Peer struct {
+	Address    string    `json:"address"`
+	Asn        uint32    `json:"asn"`
+	State      string    `json:"state"`
+	Since      time.Time `json:"since"`
+	Advertised uint64    `json:"advertised"`
+}
+
 type bgpSrv struct {
 	loop.Loop
 	log.Log
+	cfg *config.AppCfg
+	metrics *metrics.Metrics
 	bgp *bgpsrv.BgpServer
-	//ipRefCounter  *hashmap.Map[string, *atomic.Uint64]
-	ipRefCounter map[string]*atomic.Uint64
+	// logger is the one of every BgpServer, served tells whether bgp was served already
+	logger bgplog.Logger
+	served bool
+	// peers are the configured peers, replaced by Reload
+	peers []*config.BgpNeighbor
+	// index maps every held prefix, by the key utils.ParsePrefix gives it, to its holders
+	index map[string]*refs
+	// agg is nil unless aggregation is configured, exported holds the prefixes it put into the RIB
+	agg *aggregator
+	exported map[string]*exportedRoute
 	cancel context.CancelFunc
 	wg sync.WaitGroup
+	// communities are attached to every prefix
+	communities []string
 	asn uint32
 	id net.IP
+	nh6 net.IP
 }
 
 
-var (
+const (
+	defaultMultihopTtl = 254
+	// defaultHoldTime is seconds
+	defaultHoldTime = 240
+)
 
-	_bgp *bgpSrv
+var (
+	ENoNextHop6 = errors.New("Bgp.NextHop6 is not set")
 
 	_v4Family = &bgpapi.Family{
 		Afi:  bgpapi.Family_AFI_IP,
 		Safi: bgpapi.Family_SAFI_UNICAST,
 	}
+	_v6Family = &bgpapi.Family{
+		Afi:  bgpapi.Family_AFI_IP6,
+		Safi: bgpapi.Family_SAFI_UNICAST,
+	}
 )
 
-func Serve(ctx context.Context) (e error) {
-	cfg := ctx.Value("cfg").(*config.AppCfg)
-	_bgp = &bgpSrv{
-		Loop:         loop.NewLoop(1),
-		Log: log.NewLog(log.L(), "bgp"),
-		bgp:          bgpsrv.NewBgpServer(bgpsrv.LoggerOption(newZeroLogger(cfg.Log.Level))),
-		//ipRefCounter: hashmap.New[string, *atomic.Uint64](),
-		ipRefCounter: make(map[string]*atomic.Uint64),
+func New(cfg *config.AppCfg, m *metrics.Metrics, logs *log.Logs) Speaker {
+	logger := newZeroLogger(logs)
+	s := &bgpSrv{
+		Loop:         loop.NewLoop(1, logs),
+		Log: logs.NewLog("bgp"),
+		cfg: cfg,
+		metrics: m,
+		bgp:          bgpsrv.NewBgpServer(bgpsrv.LoggerOption(logger)),
+		logger: logger,
+		index: make(map[string]*refs),
+		agg: newAggregator(cfg.Bgp.Aggregation),
+		exported: make(map[string]*exportedRoute),
 		asn: cfg.Bgp.Asn,
 		id: cfg.Bgp.Id,
+		nh6: cfg.Bgp.NextHop6,
+	}
+
+	return s
+}
+
+func (s *bgpSrv) Serve(ctx context.Context) (e error) {
+	cfg := s.cfg
+	for _, peer := range cfg.Bgp.Peers {
+		if peer.Ipv6 && cfg.Bgp.NextHop6 == nil {
+			return fmt.Errorf("%w, peer %s enables IPv6", ENoNextHop6, peer.Addr.IP)
+		}
+	}
+	if s.communities, e = utils.NormalizeCommunities(cfg.Bgp.Communities); e != nil {
+		return
 	}
+	// the Serve of a BgpServer never returns, a speaker started again gets a new one
+	if s.served {
+		s.bgp = bgpsrv.NewBgpServer(bgpsrv.LoggerOption(s.logger))
+	}
+	s.served = true
 	go func () {
-		_bgp.bgp.Serve()
+		s.bgp.Serve()
 	}()
 
-	ctx, _bgp.cancel = context.WithCancel(ctx)
+	ctx, s.cancel = context.WithCancel(ctx)
 
-	if e = _bgp.bgp.StartBgp(ctx, &bgpapi.StartBgpRequest{
+	if e = s.bgp.StartBgp(ctx, &bgpapi.StartBgpRequest{
 		Global: &bgpapi.Global{
-			Asn:             _bgp.asn,
+			Asn:             s.asn,
 			RouterId:        cfg.Bgp.Id.String(),
 			ListenAddresses: []string{cfg.Bgp.Listen.IP.String()},
 			ListenPort:      int32(cfg.Bgp.Listen.Port),
@@ -65,127 +148,291 @@ func Serve(ctx context.Context) (e error) {
 			},
 		},
 	}); e != nil {
-		_bgp.L().Panic().Err(e).Msg("Failed to start BGP instance")
+		s.L().Error().Err(e).Msg("Failed to start BGP instance")
+		s.cancel()
+		s.bgp.Stop()
+		return fmt.Errorf("failed to start BGP instance: %w", e)
 	}
 
-	for _, peer := range cfg.Bgp.Peers {
-		pol := &bgpapi.ApplyPolicy{
-			ImportPolicy: &bgpapi.PolicyAssignment{
-				Direction:     bgpapi.PolicyDirection_IMPORT,
-				DefaultAction: bgpapi.RouteAction_REJECT,
-			},
-			ExportPolicy: &bgpapi.PolicyAssignment{
-				Direction:     bgpapi.PolicyDirection_EXPORT,
-				DefaultAction: bgpapi.RouteAction_ACCEPT,
-			},
+	if e = s.applyPeerPolicies(ctx, cfg.Bgp.Peers); e != nil {
+		s.L().Error().Err(e).Msg("Failed to apply peer policies")
+		_ = s.bgp.StopBgp(ctx, &bgpapi.StopBgpRequest{})
+		s.cancel()
+		s.bgp.Stop()
+		return e
+	}
+
+	go s.loop(ctx)
+	go s.watchPeers(ctx)
+
+	return nil
+}
+
+// Connect configures the peers. It is called once the lists are loaded, so the first UPDATE a peer receives
+// holds every prefix and, after a graceful restart, the End-of-RIB marker following it does not flush routes
+// still being resolved.
+func (s *bgpSrv) Connect(ctx context.Context) error {
+	return s.Operation(func() error {
+		for _, peer := range s.cfg.Bgp.Peers {
+			if e := s.addPeer(ctx, peer); e != nil {
+				return e
+			}
 		}
+		s.peers = s.cfg.Bgp.Peers
+		return nil
+	}, true)
+}
 
-		if e = _bgp.bgp.AddPeer(ctx, &bgpapi.AddPeerRequest{
-			Peer: &bgpapi.Peer{
-				ApplyPolicy: pol,
-				Conf: &bgpapi.PeerConf{
-					NeighborAddress: peer.Addr.IP.String(),
-					PeerAsn:         peer.Asn,
-				},
-				EbgpMultihop: &bgpapi.EbgpMultihop{
-					Enabled:     peer.Multihop,
-					MultihopTtl: 254,
+func (s *bgpSrv) addPeer(ctx context.Context, peer *config.BgpNeighbor) (e error) {
+	cfg := s.cfg
+
+	pol := &bgpapi.ApplyPolicy{
+		ImportPolicy: &bgpapi.PolicyAssignment{
+			Direction:     bgpapi.PolicyDirection_IMPORT,
+			DefaultAction: bgpapi.RouteAction_REJECT,
+		},
+		ExportPolicy: &bgpapi.PolicyAssignment{
+			Direction:     bgpapi.PolicyDirection_EXPORT,
+			DefaultAction: bgpapi.RouteAction_ACCEPT,
+		},
+	}
+
+	multihopTtl, holdTime, keepalive := peer.MultihopTtl, uint64(peer.HoldTime), uint64(peer.KeepaliveInterval)
+	if multihopTtl == 0 {
+		multihopTtl = defaultMultihopTtl
+	}
+	if holdTime == 0 {
+		holdTime = defaultHoldTime
+	}
+	if keepalive == 0 {
+		keepalive = holdTime / 3
+	}
+	localAddress := cfg.Bgp.Listen.IP
+	if peer.LocalAddress != nil {
+		localAddress = peer.LocalAddress
+	}
+
+	if e = s.bgp.AddPeer(ctx, &bgpapi.AddPeerRequest{
+		Peer: &bgpapi.Peer{
+			ApplyPolicy: pol,
+			Conf: &bgpapi.PeerConf{
+				NeighborAddress: peer.Addr.IP.String(),
+				PeerAsn:         peer.Asn,
+				AuthPassword:    peer.Password,
+			},
+			EbgpMultihop: &bgpapi.EbgpMultihop{
+				Enabled:     peer.Multihop,
+				MultihopTtl: multihopTtl,
+			},
+			TtlSecurity: &bgpapi.TtlSecurity{
+				Enabled: peer.TtlMin > 0,
+				TtlMin:  peer.TtlMin,
+			},
+			Timers: &bgpapi.Timers{
+				Config: &bgpapi.TimersConfig{
+					HoldTime:          holdTime,
+					KeepaliveInterval: keepalive,
 				},
-				Timers: &bgpapi.Timers{
-					Config: &bgpapi.TimersConfig{
-						HoldTime: 240,
+			},
+			Transport: &bgpapi.Transport{
+				PassiveMode:  peer.PassiveMode,
+				MtuDiscovery: true,
+				LocalAddress: localAddress.String(),
+			},
+			GracefulRestart: s.gracefulRestart(),
+			RouteServer: &bgpapi.RouteServer{
+				RouteServerClient: false,
+				SecondaryRoute:    false,
+			},
+
+			AfiSafis: []*bgpapi.AfiSafi{
+				{
+					Config: &bgpapi.AfiSafiConfig{
+						Family:  _v4Family,
+						Enabled: true,
 					},
+					MpGracefulRestart:        s.mpGracefulRestart(),
+					LongLivedGracefulRestart: s.longLivedGracefulRestart(),
 				},
-				Transport: &bgpapi.Transport{
-					PassiveMode:  peer.PassiveMode,
-					MtuDiscovery: true,
-					LocalAddress: cfg.Bgp.Listen.IP.String(),
-				},
-				RouteServer: &bgpapi.RouteServer{
-					RouteServerClient: false,
-					SecondaryRoute:    false,
-				},
-
-				AfiSafis: []*bgpapi.AfiSafi{
-					{
-						Config: &bgpapi.AfiSafiConfig{
-							Family:  _v4Family,
-							Enabled: true,
-						},
+				{
+					Config: &bgpapi.AfiSafiConfig{
+						Family:  _v6Family,
+						Enabled: peer.Ipv6,
 					},
+					MpGracefulRestart:        s.mpGracefulRestart(),
+					LongLivedGracefulRestart: s.longLivedGracefulRestart(),
 				},
 			},
-			}); e != nil {
-				_bgp.L().Fatal().Err(e).Msgf("Failed to add peer %s", peer.Addr.String())
-			}
+		},
+	}); e != nil {
+		s.L().Error().Err(e).Msgf("Failed to add peer %s", peer.Addr.String())
+		return fmt.Errorf("failed to add peer %s: %w", peer.Addr.String(), e)
 	}
 
-	go _bgp.loop(ctx)
-
 	return nil
 }
-func Shutdown(ctx context.Context) (e error) {
-	if e = _bgp.bgp.StopBgp(ctx,  &bgpapi.StopBgpRequest{}); e != nil {
-		_bgp.L().Panic().Err(e).Msg("Failed to shutdown BGP instance")
+
+// Shutdown stops the BGP instance, which withdraws every prefix. With graceful restart configured the sessions
+// are closed first in a way peers take for a restart, they keep our routes as stale until the daemon is back
+// or RestartTime expires.
+func (s *bgpSrv) Shutdown(ctx context.Context) (e error) {
+	if s.cfg.Bgp.GracefulRestart != nil {
+		s.L().Info().Msg("Graceful restart is enabled, closing the sessions for a restart")
+		s.closeSessions(ctx)
 	}
-	_bgp.cancel()
-	_bgp.bgp.Stop()
-	_bgp.wg.Wait()
-	return nil
+	if e = s.bgp.StopBgp(ctx,  &bgpapi.StopBgpRequest{}); e != nil {
+		s.L().Error().Err(e).Msg("Failed to shutdown BGP instance")
+	}
+	s.cancel()
+	s.bgp.Stop()
+	s.wg.Wait()
+	return
 }
 
-func Advance(ips []string) error {
-	return _bgp.Operation(func () (e error) {
-		for _, ip := range ips {
-			counter := new(atomic.Uint64)
-			_bgp.L().Trace().Msgf("Before GetOrInsert: %s", ip)
-
-			refs, ok := _bgp.ipRefCounter[ip]
+func (s *bgpSrv) Advance(holder string, ips []string, communities []string) error {
+	return s.Operation(func () (e error) {
+		changed := false
+		for _, a := range ips {
+			prefix, family, ip, err := parsePrefix(a)
+			if err != nil {
+				e = err
+				continue
+			}
+			if family == _v6Family && s.nh6 == nil {
+				// without Bgp.NextHop6 no peer takes IPv6 prefixes, nothing to announce them with
+				continue
+			}
+			r, ok := s.index[ip]
 			if !ok {
-				refs = counter
-				_bgp.ipRefCounter[ip] = counter
+				r = newRefs()
+				s.index[ip] = r
 			}
-			_bgp.L().Trace().Msgf("After GetOrInsert: %s, %v, %t", ip, refs, ok)
-			c := refs.Add(1)
-			if  c == 1 {
-				_bgp.L().Debug().Msgf("Advance IPs: %s", ip)
-				prefix := &bgpapi.IPAddressPrefix{
-					PrefixLen: 32,
-					Prefix:    ip,
+			before := r.union()
+			c := r.hold(holder, communities)
+			if c == 1 || !slices.Equal(before, r.union()) {
+				s.L().Debug().Msgf("Advance IPs: %s %v for %s", ip, r.union(), holder)
+				changed = true
+				if s.agg == nil {
+					e = s.add(prefix, family, s.asn, r.union())
+					s.metrics.Prefixes.Set(float64(len(s.index)))
 				}
-				e = _bgp.add(prefix, _bgp.asn)
 			} else {
-				_bgp.L().Debug().Msgf("No need to change BGP, %v(%d)", ip, c)
+				s.L().Debug().Msgf("No need to change BGP, %v(%d) held by %s", ip, c,
+					strings.Join(r.names(), ", "))
+			}
+		}
+		if changed && s.agg != nil {
+			if err := s.reaggregate(); err != nil {
+				e = err
 			}
 		}
 		return
 	}, true)
 }
 
-func Withdraw(ips []string) error {
-	return _bgp.Operation( func () (e error) {
-		_bgp.L().Trace().Msgf("-> Withdraw")
-		defer _bgp.L().Trace().Msgf("<- Withdraw")
-		for _, ip := range ips {
-			if refs, exists := _bgp.ipRefCounter[ip]; exists {
-				c := refs.Add(^uint64(0))
-				if c < 1 {
-					_bgp.L().Debug().Msgf("Withdraw IPs: %v", ip)
-					prefix := &bgpapi.IPAddressPrefix{
-						PrefixLen: 32,
-						Prefix:    ip,
-						}
-						if e = _bgp.remove(prefix, _bgp.asn); e != nil {
-							_bgp.L().Error().Err(e)
-						}
-						_bgp.L().Trace().Msg("Before map delete")
-						delete(_bgp.ipRefCounter, ip)
-						_bgp.L().Trace().Msg("After map delete")
-				} else {
-					_bgp.L().Debug().Msgf("No need to change BGP, %v(%d)", ip, c)
+func (s *bgpSrv) Withdraw(holder string, ips []string, communities []string) error {
+	return s.Operation( func () (e error) {
+		s.L().Trace().Msgf("-> Withdraw")
+		defer s.L().Trace().Msgf("<- Withdraw")
+		changed := false
+		for _, a := range ips {
+			prefix, family, ip, err := parsePrefix(a)
+			if err != nil {
+				e = err
+				continue
+			}
+			r, exists := s.index[ip]
+			if !exists {
+				continue
+			}
+			before := r.union()
+			c, held := r.release(holder, communities)
+			switch {
+			case !held:
+				s.L().Warn().Msgf("%s does not hold %s, held by %s", holder, ip, strings.Join(r.names(), ", "))
+			case c < 1:
+				s.L().Debug().Msgf("Withdraw IPs: %v, released by %s", ip, holder)
+				changed = true
+				if s.agg == nil {
+					if e = s.remove(prefix, family, s.asn); e != nil {
+						s.L().Error().Err(e)
+					}
+				}
+				delete(s.index, ip)
+				if s.agg == nil {
+					s.metrics.Prefixes.Set(float64(len(s.index)))
+				}
+			case !slices.Equal(before, r.union()):
+				// the remaining holders attach fewer communities, announce the prefix again without them
+				s.L().Debug().Msgf("Update IPs: %s %v", ip, r.union())
+				changed = true
+				if s.agg == nil {
+					e = s.add(prefix, family, s.asn, r.union())
 				}
+			case r.holders[holder] > 0:
+				s.L().Debug().Msgf("No need to change BGP, %v(%d) still held by %s", ip, c, holder)
+			default:
+				s.L().Info().Msgf("%s stays announced after %s released it, held by %s", ip, holder,
+					strings.Join(r.names(), ", "))
+			}
+		}
+		if changed && s.agg != nil {
+			if err := s.reaggregate(); err != nil {
+				e = err
 			}
 		}
 		return
 	}, true)
-}
\ No newline at end of file
+}
+
+// Peers returns the state of every configured peer ordered by address.
+func (s *bgpSrv) Peers(ctx context.Context) (r []Peer, e error) {
+	e = s.bgp.ListPeer(ctx, &bgpapi.ListPeerRequest{EnableAdvertised: true}, func(p *bgpapi.Peer) {
+		if p.State == nil {
+			return
+		}
+		pr := Peer{
+			Address: p.State.NeighborAddress,
+			Asn:     p.State.PeerAsn,
+			State:   strings.ToLower(p.State.SessionState.String()),
+		}
+		if p.Conf != nil && pr.Address == "" {
+			pr.Address = p.Conf.NeighborAddress
+		}
+		if t := p.GetTimers().GetState(); t != nil {
+			// unset timestamps are zero, the session never went up or down
+			if p.State.SessionState == bgpapi.PeerState_ESTABLISHED && t.Uptime.GetSeconds() > 0 {
+				pr.Since = t.Uptime.AsTime()
+			} else if t.Downtime.GetSeconds() > 0 {
+				pr.Since = t.Downtime.AsTime()
+			}
+		}
+		for _, af := range p.AfiSafis {
+			if st := af.GetState(); st != nil {
+				pr.Advertised += st.Advertised
+			}
+		}
+		r = append(r, pr)
+	})
+	slices.SortFunc(r, func(a, b Peer) int {
+		return strings.Compare(a.Address, b.Address)
+	})
+
+	return
+}
+
+// watchPeers keeps the peer state metric in sync with gobgp session state changes.
+func (s *bgpSrv) watchPeers(ctx context.Context) {
+	if e := s.bgp.WatchEvent(ctx, &bgpapi.WatchEventRequest{
+		Peer: &bgpapi.WatchEventRequest_Peer{},
+	}, func(r *bgpapi.WatchEventResponse) {
+		if p := r.GetPeer(); p != nil && p.Peer != nil && p.Peer.State != nil &&
+			net.ParseIP(p.Peer.State.NeighborAddress) != nil {
+			st := p.Peer.State
+			s.L().Debug().Msgf("Peer %s is %s", st.NeighborAddress, st.SessionState.String())
+			s.metrics.PeerState.WithLabelValues(st.NeighborAddress).Set(float64(st.SessionState))
+		}
+	}); e != nil {
+		s.L().Error().Err(e).Msg("Failed to watch peer events")
+	}
+}
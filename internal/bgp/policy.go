@@ -0,0 +1,144 @@
+package bgp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+
+	bgpapi "github.com/osrg/gobgp/v3/api"
+	"github.com/red55/bgp-dns/internal/config"
+)
+
+const exportPolicy = "bgp-dns-peers"
+
+// peerStatements returns the export statements applying the per-peer next hop, MED, local preference and
+// prepend of peer, one per family as the next hop differs. Nil when the peer uses the defaults.
+func (s *bgpSrv) peerStatements(peer *config.BgpNeighbor, set string) []*bgpapi.Statement {
+	if peer.NextHop == nil && peer.NextHop6 == nil && peer.Med == nil && peer.LocalPref == nil && peer.Prepend == 0 {
+		return nil
+	}
+
+	var r []*bgpapi.Statement
+	for _, f := range []struct {
+		family *bgpapi.Family
+		nh     net.IP
+		name   string
+	}{{_v4Family, peer.NextHop, "v4"}, {_v6Family, peer.NextHop6, "v6"}} {
+		a := &bgpapi.Actions{RouteAction: bgpapi.RouteAction_NONE}
+		if f.nh != nil {
+			a.Nexthop = &bgpapi.NexthopAction{Address: f.nh.String()}
+		}
+		if peer.Med != nil {
+			a.Med = &bgpapi.MedAction{Type: bgpapi.MedAction_REPLACE, Value: int64(*peer.Med)}
+		}
+		if peer.LocalPref != nil {
+			a.LocalPref = &bgpapi.LocalPrefAction{Value: *peer.LocalPref}
+		}
+		if peer.Prepend > 0 {
+			a.AsPrepend = &bgpapi.AsPrependAction{Asn: s.asn, Repeat: peer.Prepend}
+		}
+		r = append(r, &bgpapi.Statement{
+			Name: set + "-" + f.name,
+			Conditions: &bgpapi.Conditions{
+				NeighborSet: &bgpapi.MatchSet{Type: bgpapi.MatchSet_ANY, Name: set},
+				AfiSafiIn:   []*bgpapi.Family{f.family},
+			},
+			Actions: a,
+		})
+	}
+	return r
+}
+
+// applyPeerPolicies installs a global export policy matching each configured peer by its address, so the
+// attributes of a path are set for the peer it is sent to.
+func (s *bgpSrv) applyPeerPolicies(ctx context.Context, peers []*config.BgpNeighbor) error {
+	var statements []*bgpapi.Statement
+	for _, peer := range peers {
+		a, set, ok := peerSet(peer)
+		if !ok {
+			continue
+		}
+		st := s.peerStatements(peer, set)
+		if st == nil {
+			continue
+		}
+		if e := s.bgp.AddDefinedSet(ctx, &bgpapi.AddDefinedSetRequest{
+			DefinedSet: &bgpapi.DefinedSet{
+				DefinedType: bgpapi.DefinedType_NEIGHBOR,
+				Name:        set,
+				List:        []string{netip.PrefixFrom(a, a.BitLen()).String()},
+			},
+		}); e != nil {
+			return fmt.Errorf("failed to add neighbor set %s: %w", set, e)
+		}
+		statements = append(statements, st...)
+	}
+	if len(statements) == 0 {
+		return nil
+	}
+
+	if e := s.bgp.AddPolicy(ctx, &bgpapi.AddPolicyRequest{
+		Policy: &bgpapi.Policy{Name: exportPolicy, Statements: statements},
+	}); e != nil {
+		return fmt.Errorf("failed to add export policy: %w", e)
+	}
+	if e := s.bgp.AddPolicyAssignment(ctx, &bgpapi.AddPolicyAssignmentRequest{
+		Assignment: &bgpapi.PolicyAssignment{
+			Name:          "global",
+			Direction:     bgpapi.PolicyDirection_EXPORT,
+			Policies:      []*bgpapi.Policy{{Name: exportPolicy}},
+			DefaultAction: bgpapi.RouteAction_ACCEPT,
+		},
+	}); e != nil {
+		return fmt.Errorf("failed to assign export policy: %w", e)
+	}
+	return nil
+}
+
+// removePeerPolicies removes what applyPeerPolicies installed for peers. Failures are logged only, what is
+// missing has nothing to remove.
+func (s *bgpSrv) removePeerPolicies(ctx context.Context, peers []*config.BgpNeighbor) {
+	var sets []string
+	for _, peer := range peers {
+		if _, set, ok := peerSet(peer); ok && s.peerStatements(peer, set) != nil {
+			sets = append(sets, set)
+		}
+	}
+	if len(sets) == 0 {
+		return
+	}
+
+	if e := s.bgp.DeletePolicyAssignment(ctx, &bgpapi.DeletePolicyAssignmentRequest{
+		Assignment: &bgpapi.PolicyAssignment{
+			Name:      "global",
+			Direction: bgpapi.PolicyDirection_EXPORT,
+			Policies:  []*bgpapi.Policy{{Name: exportPolicy}},
+		},
+	}); e != nil {
+		s.L().Warn().Err(e).Msg("Failed to unassign export policy")
+	}
+	if e := s.bgp.DeletePolicy(ctx, &bgpapi.DeletePolicyRequest{
+		Policy: &bgpapi.Policy{Name: exportPolicy},
+		All:    true,
+	}); e != nil {
+		s.L().Warn().Err(e).Msg("Failed to delete export policy")
+	}
+	for _, set := range sets {
+		if e := s.bgp.DeleteDefinedSet(ctx, &bgpapi.DeleteDefinedSetRequest{
+			DefinedSet: &bgpapi.DefinedSet{DefinedType: bgpapi.DefinedType_NEIGHBOR, Name: set},
+			All:        true,
+		}); e != nil {
+			s.L().Warn().Err(e).Msgf("Failed to delete neighbor set %s", set)
+		}
+	}
+}
+
+// peerSet returns the address of peer and the name of the neighbor set matching it.
+func peerSet(peer *config.BgpNeighbor) (a netip.Addr, set string, ok bool) {
+	if a, ok = netip.AddrFromSlice(peer.Addr.IP); !ok {
+		return
+	}
+	a = a.Unmap()
+	return a, "peer-" + a.String(), true
+}
@@ -0,0 +1,261 @@
+package bgp
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+
+	bgpapi "github.com/osrg/gobgp/v3/api"
+	"github.com/red55/bgp-dns/internal/dns"
+	"github.com/red55/bgp-dns/internal/utils"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// Corrections are what Reconcile changed: the prefixes whose holders were set from the holds, and the ones
+// announced again, announced with other communities or removed to bring the RIB in line.
+type Corrections struct {
+	Reindexed []string `json:"reindexed"`
+	Announced []string `json:"announced"`
+	Updated   []string `json:"updated"`
+	Removed   []string `json:"removed"`
+}
+
+// Empty reports whether nothing was corrected.
+func (c *Corrections) Empty() bool {
+	return len(c.Reindexed)+len(c.Announced)+len(c.Updated)+len(c.Removed) == 0
+}
+
+// Drift compares the holders of every prefix with holds and returns, by prefix, how the two differ. A prefix
+// drifts for a moment while an Advance or a Withdraw is on its way, only one which keeps drifting is lost.
+func (s *bgpSrv) Drift(holds map[string]dns.Hold) (r map[string]string, e error) {
+	e = s.Operation(func() error {
+		want := s.indexOf(holds)
+		r = make(map[string]string)
+		for k, refs := range s.index {
+			if w := want[k]; !refs.equal(w) {
+				r[k] = drift(refs, w)
+			}
+		}
+		for k, w := range want {
+			if _, ok := s.index[k]; !ok {
+				r[k] = drift(nil, w)
+			}
+		}
+		return nil
+	}, true)
+
+	return
+}
+
+// Reconcile sets the holders of prefixes from holds when they still differ, then brings the RIB in line with
+// the held prefixes. Advance and Withdraw change the RIB a prefix at a time, a failed AddPath leaves a held
+// prefix out of it, a failed DeletePath one nobody holds in it and a lost Advance or Withdraw the holders of
+// a prefix wrong until then.
+func (s *bgpSrv) Reconcile(ctx context.Context, holds map[string]dns.Hold, prefixes []string) (c Corrections, e error) {
+	c = Corrections{Reindexed: []string{}, Announced: []string{}, Updated: []string{}, Removed: []string{}}
+	e = s.Operation(func() error {
+		want := s.indexOf(holds)
+		for _, k := range prefixes {
+			refs, w := s.index[k], want[k]
+			if refs.equal(w) {
+				continue
+			}
+			s.L().Warn().Msgf("%s is %s, holding it as the cache does", k, drift(refs, w))
+			if w == nil {
+				delete(s.index, k)
+			} else {
+				s.index[k] = w
+			}
+			c.Reindexed = append(c.Reindexed, k)
+		}
+		if len(c.Reindexed) > 0 {
+			if s.agg != nil {
+				if err := s.reaggregate(); err != nil {
+					s.L().Error().Err(err).Msg("Failed to aggregate the reindexed prefixes")
+				}
+			} else {
+				s.metrics.Prefixes.Set(float64(len(s.index)))
+			}
+		}
+		return s.reconcileRib(ctx, &c)
+	}, true)
+	for _, l := range [][]string{c.Reindexed, c.Announced, c.Updated, c.Removed} {
+		slices.Sort(l)
+	}
+
+	return
+}
+
+// reconcileRib announces the prefixes missing from the RIB or carrying other communities there and removes
+// the ones nothing holds. Runs on the loop.
+func (s *bgpSrv) reconcileRib(ctx context.Context, c *Corrections) error {
+	want := s.wanted()
+	have, e := s.rib(ctx)
+	if e != nil {
+		return e
+	}
+
+	for p, cs := range want {
+		attrs, ok := have[p]
+		switch {
+		case !ok:
+			s.L().Warn().Msgf("%s is held but missing from the RIB, announcing it again", p)
+		case !equalAttrs(attrs, communityAttrs(mergeCommunities(s.communities, cs))):
+			s.L().Warn().Msgf("%s carries other communities in the RIB than %v, announcing it again", p, cs)
+		default:
+			continue
+		}
+		prefix, family, _, err := parsePrefix(p.String())
+		if err == nil {
+			err = s.add(prefix, family, s.asn, cs)
+		}
+		if err != nil {
+			e = err
+			continue
+		}
+		if ok {
+			c.Updated = append(c.Updated, p.String())
+		} else {
+			c.Announced = append(c.Announced, p.String())
+		}
+	}
+	for p := range have {
+		if _, ok := want[p]; ok {
+			continue
+		}
+		s.L().Warn().Msgf("%s is in the RIB but nothing holds it, removing it", p)
+		prefix, family, _, err := parsePrefix(p.String())
+		if err == nil {
+			err = s.remove(prefix, family, s.asn)
+		}
+		if err != nil {
+			e = err
+			continue
+		}
+		c.Removed = append(c.Removed, p.String())
+	}
+	if len(c.Announced)+len(c.Updated)+len(c.Removed) == 0 {
+		s.L().Debug().Msgf("The RIB holds the %d prefixes it should", len(want))
+	}
+
+	return e
+}
+
+// wanted returns the prefixes which belong into the RIB with the communities they carry: the held ones, or
+// the aggregates exported for them.
+func (s *bgpSrv) wanted() map[netip.Prefix][]string {
+	r := make(map[netip.Prefix][]string, len(s.index))
+	if s.agg != nil {
+		for _, x := range s.exported {
+			r[x.prefix] = x.communities
+		}
+		return r
+	}
+	for k, refs := range s.index {
+		if p, _, e := utils.ParsePrefix(k); e == nil {
+			r[p] = refs.union()
+		}
+	}
+	return r
+}
+
+// rib returns the prefixes we originated in the global table with their community attributes, paths learned
+// from peers are left out.
+func (s *bgpSrv) rib(ctx context.Context) (map[netip.Prefix][]*anypb.Any, error) {
+	r := make(map[netip.Prefix][]*anypb.Any)
+	for _, family := range []*bgpapi.Family{_v4Family, _v6Family} {
+		if e := s.bgp.ListPath(ctx, &bgpapi.ListPathRequest{
+			TableType: bgpapi.TableType_GLOBAL,
+			Family:    family,
+		}, func(dst *bgpapi.Destination) {
+			p, e := netip.ParsePrefix(dst.Prefix)
+			if e != nil {
+				return
+			}
+			for _, path := range dst.Paths {
+				// local paths have no neighbor
+				if !path.IsWithdraw && net.ParseIP(path.NeighborIp) == nil {
+					r[p.Masked()] = communitiesOf(path.Pattrs)
+					return
+				}
+			}
+		}); e != nil {
+			return nil, e
+		}
+	}
+	return r, nil
+}
+
+// communitiesOf returns the community attributes among attrs.
+func communitiesOf(attrs []*anypb.Any) []*anypb.Any {
+	var r []*anypb.Any
+	for _, a := range attrs {
+		if a.MessageIs(&bgpapi.CommunitiesAttribute{}) || a.MessageIs(&bgpapi.ExtendedCommunitiesAttribute{}) ||
+			a.MessageIs(&bgpapi.LargeCommunitiesAttribute{}) {
+			r = append(r, a)
+		}
+	}
+	return r
+}
+
+// equalAttrs reports whether a and b hold the same attributes in the same order.
+func equalAttrs(a, b []*anypb.Any) bool {
+	return slices.EqualFunc(a, b, func(x, y *anypb.Any) bool {
+		mx, e1 := x.UnmarshalNew()
+		my, e2 := y.UnmarshalNew()
+		return e1 == nil && e2 == nil && proto.Equal(mx, my)
+	})
+}
+
+// indexOf returns the holders every prefix of holds should have, a hold of every holder for every address.
+// IPv6 prefixes are left out without Bgp.NextHop6, Advance does not hold them either. Runs on the loop.
+func (s *bgpSrv) indexOf(holds map[string]dns.Hold) map[string]*refs {
+	r := make(map[string]*refs)
+	for holder, h := range holds {
+		for _, a := range h.Ips {
+			p, key, e := utils.ParsePrefix(a)
+			if e != nil || (p.Addr().Is6() && s.nh6 == nil) {
+				continue
+			}
+			if r[key] == nil {
+				r[key] = newRefs()
+			}
+			r[key].hold(holder, h.Communities)
+		}
+	}
+	return r
+}
+
+// equal reports whether r and o have the same holds, a nil refs is no prefix at all.
+func (r *refs) equal(o *refs) bool {
+	if r == nil || o == nil {
+		return r == o
+	}
+	return r.n == o.n && maps.Equal(r.holders, o.holders) && maps.Equal(r.communities, o.communities)
+}
+
+// drift describes how the holders of a prefix, have, differ from the ones it should have, want.
+func drift(have, want *refs) string {
+	return fmt.Sprintf("held by %s while the cache holds it for %s", have.describe(), want.describe())
+}
+
+// describe lists the holders with the number of their holds when more than one, and the communities.
+func (r *refs) describe() string {
+	if r == nil {
+		return "nobody"
+	}
+	h := make([]string, 0, len(r.holders))
+	for _, k := range r.names() {
+		if n := r.holders[k]; n > 1 {
+			h = append(h, fmt.Sprintf("%s(%d)", k, n))
+		} else {
+			h = append(h, k)
+		}
+	}
+	return fmt.Sprintf("%s %v", strings.Join(h, ", "), r.union())
+}
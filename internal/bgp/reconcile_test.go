@@ -0,0 +1,115 @@
+package bgp
+
+import (
+	"context"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/red55/bgp-dns/internal/config"
+	"github.com/red55/bgp-dns/internal/dns"
+	"github.com/red55/bgp-dns/internal/log"
+	"github.com/red55/bgp-dns/internal/metrics"
+)
+
+// newSpeaker starts a speaker which listens nowhere and has no peers.
+func newSpeaker(t *testing.T, ctx context.Context) *bgpSrv {
+	cfg := &config.AppCfg{}
+	cfg.Bgp.Asn = 65530
+	cfg.Bgp.Id = net.ParseIP("127.0.0.1")
+	cfg.Bgp.Listen = net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: -1}
+	s := New(cfg, metrics.New(), log.NewLogs(nil)).(*bgpSrv)
+	if e := s.Serve(ctx); e != nil {
+		t.Fatal(e)
+	}
+	t.Cleanup(func() {
+		_ = s.Shutdown(context.Background())
+	})
+	return s
+}
+
+// ribPrefixes returns the prefixes the speaker put into the RIB.
+func ribPrefixes(t *testing.T, ctx context.Context, s *bgpSrv) (r []string) {
+	e := s.Operation(func() error {
+		have, e := s.rib(ctx)
+		for p := range have {
+			r = append(r, p.String())
+		}
+		return e
+	}, true)
+	if e != nil {
+		t.Fatal(e)
+	}
+	slices.Sort(r)
+	return
+}
+
+func TestReconcileWithCache(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	s := newSpeaker(t, ctx)
+
+	for _, a := range []struct {
+		holder string
+		ips    []string
+	}{
+		{"a.example.", []string{"192.0.2.1", "192.0.2.9"}},
+		{"b.example.", []string{"192.0.2.1", "192.0.2.2"}},
+	} {
+		if e := s.Advance(a.holder, a.ips, nil); e != nil {
+			t.Fatal(e)
+		}
+	}
+	// the Withdraw of a.example. and the Advance of c.example. were lost
+	holds := map[string]dns.Hold{
+		"b.example.": {Ips: []string{"192.0.2.1", "192.0.2.2"}},
+		"c.example.": {Ips: []string{"192.0.2.3"}},
+	}
+
+	drifts, e := s.Drift(holds)
+	if e != nil {
+		t.Fatal(e)
+	}
+	var prefixes []string
+	for k := range drifts {
+		prefixes = append(prefixes, k)
+	}
+	slices.Sort(prefixes)
+	if want := []string{"192.0.2.1", "192.0.2.3", "192.0.2.9"}; !slices.Equal(prefixes, want) {
+		t.Fatalf("drifting %v, want %v", prefixes, want)
+	}
+
+	c, e := s.Reconcile(ctx, holds, prefixes)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !slices.Equal(c.Reindexed, prefixes) {
+		t.Errorf("reindexed %v, want %v", c.Reindexed, prefixes)
+	}
+	if want := []string{"192.0.2.3/32"}; !slices.Equal(c.Announced, want) {
+		t.Errorf("announced %v, want %v", c.Announced, want)
+	}
+	if want := []string{"192.0.2.9/32"}; !slices.Equal(c.Removed, want) {
+		t.Errorf("removed %v, want %v", c.Removed, want)
+	}
+
+	if drifts, e = s.Drift(holds); e != nil || len(drifts) > 0 {
+		t.Errorf("still drifting after Reconcile: %v %v", drifts, e)
+	}
+	if got, want := ribPrefixes(t, ctx, s), []string{"192.0.2.1/32", "192.0.2.2/32", "192.0.2.3/32"}; !slices.Equal(got, want) {
+		t.Errorf("RIB holds %v, want %v", got, want)
+	}
+	r, e := s.Route("192.0.2.1")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(r.Holders) != 1 || r.Holders[0] != "b.example." {
+		t.Errorf("192.0.2.1 is held by %v, want b.example.", r.Holders)
+	}
+
+	// nothing drifts, nothing is corrected
+	if c, e = s.Reconcile(ctx, holds, nil); e != nil || !c.Empty() {
+		t.Errorf("Reconcile of a clean index corrected %+v %v", c, e)
+	}
+}
@@ -0,0 +1,56 @@
+package bgp
+
+import (
+	"context"
+	"reflect"
+
+	bgpapi "github.com/osrg/gobgp/v3/api"
+	"github.com/red55/bgp-dns/internal/config"
+)
+
+// Reload applies the peers of cfg. Removed peers are deleted and new ones added, a changed
+// peer is deleted and added again, which resets its session; the export policy follows the peers.
+func (s *bgpSrv) Reload(ctx context.Context, cfg *config.AppCfg) error {
+	return s.Operation(func() (e error) {
+		wanted := make(map[string]*config.BgpNeighbor, len(cfg.Bgp.Peers))
+		for _, p := range cfg.Bgp.Peers {
+			wanted[p.Addr.IP.String()] = p
+		}
+		var kept, added []*config.BgpNeighbor
+		current := make(map[string]*config.BgpNeighbor, len(s.peers))
+		for _, p := range s.peers {
+			a := p.Addr.IP.String()
+			current[a] = p
+			if w, ok := wanted[a]; ok && reflect.DeepEqual(p, w) {
+				kept = append(kept, p)
+				continue
+			}
+			s.L().Info().Msgf("Removing peer %s", a)
+			if err := s.bgp.DeletePeer(ctx, &bgpapi.DeletePeerRequest{Address: a}); err != nil {
+				s.L().Error().Err(err).Msgf("Failed to remove peer %s", a)
+			}
+		}
+		for _, p := range cfg.Bgp.Peers {
+			if c, ok := current[p.Addr.IP.String()]; !ok || !reflect.DeepEqual(c, p) {
+				added = append(added, p)
+			}
+		}
+		if len(kept) == len(s.peers) && len(added) == 0 {
+			return nil
+		}
+
+		s.removePeerPolicies(ctx, s.peers)
+		s.peers = kept
+		if e = s.applyPeerPolicies(ctx, cfg.Bgp.Peers); e != nil {
+			return
+		}
+		for _, p := range added {
+			s.L().Info().Msgf("Adding peer %s", p.Addr.IP.String())
+			if e = s.addPeer(ctx, p); e != nil {
+				return
+			}
+			s.peers = append(s.peers, p)
+		}
+		return nil
+	}, true)
+}
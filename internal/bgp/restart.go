@@ -0,0 +1,96 @@
+package bgp
+
+import (
+	"context"
+	"time"
+
+	bgpapi "github.com/osrg/gobgp/v3/api"
+)
+
+const (
+	// defaultRestartTime and defaultStaleRoutesTime are seconds
+	defaultRestartTime     = 120
+	defaultStaleRoutesTime = 360
+	// closeTimeout bounds how long closeSessions waits for the sessions to go down
+	closeTimeout = 5 * time.Second
+)
+
+// gracefulRestart returns the graceful restart settings of a peer, nil when it is not configured. The
+// notification extension (RFC 8538) keeps the routes when a session ends with a NOTIFICATION such as
+// a hold timer expiry. The Restart State bit is always set, a peer holding no stale routes of ours ignores it;
+// there is no selection deferral as peers are connected with the RIB already complete.
+func (s *bgpSrv) gracefulRestart() *bgpapi.GracefulRestart {
+	c := s.cfg.Bgp.GracefulRestart
+	if c == nil {
+		return nil
+	}
+	restart, stale := uint32(c.RestartTime), uint32(c.StaleRoutesTime)
+	if restart == 0 {
+		restart = defaultRestartTime
+	}
+	if stale == 0 {
+		stale = defaultStaleRoutesTime
+	}
+	return &bgpapi.GracefulRestart{
+		Enabled:             true,
+		RestartTime:         restart,
+		StaleRoutesTime:     stale,
+		NotificationEnabled: true,
+		LocalRestarting:     true,
+		LonglivedEnabled:    c.LongLivedStaleTime > 0,
+	}
+}
+
+func (s *bgpSrv) mpGracefulRestart() *bgpapi.MpGracefulRestart {
+	if s.cfg.Bgp.GracefulRestart == nil {
+		return nil
+	}
+	return &bgpapi.MpGracefulRestart{
+		Config: &bgpapi.MpGracefulRestartConfig{Enabled: true},
+	}
+}
+
+func (s *bgpSrv) longLivedGracefulRestart() *bgpapi.LongLivedGracefulRestart {
+	c := s.cfg.Bgp.GracefulRestart
+	if c == nil || c.LongLivedStaleTime <= 0 {
+		return nil
+	}
+	return &bgpapi.LongLivedGracefulRestart{
+		Config: &bgpapi.LongLivedGracefulRestartConfig{
+			Enabled:     true,
+			RestartTime: uint32(c.LongLivedStaleTime),
+		},
+	}
+}
+
+// closeSessions ends the sessions so that peers keep our routes as stale. gobgp cannot drop a session without
+// a NOTIFICATION, and StopBgp sends a Peer De-configured Cease which it turns into a Hard Reset, ending the
+// graceful restart. An Administrative Reset is no Hard Reset (RFC 8538), so every session is reset first and
+// StopBgp finds none left up.
+func (s *bgpSrv) closeSessions(ctx context.Context) {
+	if e := s.bgp.ResetPeer(ctx, &bgpapi.ResetPeerRequest{Communication: "restarting"}); e != nil {
+		s.L().Error().Err(e).Msg("Failed to reset the sessions")
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, closeTimeout)
+	defer cancel()
+	t := time.NewTicker(100 * time.Millisecond)
+	defer t.Stop()
+
+	for {
+		up := 0
+		if e := s.bgp.ListPeer(ctx, &bgpapi.ListPeerRequest{}, func(p *bgpapi.Peer) {
+			if p.State != nil && p.State.SessionState == bgpapi.PeerState_ESTABLISHED {
+				up++
+			}
+		}); e != nil || up == 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			s.L().Warn().Msgf("%d sessions are still up, stopping anyway", up)
+			return
+		case <-t.C:
+		}
+	}
+}
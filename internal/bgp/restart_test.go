@@ -0,0 +1,119 @@
+package bgp
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	bgpapi "github.com/osrg/gobgp/v3/api"
+	bgpsrv "github.com/osrg/gobgp/v3/pkg/server"
+	"github.com/red55/bgp-dns/internal/config"
+	"github.com/red55/bgp-dns/internal/log"
+	"github.com/red55/bgp-dns/internal/metrics"
+)
+
+// freePort returns a TCP port nothing listens on at 127.0.0.1.
+func freePort(t *testing.T) int {
+	l, e := net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer func() {
+		_ = l.Close()
+	}()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// newPeer starts a gobgp instance at 127.0.0.2 supporting graceful restart, which connects to a speaker at
+// 127.0.0.1:port.
+func newPeer(t *testing.T, ctx context.Context, port int) *bgpsrv.BgpServer {
+	p := bgpsrv.NewBgpServer(bgpsrv.LoggerOption(newZeroLogger(log.NewLogs(nil))))
+	go p.Serve()
+	t.Cleanup(p.Stop)
+	if e := p.StartBgp(ctx, &bgpapi.StartBgpRequest{Global: &bgpapi.Global{
+		Asn: 65531, RouterId: "127.0.0.2", ListenPort: -1,
+	}}); e != nil {
+		t.Fatal(e)
+	}
+	if e := p.AddPeer(ctx, &bgpapi.AddPeerRequest{Peer: &bgpapi.Peer{
+		Conf:            &bgpapi.PeerConf{NeighborAddress: "127.0.0.1", PeerAsn: 65530},
+		Transport:       &bgpapi.Transport{LocalAddress: "127.0.0.2", RemotePort: uint32(port)},
+		Timers:          &bgpapi.Timers{Config: &bgpapi.TimersConfig{ConnectRetry: 1}},
+		GracefulRestart: &bgpapi.GracefulRestart{Enabled: true, RestartTime: 30, NotificationEnabled: true},
+		AfiSafis: []*bgpapi.AfiSafi{{
+			Config:            &bgpapi.AfiSafiConfig{Family: _v4Family, Enabled: true},
+			MpGracefulRestart: &bgpapi.MpGracefulRestart{Config: &bgpapi.MpGracefulRestartConfig{Enabled: true}},
+		}},
+	}}); e != nil {
+		t.Fatal(e)
+	}
+	return p
+}
+
+// learned returns the path the peer learned for prefix, nil when it has none.
+func learned(t *testing.T, ctx context.Context, p *bgpsrv.BgpServer, prefix string) (r *bgpapi.Path) {
+	if e := p.ListPath(ctx, &bgpapi.ListPathRequest{
+		TableType: bgpapi.TableType_GLOBAL,
+		Family:    _v4Family,
+		Prefixes:  []*bgpapi.TableLookupPrefix{{Prefix: prefix}},
+	}, func(d *bgpapi.Destination) {
+		if len(d.Paths) > 0 {
+			r = d.Paths[0]
+		}
+	}); e != nil {
+		t.Fatal(e)
+	}
+	return
+}
+
+func TestShutdownForGracefulRestart(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	port := freePort(t)
+	cfg := &config.AppCfg{}
+	cfg.Bgp.Asn = 65530
+	cfg.Bgp.Id = net.ParseIP("127.0.0.1")
+	cfg.Bgp.Listen = net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: port}
+	cfg.Bgp.GracefulRestart = &config.GracefulRestartCfg{RestartTime: 30}
+	cfg.Bgp.Peers = []*config.BgpNeighbor{{
+		Asn:         65531,
+		Addr:        net.TCPAddr{IP: net.ParseIP("127.0.0.2")},
+		PassiveMode: true,
+	}}
+	s := New(cfg, metrics.New(), log.NewLogs(nil)).(*bgpSrv)
+	if e := s.Serve(ctx); e != nil {
+		t.Fatal(e)
+	}
+	if e := s.Advance("a.example.", []string{"192.0.2.1"}, nil); e != nil {
+		t.Fatal(e)
+	}
+	if e := s.Connect(ctx); e != nil {
+		t.Fatal(e)
+	}
+	p := newPeer(t, ctx, port)
+
+	for learned(t, ctx, p, "192.0.2.1/32") == nil {
+		select {
+		case <-ctx.Done():
+			t.Fatal("the peer never learned 192.0.2.1/32")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	if e := s.Shutdown(ctx); e != nil {
+		t.Fatal(e)
+	}
+	// the session went down without a Hard Reset, the peer keeps the route as stale
+	path := learned(t, ctx, p, "192.0.2.1/32")
+	if path == nil || !path.Stale {
+		t.Fatalf("the peer holds %v after the shutdown, want a stale 192.0.2.1/32", path)
+	}
+	// the port is released
+	l, e := net.Listen("tcp", cfg.Bgp.Listen.String())
+	if e != nil {
+		t.Fatalf("BGP port still bound: %v", e)
+	}
+	_ = l.Close()
+}
@@ -1,6 +1,7 @@
 package bgp
 
 import (
+    "fmt"
     bgplog "github.com/osrg/gobgp/v3/pkg/log"
     "github.com/rs/zerolog"
 	"github.com/red55/bgp-dns/internal/log"
@@ -11,19 +12,24 @@ type zeroLogger struct {
 }
 
 
-func newZeroLogger(lvl zerolog.Level) bgplog.Logger {
-	l := log.L().Level(lvl)
-
-	r := &zeroLogger{
-		Log: log.NewLog(&l,"gobgp"),
+// newZeroLogger makes the logger of gobgp, the gobgp module of the log.
+func newZeroLogger(logs *log.Logs) bgplog.Logger {
+	return &zeroLogger{
+		Log: logs.NewLog("gobgp"),
 	}
-	r.Log.SetLevel(lvl)
-
-	return r
 }
+// withFields adds the fields of a gobgp log line as fields of the event. Errors and the values which print
+// themselves, addresses and states, are logged as their text rather than as the JSON of their insides.
 func withFields(e *zerolog.Event, fields bgplog.Fields) *zerolog.Event {
-	for k,v := range fields{
-		e = e.Any(k,v)
+	for k, v := range fields {
+		switch t := v.(type) {
+		case error:
+			e = e.AnErr(k, t)
+		case fmt.Stringer:
+			e = e.Stringer(k, t)
+		default:
+			e = e.Interface(k, v)
+		}
 	}
 
 	return e
@@ -0,0 +1,11 @@
+package config
+
+import (
+	"net"
+)
+
+type AdminCfg struct {
+	Listen *net.TCPAddr `yaml:"Listen" json:"Listen"`
+	// Socket is the path of the unix socket serving the admin API to bgp-dnsctl
+	Socket string `yaml:"Socket" json:"Socket"`
+}
@@ -2,18 +2,63 @@ package config
 
 import (
 	"net"
+	"time"
 )
 
-type bgpNeighbor struct {
+type BgpNeighbor struct {
 	Asn    uint32         	`yaml:"Asn" json:"Asn"`
 	Addr 	 net.TCPAddr 	`yaml:"Address" json:"Address"`
 	Multihop bool			`yaml:"Multihop" json:"Multihop"`
 	PassiveMode bool 		`yaml:"PassiveMode" json:"PassiveMode"`
+	Ipv6 bool				`yaml:"Ipv6" json:"Ipv6"`
+	// NextHop and NextHop6 override the next hop of the IPv4 and IPv6 prefixes sent to the peer
+	NextHop  net.IP `yaml:"NextHop" json:"NextHop"`
+	NextHop6 net.IP `yaml:"NextHop6" json:"NextHop6"`
+	Med       *uint32 `yaml:"Med" json:"Med"`
+	// LocalPref is sent to iBGP peers only
+	LocalPref *uint32 `yaml:"LocalPref" json:"LocalPref"`
+	// Prepend is how many more times our ASN is prepended to the AS path sent to the peer
+	Prepend uint32 `yaml:"Prepend" json:"Prepend"`
+	// Password enables TCP MD5 authentication of the session
+	Password string `yaml:"Password" json:"Password"`
+	// TtlMin enables GTSM, packets of the session must arrive with at least this TTL
+	TtlMin      uint32 `yaml:"TtlMin" json:"TtlMin"`
+	MultihopTtl uint32 `yaml:"MultihopTtl" json:"MultihopTtl"`
+	// HoldTime and KeepaliveInterval are seconds, 240 and a third of HoldTime by default
+	HoldTime          time.Duration `yaml:"HoldTime" json:"HoldTime"`
+	KeepaliveInterval time.Duration `yaml:"KeepaliveInterval" json:"KeepaliveInterval"`
+	// LocalAddress is the source address of the session, Bgp.Listen.Ip by default
+	LocalAddress net.IP `yaml:"LocalAddress" json:"LocalAddress"`
 }
 
-type bgpCfg struct {
+// AggregationCfg merges host routes into covering prefixes no longer than MinPrefixLen (MinPrefixLen6 for
+// IPv6) when at least Density (0..1] of the addresses of the prefix are announced.
+type AggregationCfg struct {
+	MinPrefixLen  int     `yaml:"MinPrefixLen" json:"MinPrefixLen"`
+	MinPrefixLen6 int     `yaml:"MinPrefixLen6" json:"MinPrefixLen6"`
+	Density       float64 `yaml:"Density" json:"Density"`
+}
+
+// GracefulRestartCfg enables BGP graceful restart (RFC 4724), times are seconds. Peers keep our routes for
+// RestartTime while the daemon restarts, StaleRoutesTime bounds how long the routes of a restarting peer are
+// kept. LongLivedStaleTime (RFC 9494) keeps our routes as least preferred for that long afterwards, 0 disables it.
+type GracefulRestartCfg struct {
+	RestartTime        time.Duration `yaml:"RestartTime" json:"RestartTime"`
+	StaleRoutesTime    time.Duration `yaml:"StaleRoutesTime" json:"StaleRoutesTime"`
+	LongLivedStaleTime time.Duration `yaml:"LongLivedStaleTime" json:"LongLivedStaleTime"`
+}
+
+type BgpCfg struct {
 	Asn    uint32         	`yaml:"Asn" json:"Asn"`
 	Id     	net.IP         	`yaml:"Id" json:"Id"`
 	Listen   net.TCPAddr    `yaml:"Listen" json:"Listen"`
-	Peers []*bgpNeighbor 	`yaml:"Peers" json:"Peers"`
+	NextHop6 net.IP			`yaml:"NextHop6" json:"NextHop6"`
+	Peers []*BgpNeighbor 	`yaml:"Peers" json:"Peers"`
+	Aggregation *AggregationCfg `yaml:"Aggregation" json:"Aggregation"`
+	// Communities are attached to every announced prefix: 65000:100, rt:65000:100 or 65000:1:2
+	Communities []string `yaml:"Communities" json:"Communities"`
+	GracefulRestart *GracefulRestartCfg `yaml:"GracefulRestart" json:"GracefulRestart"`
+	// ReconcileInterval is how often, in seconds, the held prefixes and the RIB are reconciled with the cache (60
+	// by default)
+	ReconcileInterval time.Duration `yaml:"ReconcileInterval" json:"ReconcileInterval"`
 }
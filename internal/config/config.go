@@ -1,8 +1,9 @@
 package config
 
 type AppCfg struct {
-    Log logCfg `yaml:"Log" json:"Log"`
-    Bgp bgpCfg `yaml:"Bgp" json:"Bgp"`
-    Dns dnsCfg `yaml:"Dns" json:"Dns"`
+    Log LogCfg `yaml:"Log" json:"Log"`
+    Bgp BgpCfg `yaml:"Bgp" json:"Bgp"`
+    Dns DnsCfg `yaml:"Dns" json:"Dns"`
+    Admin AdminCfg `yaml:"Admin" json:"Admin"`
 }
 
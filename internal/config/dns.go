@@ -5,19 +5,59 @@ import (
 	"time"
 )
 
-type listCfg struct {
+// ListCfg is a domain list: a file, or a directory where every *.lst file is a list of its own.
+// Resolvers are used for the domains of the list, Dns.List.Resolvers and then Dns.Resolvers when empty.
+// With Url set the list is downloaded every Refresh seconds and File keeps the last good copy.
+type ListCfg struct {
 	File      string         `yaml:"File" json:"File"`
-	Resolvers []*net.UDPAddr `yaml:"Resolvers" json:"Resolvers"`
+	Resolvers []*ResolverCfg `yaml:"Resolvers" json:"Resolvers"`
+	Url       string         `yaml:"Url" json:"Url"`
+	Refresh   time.Duration  `yaml:"Refresh" json:"Refresh"`
+	// MaxSize limits the downloaded list, bytes
+	MaxSize int64 `yaml:"MaxSize" json:"MaxSize"`
+	// Checksum is the hex SHA-256 of the list, or the http(s) URL of a sha256sum style file holding it
+	Checksum string `yaml:"Checksum" json:"Checksum"`
+	CaFile   string `yaml:"CaFile" json:"CaFile"`
+	// Communities are attached to the prefixes of every entry of the list
+	Communities []string `yaml:"Communities" json:"Communities"`
 }
-type cacheCfg struct {
+type CacheCfg struct {
 	MaxEntries int `yaml:"MaxEntries" json:"MaxEntries"`
 	MinTtl	  time.Duration `yaml:"MinTtl" json:"MinTtl"`
+	// StateFile keeps the entries across restarts, it is saved every StateInterval seconds (300 by default)
+	// and on shutdown
+	StateFile     string        `yaml:"StateFile" json:"StateFile"`
+	StateInterval time.Duration `yaml:"StateInterval" json:"StateInterval"`
 }
 
-type dnsCfg struct {
+// EncryptedListenCfg is a DNS-over-TLS or DNS-over-HTTPS listener, Path applies to DoH only.
+type EncryptedListenCfg struct {
+	Listen   *net.TCPAddr `yaml:"Listen" json:"Listen"`
+	CertFile string       `yaml:"CertFile" json:"CertFile"`
+	KeyFile  string       `yaml:"KeyFile" json:"KeyFile"`
+	Path     string       `yaml:"Path" json:"Path"`
+}
+
+type DnsCfg struct {
 	Listen    *net.UDPAddr   `yaml:"Listen" json:"Listen"`
-	Resolvers []*net.UDPAddr `yaml:"Resolvers" json:"Resolvers"`
-	List      listCfg        `yaml:"List" json:"List"`
-	Cache	  cacheCfg		 `yaml:"Cache" json:"Cache"`
+	Resolvers []*ResolverCfg `yaml:"Resolvers" json:"Resolvers"`
+	List      ListCfg        `yaml:"List" json:"List"`
+	Lists     []*ListCfg     `yaml:"Lists" json:"Lists"`
+	Cache	  CacheCfg		 `yaml:"Cache" json:"Cache"`
+	Tls       *EncryptedListenCfg `yaml:"Tls" json:"Tls"`
+	Https     *EncryptedListenCfg `yaml:"Https" json:"Https"`
 }
 
+// AllLists returns Dns.List followed by Dns.Lists, skipping entries without a File.
+func (c *DnsCfg) AllLists() []*ListCfg {
+	r := make([]*ListCfg, 0, len(c.Lists)+1)
+	if c.List.File != "" {
+		r = append(r, &c.List)
+	}
+	for _, l := range c.Lists {
+		if l != nil && l.File != "" {
+			r = append(r, l)
+		}
+	}
+	return r
+}
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	EUnknownKey = errors.New("unknown key")
+
+	ipType       = reflect.TypeOf(net.IP{})
+	levelType    = reflect.TypeOf(zerolog.DebugLevel)
+	resolverType = reflect.TypeOf(ResolverCfg{})
+)
+
+// checkKeys walks the YAML document n against the type t it is decoded into. It reports the keys t has no
+// field for, which the decoder would ignore, and the values it would turn into nothing, such as an address
+// which does not parse. path is the YAML path of n.
+func checkKeys(n *yaml.Node, t reflect.Type, path string) (errs []error) {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		for _, c := range n.Content {
+			errs = append(errs, checkKeys(c, t, path)...)
+		}
+		return errs
+	case yaml.AliasNode:
+		return checkKeys(n.Alias, t, path)
+	}
+	if n.Kind == yaml.ScalarNode && n.Tag == "!!null" {
+		return nil
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == ipType:
+		if n.Kind != yaml.ScalarNode {
+			return []error{fmt.Errorf("%s: expected an IP address", at(path, n))}
+		}
+		if n.Value != "" && net.ParseIP(n.Value) == nil {
+			return []error{fmt.Errorf("%s: invalid IP address %q", at(path, n), n.Value)}
+		}
+		return nil
+	case t == levelType:
+		if _, e := zerolog.ParseLevel(n.Value); n.Kind != yaml.ScalarNode || e != nil {
+			return []error{fmt.Errorf("%s: invalid log level %q", at(path, n), n.Value)}
+		}
+		return nil
+	case t == resolverType && n.Kind == yaml.ScalarNode:
+		// a resolver written as its URL
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if n.Kind != yaml.MappingNode {
+			return []error{fmt.Errorf("%s: expected a mapping", at(path, n))}
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			p := k.Value
+			if path != "" {
+				p = path + "." + k.Value
+			}
+			f, ok := field(t, k.Value)
+			if !ok {
+				errs = append(errs, fmt.Errorf("%s: %w", at(p, k), EUnknownKey))
+				continue
+			}
+			errs = append(errs, checkKeys(v, f.Type, p)...)
+		}
+	case reflect.Map:
+		if n.Kind != yaml.MappingNode {
+			return []error{fmt.Errorf("%s: expected a mapping", at(path, n))}
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			errs = append(errs, checkKeys(n.Content[i+1], t.Elem(), path+"."+n.Content[i].Value)...)
+		}
+	case reflect.Slice:
+		if n.Kind != yaml.SequenceNode {
+			return []error{fmt.Errorf("%s: expected a list", at(path, n))}
+		}
+		for i, c := range n.Content {
+			errs = append(errs, checkKeys(c, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	default:
+		if n.Kind != yaml.ScalarNode {
+			return []error{fmt.Errorf("%s: expected a single value", at(path, n))}
+		}
+	}
+	return errs
+}
+
+// field finds the field of t decoded from key, matched the way the decoder matches it: by the json tag or the
+// field name, ignoring case.
+func field(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		if strings.EqualFold(keyOf(f), key) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// keyOf returns the key field f is written as, its json tag or, for the fields of net.TCPAddr and the like,
+// its name as the configuration capitalizes it: Ip.
+func keyOf(f reflect.StructField) string {
+	if name, _, _ := strings.Cut(f.Tag.Get("json"), ","); name != "" {
+		return name
+	}
+	return f.Name[:1] + strings.ToLower(f.Name[1:])
+}
+
+// at is the YAML path of node n with its line, which a value set by an override does not have.
+func at(path string, n *yaml.Node) string {
+	if n.Line == 0 {
+		return path
+	}
+	return fmt.Sprintf("%s (line %d)", path, n.Line)
+}
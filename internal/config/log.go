@@ -1,9 +1,61 @@
 package config
 
 import (
+	"time"
+
 	"github.com/rs/zerolog"
 )
 
-type logCfg struct {
-	Level zerolog.Level `yaml:"Level" json:"Level"`
+const (
+	LogFormatConsole = "console"
+	LogFormatJson    = "json"
+
+	LogOutputStdout   = "stdout"
+	LogOutputStderr   = "stderr"
+	LogOutputFile     = "file"
+	LogOutputSyslog   = "syslog"
+	LogOutputJournald = "journald"
+)
+
+// LogModules are the modules which log, each may log at a level of its own.
+var LogModules = []string{"main", "daemon", "admin", "bgp", "gobgp", "dns", "resolvers", "loop", "fswatcher", "fetcher",
+	"reconciler"}
+
+// SyslogFacilities are the facilities a syslog output may log with, by name.
+var SyslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// LogCfg sets what is logged: Level, or the level in Modules for a module listed there. Outputs may keep
+// less, each at its own level.
+type LogCfg struct {
+	Level   zerolog.Level            `yaml:"Level" json:"Level"`
+	Modules map[string]zerolog.Level `yaml:"Modules" json:"Modules"`
+	// Format is console (the default) or json
+	Format string `yaml:"Format" json:"Format"`
+	// Outputs are where the log goes, stdout when empty
+	Outputs []*LogOutputCfg `yaml:"Outputs" json:"Outputs"`
+}
+
+// LogOutputCfg is a log destination which keeps the lines at Level or above, all of them when not set, in
+// Format, the one of the log by default. A file is rotated once
+// it grows beyond MaxSize bytes or has been written for MaxAge seconds, MaxBackups rotated files are kept.
+// A syslog output sends to Address over Network (unix, unixgram, udp or tcp), the local syslog when Address
+// is empty. A journald output sends to Address, the journal socket by default, and ignores Format.
+type LogOutputCfg struct {
+	Type   string         `yaml:"Type" json:"Type"`
+	Level  *zerolog.Level `yaml:"Level" json:"Level"`
+	Format string         `yaml:"Format" json:"Format"`
+
+	Path       string        `yaml:"Path" json:"Path"`
+	MaxSize    int64         `yaml:"MaxSize" json:"MaxSize"`
+	MaxAge     time.Duration `yaml:"MaxAge" json:"MaxAge"`
+	MaxBackups int           `yaml:"MaxBackups" json:"MaxBackups"`
+
+	Network  string `yaml:"Network" json:"Network"`
+	Address  string `yaml:"Address" json:"Address"`
+	Tag      string `yaml:"Tag" json:"Tag"`
+	Facility string `yaml:"Facility" json:"Facility"`
 }
@@ -1,40 +1,92 @@
 package config
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"github.com/mitchellh/mapstructure"
 	"github.com/rs/zerolog"
 
 	//"github.com/rs/zerolog"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
 	"net"
 	"os"
 	"reflect"
 )
 
+// Init reads the configuration file at path, with the settings overridden by BGPDNS_* environment variables.
+// Keys no setting is decoded from and values which do not parse are reported with their YAML path, the settings
+// themselves are checked by AppCfg.Validate.
 func Init(path string) (*AppCfg, error) {
-	viper.SetConfigFile(path)
-	viper.SetConfigType("yaml")
+	return InitWith(path, nil)
+}
+
+// InitWith is Init with the settings also overridden by the flags of fs defined by Flags. A flag takes
+// precedence over an environment variable, which takes precedence over the file.
+func InitWith(path string, fs *pflag.FlagSet) (*AppCfg, error) {
+	// each call gets its own viper instance, so several configurations can live in one process
+	v := viper.New()
+	v.SetConfigFile(path)
+	v.SetConfigType("yaml")
 
 	_, err := os.Stat(path)
 	if len(path) > 0 && err == nil {
-		viper.AddConfigPath(path)
+		v.AddConfigPath(path)
 	} else {
-		viper.AddConfigPath(".")
+		v.AddConfigPath(".")
 	}
 
-	if err = viper.ReadInConfig(); err != nil {
+	if err = v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("unable to read application configuration: %w", err)
+	}
+	b, err := os.ReadFile(v.ConfigFileUsed())
+	if err != nil {
 		return nil, fmt.Errorf("unable to read application configuration: %w", err)
 	}
+	var doc yaml.Node
+	if err = yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("unable to read application configuration: %w", err)
+	}
+	var errs []error
+	overrides := append(envOverrides(), flagOverrides(fs)...)
+	for i := range overrides {
+		if e := overrides[i].apply(&doc); e != nil {
+			errs = append(errs, e)
+		}
+	}
+	if errs = append(errs, checkKeys(&doc, reflect.TypeOf(AppCfg{}), "")...); len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	if len(overrides) > 0 {
+		if b, err = yaml.Marshal(&doc); err != nil {
+			return nil, fmt.Errorf("unable to apply configuration overrides: %w", err)
+		}
+		if err = v.ReadConfig(bytes.NewReader(b)); err != nil {
+			return nil, fmt.Errorf("unable to apply configuration overrides: %w", err)
+		}
+	}
+
 	var cfg = &AppCfg {}
 
-	if err = viper.Unmarshal(cfg, func(config *mapstructure.DecoderConfig) {
+	if err = v.Unmarshal(cfg, func(config *mapstructure.DecoderConfig) {
 		config.TagName = "json"
 		config.DecodeHook = mapstructure.ComposeDecodeHookFunc(func(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
 
 			if from.Kind() == reflect.String {
+				if to == reflect.TypeOf(ResolverCfg{}) {
+					return ResolverCfg{Url: data.(string)}, nil
+				}
+
 				if to == reflect.TypeOf(net.IP{}) {
-					return net.ParseIP(data.(string)), nil
+					if data.(string) == "" {
+						return net.IP(nil), nil
+					}
+					if ip := net.ParseIP(data.(string)); ip != nil {
+						return ip, nil
+					}
+					return nil, fmt.Errorf("invalid IP address %q", data)
 				}
 
 				if to == reflect.TypeOf(zerolog.DebugLevel) {
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// EnvPrefix starts the names of the environment variables overriding settings, BGPDNS_DNS_LISTEN_PORT
+	// overrides Dns.Listen.Port
+	EnvPrefix = "BGPDNS_"
+	// flagAnnotation marks the flags defined by Flags
+	flagAnnotation = "bgpdns-setting"
+)
+
+// Flags defines a flag for every setting on fs, named after its lowercased YAML path: --bgp.asn,
+// --dns.listen.port. A list is overridden as a whole, written in YAML flow style.
+func Flags(fs *pflag.FlagSet) {
+	settings(reflect.TypeOf(AppCfg{}), nil, func(path []string, t reflect.Type) {
+		name := strings.ToLower(strings.Join(path, "."))
+		usage := "Overrides " + strings.Join(path, ".")
+		if t.Kind() == reflect.Slice && t != ipType {
+			usage += ", a YAML list"
+		}
+		if t.Kind() == reflect.Map {
+			usage += ", a YAML mapping"
+		}
+		fs.String(name, "", usage)
+		_ = fs.SetAnnotation(name, flagAnnotation, path)
+	})
+}
+
+// settings calls fn with the YAML path and the type of every setting of t, lists included as a whole.
+func settings(t reflect.Type, path []string, fn func(path []string, t reflect.Type)) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		fn(path, t)
+		return
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		settings(f.Type, append(slices.Clip(path), keyOf(f)), fn)
+	}
+}
+
+// override is a setting overridden by an environment variable or a flag. The path is split at '_' or '.',
+// list items are addressed by their index: BGPDNS_BGP_PEERS_0_ADDRESS_IP.
+type override struct {
+	name  string
+	path  []string
+	value string
+}
+
+// envOverrides returns the overrides of the environment, sorted by name.
+func envOverrides() (r []override) {
+	for _, kv := range os.Environ() {
+		k, v, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(k, EnvPrefix) {
+			continue
+		}
+		r = append(r, override{name: k, path: strings.Split(strings.TrimPrefix(k, EnvPrefix), "_"), value: v})
+	}
+	slices.SortFunc(r, func(a, b override) int {
+		return strings.Compare(a.name, b.name)
+	})
+	return r
+}
+
+// flagOverrides returns the overrides of the flags Flags defined and which are set on fs.
+func flagOverrides(fs *pflag.FlagSet) (r []override) {
+	if fs == nil {
+		return nil
+	}
+	fs.Visit(func(f *pflag.Flag) {
+		if path, ok := f.Annotations[flagAnnotation]; ok {
+			r = append(r, override{name: "--" + f.Name, path: path, value: f.Value.String()})
+		}
+	})
+	return r
+}
+
+// apply sets the value of o in the YAML document doc, creating the keys and list items on its path. The value
+// is YAML itself, so a list or a mapping may be given in flow style.
+func (o *override) apply(doc *yaml.Node) error {
+	var v yaml.Node
+	if e := yaml.Unmarshal([]byte(o.value), &v); e != nil {
+		return fmt.Errorf("%s: %w", o.name, e)
+	}
+	value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null"}
+	if len(v.Content) > 0 {
+		value = v.Content[0]
+	}
+	// line numbers would point into the value rather than the file
+	forget(value)
+
+	if len(doc.Content) == 0 {
+		doc.Kind = yaml.DocumentNode
+		doc.Content = []*yaml.Node{{Kind: yaml.MappingNode}}
+	}
+	n, t := doc.Content[0], reflect.TypeOf(AppCfg{})
+	for _, key := range o.path {
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		if isNull(n) {
+			*n = yaml.Node{Kind: yaml.MappingNode}
+			if t.Kind() == reflect.Slice && t != ipType {
+				n.Kind = yaml.SequenceNode
+			}
+		}
+		switch {
+		case t.Kind() == reflect.Struct && n.Kind == yaml.MappingNode:
+			f, ok := field(t, key)
+			if !ok {
+				return fmt.Errorf("%s: %w", o.name, EUnknownKey)
+			}
+			n = child(n, t, f)
+			t = f.Type
+		case t.Kind() == reflect.Map && n.Kind == yaml.MappingNode:
+			n = entry(n, strings.ToLower(key))
+			t = t.Elem()
+		case t.Kind() == reflect.Slice && t != ipType && n.Kind == yaml.SequenceNode:
+			idx, e := strconv.Atoi(key)
+			if e != nil || idx < 0 || idx > len(n.Content) {
+				return fmt.Errorf("%s: %s is not an index of the %d items of the list", o.name, key, len(n.Content))
+			}
+			if idx == len(n.Content) {
+				n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null"})
+			}
+			n = n.Content[idx]
+			t = t.Elem()
+		default:
+			return fmt.Errorf("%s: %w", o.name, EUnknownKey)
+		}
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	// a string setting takes the value as is, a password of "yes" is not a boolean
+	if t.Kind() == reflect.String && value.Kind == yaml.ScalarNode && !isNull(value) {
+		value.Tag = "!!str"
+		value.Style = yaml.DoubleQuotedStyle
+	}
+	*n = *value
+
+	return nil
+}
+
+// child returns the value of the key of mapping n field f is decoded from, adding the key when missing.
+func child(n *yaml.Node, t reflect.Type, f reflect.StructField) *yaml.Node {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if g, ok := field(t, n.Content[i].Value); ok && g.Name == f.Name {
+			return n.Content[i+1]
+		}
+	}
+	v := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null"}
+	n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: keyOf(f)}, v)
+	return v
+}
+
+// entry returns the value of key in mapping n, adding the key when missing.
+func entry(n *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if strings.EqualFold(n.Content[i].Value, key) {
+			return n.Content[i+1]
+		}
+	}
+	v := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null"}
+	n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, v)
+	return v
+}
+
+func isNull(n *yaml.Node) bool {
+	return n.Kind == 0 || n.Kind == yaml.ScalarNode && n.Tag == "!!null"
+}
+
+func forget(n *yaml.Node) {
+	n.Line, n.Column = 0, 0
+	for _, c := range n.Content {
+		forget(c)
+	}
+}
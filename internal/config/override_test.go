@@ -0,0 +1,124 @@
+package config
+
+import (
+	"errors"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/pflag"
+)
+
+// initWith reads the configuration with the flags of args set.
+func initWith(t *testing.T, y string, args ...string) (*AppCfg, error) {
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	Flags(fs)
+	if e := fs.Parse(args); e != nil {
+		t.Fatal(e)
+	}
+	return InitWith(write(t, y), fs)
+}
+
+func TestOverridePrecedence(t *testing.T) {
+	tests := []struct {
+		name string
+		env  bool
+		args []string
+		want uint32
+	}{
+		{"file", false, nil, 65530},
+		{"environment over file", true, nil, 65540},
+		{"flag over file", false, []string{"--bgp.asn=65550"}, 65550},
+		{"flag over environment", true, []string{"--bgp.asn=65550"}, 65550},
+		{"flag of another setting", true, []string{"--bgp.listen.port=1179"}, 65540},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env {
+				t.Setenv("BGPDNS_BGP_ASN", "65540")
+			}
+			c, e := initWith(t, validConfig, tt.args...)
+			if e != nil {
+				t.Fatal(e)
+			}
+			if c.Bgp.Asn != tt.want {
+				t.Errorf("Bgp.Asn is %d, want %d", c.Bgp.Asn, tt.want)
+			}
+			// what is not overridden is read from the file
+			if c.Bgp.Peers[0].Asn != 65531 || c.Dns.Cache.MaxEntries != 1024 {
+				t.Errorf("settings not overridden changed: %+v %+v", c.Bgp.Peers[0], c.Dns.Cache)
+			}
+		})
+	}
+}
+
+func TestOverrideNested(t *testing.T) {
+	t.Setenv("BGPDNS_BGP_PEERS_0_ADDRESS_IP", "192.0.2.9")
+	t.Setenv("BGPDNS_BGP_PEERS_0_PASSWORD", "yes")
+	t.Setenv("BGPDNS_BGP_PEERS_1_ASN", "65532")
+	t.Setenv("BGPDNS_BGP_PEERS_1_ADDRESS_IP", "192.0.2.3")
+	t.Setenv("BGPDNS_DNS_CACHE_MAXENTRIES", "16")
+	t.Setenv("BGPDNS_LOG_MODULES_BGP", "debug")
+	t.Setenv("BGPDNS_BGP_GRACEFULRESTART_RESTARTTIME", "120")
+	c, e := initWith(t, validConfig, "--bgp.communities=[65000:1, 65000:2]", "--dns.listen.port=5353")
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if len(c.Bgp.Peers) != 2 {
+		t.Fatalf("%d peers, want the one of the file and one added", len(c.Bgp.Peers))
+	}
+	// the item of the file keeps the settings not overridden
+	if p := c.Bgp.Peers[0]; !p.Addr.IP.Equal(net.ParseIP("192.0.2.9")) || p.Addr.Port != 179 || p.Asn != 65531 {
+		t.Errorf("peer 0 is %+v", p)
+	}
+	// a string setting is not taken for a boolean
+	if p := c.Bgp.Peers[0]; p.Password != "yes" {
+		t.Errorf("Bgp.Peers[0].Password is %q", p.Password)
+	}
+	if p := c.Bgp.Peers[1]; !p.Addr.IP.Equal(net.ParseIP("192.0.2.3")) || p.Asn != 65532 {
+		t.Errorf("peer 1 is %+v", p)
+	}
+	if c.Dns.Cache.MaxEntries != 16 {
+		t.Errorf("Dns.Cache.MaxEntries is %d", c.Dns.Cache.MaxEntries)
+	}
+	if c.Dns.Listen.Port != 5353 || !c.Dns.Listen.IP.Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("Dns.Listen is %s", c.Dns.Listen)
+	}
+	if l, ok := c.Log.Modules["bgp"]; !ok || l != zerolog.DebugLevel {
+		t.Errorf("Log.Modules is %v", c.Log.Modules)
+	}
+	if g := c.Bgp.GracefulRestart; g == nil || g.RestartTime != 120 {
+		t.Errorf("Bgp.GracefulRestart is %+v", g)
+	}
+	if !slices.Equal(c.Bgp.Communities, []string{"65000:1", "65000:2"}) {
+		t.Errorf("Bgp.Communities is %v", c.Bgp.Communities)
+	}
+}
+
+func TestOverrideInvalid(t *testing.T) {
+	tests := []struct {
+		name, env, value string
+		want             string
+	}{
+		{"unknown key", "BGPDNS_BGP_NOSUCH", "1", "BGPDNS_BGP_NOSUCH: unknown key"},
+		{"key below a setting", "BGPDNS_BGP_ASN_VALUE", "1", "BGPDNS_BGP_ASN_VALUE: unknown key"},
+		{"index past the end", "BGPDNS_BGP_PEERS_2_ASN", "1",
+			"BGPDNS_BGP_PEERS_2_ASN: 2 is not an index of the 1 items of the list"},
+		{"not YAML", "BGPDNS_BGP_COMMUNITIES", "[65000:1", "BGPDNS_BGP_COMMUNITIES: "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(tt.env, tt.value)
+			_, e := initWith(t, validConfig)
+			if e == nil || !strings.Contains(e.Error(), tt.want) {
+				t.Errorf("got %v, want %q", e, tt.want)
+			}
+			if strings.HasSuffix(tt.want, "unknown key") && !errors.Is(e, EUnknownKey) {
+				t.Errorf("%v is not %v", e, EUnknownKey)
+			}
+		})
+	}
+}
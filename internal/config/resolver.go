@@ -0,0 +1,97 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ResolverCfg is an upstream DNS resolver. It is written either as {Ip, Port} for plain UDP or as a URL:
+// udp://host:53, tcp://host:53, tls://host:853 or https://host/dns-query. Ip overrides the address
+// dialed for a named host, ServerName the TLS server name and CaFile the bundle used to verify it.
+type ResolverCfg struct {
+	Ip         net.IP `yaml:"Ip" json:"Ip"`
+	Port       int    `yaml:"Port" json:"Port"`
+	Url        string `yaml:"Url" json:"Url"`
+	ServerName string `yaml:"ServerName" json:"ServerName"`
+	CaFile     string `yaml:"CaFile" json:"CaFile"`
+}
+
+const (
+	SchemeUdp   = "udp"
+	SchemeTcp   = "tcp"
+	SchemeTls   = "tls"
+	SchemeHttps = "https"
+)
+
+var defaultPorts = map[string]int{
+	SchemeUdp:   53,
+	SchemeTcp:   53,
+	SchemeTls:   853,
+	SchemeHttps: 443,
+}
+
+// Endpoint returns the transport of the resolver and the address to dial, which is the URL itself for https.
+func (r *ResolverCfg) Endpoint() (scheme string, addr string, e error) {
+	if r.Url == "" {
+		if r.Ip == nil {
+			return "", "", fmt.Errorf("resolver has neither Ip nor Url")
+		}
+		port := r.Port
+		if port == 0 {
+			port = defaultPorts[SchemeUdp]
+		}
+		return SchemeUdp, net.JoinHostPort(r.Ip.String(), strconv.Itoa(port)), nil
+	}
+
+	raw := r.Url
+	if !strings.Contains(raw, "://") {
+		raw = SchemeUdp + "://" + raw
+	}
+	u, e := url.Parse(raw)
+	if e != nil {
+		return "", "", fmt.Errorf("invalid resolver %s: %w", r.Url, e)
+	}
+	scheme = strings.ToLower(u.Scheme)
+	if _, ok := defaultPorts[scheme]; !ok {
+		return "", "", fmt.Errorf("invalid resolver %s: unsupported scheme %s", r.Url, u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return "", "", fmt.Errorf("invalid resolver %s: host is empty", r.Url)
+	}
+	if scheme == SchemeHttps {
+		return scheme, u.String(), nil
+	}
+
+	host, port := u.Hostname(), u.Port()
+	if r.Ip != nil {
+		host = r.Ip.String()
+	}
+	if port == "" {
+		port = strconv.Itoa(defaultPorts[scheme])
+	}
+	return scheme, net.JoinHostPort(host, port), nil
+}
+
+// TlsServerName returns the name used for SNI and certificate verification.
+func (r *ResolverCfg) TlsServerName() string {
+	if r.ServerName != "" {
+		return r.ServerName
+	}
+	if u, e := url.Parse(r.Url); e == nil {
+		return u.Hostname()
+	}
+	return ""
+}
+
+func (r *ResolverCfg) String() string {
+	if r.Url != "" {
+		return r.Url
+	}
+	if _, addr, e := r.Endpoint(); e == nil {
+		return addr
+	}
+	return "<invalid>"
+}
@@ -0,0 +1,394 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/red55/bgp-dns/internal/utils"
+)
+
+const (
+	// asTrans stands for a 4-byte ASN towards 2-byte speakers (RFC 6793) and is never a real one
+	asTrans = 23456
+	// the last 2-byte and 4-byte ASNs are reserved (RFC 7300)
+	asLast16 = 1<<16 - 1
+	asLast32 = 1<<32 - 1
+	// maxRestartTime and maxLongLivedStaleTime are the widths of the graceful restart capability fields
+	maxRestartTime        = 1<<12 - 1
+	maxLongLivedStaleTime = 1<<24 - 1
+)
+
+// problems collects the errors of a validation pass, each prefixed with the YAML path of the setting.
+type problems []error
+
+func (p *problems) add(path string, format string, v ...interface{}) {
+	*p = append(*p, fmt.Errorf("%s: %s", path, fmt.Sprintf(format, v...)))
+}
+
+// Validate checks the settings which would otherwise only fail once applied, so a bad configuration is
+// rejected before anything is started or reloaded. Every problem is reported with the YAML path of the setting.
+func (c *AppCfg) Validate() error {
+	var p problems
+	c.Log.validate(&p)
+	c.Bgp.validate(&p)
+	c.Dns.validate(&p)
+	if c.Admin.Listen != nil {
+		checkListen(&p, "Admin.Listen", c.Admin.Listen.IP, c.Admin.Listen.Port)
+	}
+	if c.Admin.Socket != "" {
+		checkDir(&p, "Admin.Socket", c.Admin.Socket)
+	}
+	return errors.Join(p...)
+}
+
+func (c *LogCfg) validate(p *problems) {
+	checkFormat(p, "Log.Format", c.Format)
+	for m := range c.Modules {
+		if !slices.Contains(LogModules, strings.ToLower(m)) {
+			p.add("Log.Modules."+m, "unknown module, one of %s", strings.Join(LogModules, ", "))
+		}
+	}
+	for i, o := range c.Outputs {
+		path := fmt.Sprintf("Log.Outputs[%d]", i)
+		if o == nil {
+			p.add(path, "empty")
+			continue
+		}
+		checkFormat(p, path+".Format", o.Format)
+		switch o.Type {
+		case LogOutputStdout, LogOutputStderr, LogOutputJournald:
+		case LogOutputFile:
+			if o.Path == "" {
+				p.add(path+".Path", "not set")
+			} else {
+				checkDir(p, path+".Path", o.Path)
+			}
+			if o.MaxSize < 0 {
+				p.add(path+".MaxSize", "%d is negative", o.MaxSize)
+			}
+			if o.MaxAge < 0 {
+				p.add(path+".MaxAge", "%d is negative", o.MaxAge)
+			}
+			if o.MaxBackups < 0 {
+				p.add(path+".MaxBackups", "%d is negative", o.MaxBackups)
+			}
+		case LogOutputSyslog:
+			switch o.Network {
+			case "", "unix", "unixgram", "udp", "tcp":
+			default:
+				p.add(path+".Network", "%s is not one of unix, unixgram, udp or tcp", o.Network)
+			}
+			if o.Network != "" && o.Address == "" {
+				p.add(path+".Address", "not set")
+			}
+			if o.Network == "" && o.Address != "" {
+				p.add(path+".Network", "not set")
+			}
+			if _, ok := SyslogFacilities[o.Facility]; o.Facility != "" && !ok {
+				p.add(path+".Facility", "%s is not a syslog facility", o.Facility)
+			}
+		case "":
+			p.add(path+".Type", "not set")
+		default:
+			p.add(path+".Type", "%s is not one of stdout, stderr, file, syslog or journald", o.Type)
+		}
+	}
+}
+
+func checkFormat(p *problems, path string, format string) {
+	switch format {
+	case "", LogFormatConsole, LogFormatJson:
+	default:
+		p.add(path, "%s is neither %s nor %s", format, LogFormatConsole, LogFormatJson)
+	}
+}
+
+func (c *BgpCfg) validate(p *problems) {
+	// a file caught half written parses as well, these are never left out on purpose
+	checkAsn(p, "Bgp.Asn", c.Asn)
+	switch {
+	case c.Id == nil:
+		p.add("Bgp.Id", "not set")
+	case c.Id.To4() == nil:
+		p.add("Bgp.Id", "router ID %s is not an IPv4 address", c.Id)
+	case c.Id.IsUnspecified(), c.Id.IsMulticast(), c.Id.Equal(net.IPv4bcast):
+		p.add("Bgp.Id", "router ID %s is not a valid one", c.Id)
+	}
+	if c.Listen.IP == nil {
+		p.add("Bgp.Listen.Ip", "not set")
+	}
+	// -1 keeps the speaker from listening, 0 is the BGP port
+	if c.Listen.Port < -1 || c.Listen.Port > 65535 {
+		p.add("Bgp.Listen.Port", "%d is not a port", c.Listen.Port)
+	}
+	if c.NextHop6 != nil && c.NextHop6.To4() != nil {
+		p.add("Bgp.NextHop6", "%s is not an IPv6 address", c.NextHop6)
+	}
+	if _, e := utils.NormalizeCommunities(c.Communities); e != nil {
+		p.add("Bgp.Communities", "%v", e)
+	}
+	if c.ReconcileInterval < 0 {
+		p.add("Bgp.ReconcileInterval", "%d is negative", c.ReconcileInterval)
+	}
+	if a := c.Aggregation; a != nil {
+		if a.MinPrefixLen < 0 || a.MinPrefixLen > 32 {
+			p.add("Bgp.Aggregation.MinPrefixLen", "%d is out of range 0..32", a.MinPrefixLen)
+		}
+		if a.MinPrefixLen6 < 0 || a.MinPrefixLen6 > 128 {
+			p.add("Bgp.Aggregation.MinPrefixLen6", "%d is out of range 0..128", a.MinPrefixLen6)
+		}
+		if a.Density < 0 || a.Density > 1 {
+			p.add("Bgp.Aggregation.Density", "%g is out of range 0..1", a.Density)
+		}
+	}
+	if g := c.GracefulRestart; g != nil {
+		if g.RestartTime < 0 || g.RestartTime > maxRestartTime {
+			p.add("Bgp.GracefulRestart.RestartTime", "%d is out of range 0..%d", g.RestartTime, maxRestartTime)
+		}
+		if g.StaleRoutesTime < 0 {
+			p.add("Bgp.GracefulRestart.StaleRoutesTime", "%d is negative", g.StaleRoutesTime)
+		}
+		if g.LongLivedStaleTime < 0 || g.LongLivedStaleTime > maxLongLivedStaleTime {
+			p.add("Bgp.GracefulRestart.LongLivedStaleTime", "%d is out of range 0..%d", g.LongLivedStaleTime,
+				maxLongLivedStaleTime)
+		}
+	}
+
+	if len(c.Peers) == 0 {
+		p.add("Bgp.Peers", "no peers configured")
+	}
+	seen := make(map[string]string, len(c.Peers))
+	for i, peer := range c.Peers {
+		path := fmt.Sprintf("Bgp.Peers[%d]", i)
+		if peer == nil {
+			p.add(path, "empty")
+			continue
+		}
+		checkAsn(p, path+".Asn", peer.Asn)
+		peer.validate(p, path, c)
+
+		if peer.Addr.IP == nil {
+			continue
+		}
+		if a := peer.Addr.IP.String(); seen[a] != "" {
+			p.add(path+".Address.Ip", "peer %s is already configured as %s", a, seen[a])
+		} else {
+			seen[a] = path
+		}
+	}
+}
+
+func (n *BgpNeighbor) validate(p *problems, path string, c *BgpCfg) {
+	switch {
+	case n.Addr.IP == nil:
+		p.add(path+".Address.Ip", "not set")
+	case n.Addr.IP.Equal(c.Id):
+		p.add(path+".Address.Ip", "%s is the router ID of this speaker (Bgp.Id)", n.Addr.IP)
+	case n.Addr.IP.Equal(c.Listen.IP) && !c.Listen.IP.IsUnspecified():
+		p.add(path+".Address.Ip", "%s is the listen address of this speaker (Bgp.Listen.Ip)", n.Addr.IP)
+	}
+	// the IPv6 prefixes carry Bgp.NextHop6 in the RIB, a peer NextHop6 only replaces it on the way out
+	if n.Ipv6 && c.NextHop6 == nil {
+		p.add(path+".Ipv6", "IPv6 is enabled and Bgp.NextHop6 is not set")
+	}
+	if n.Addr.Port < 0 || n.Addr.Port > 65535 {
+		p.add(path+".Address.Port", "%d is not a port", n.Addr.Port)
+	}
+	if n.LocalPref != nil && n.Asn != c.Asn {
+		p.add(path+".LocalPref", "sent to iBGP peers only, Asn %d is not Bgp.Asn %d", n.Asn, c.Asn)
+	}
+	if n.NextHop != nil && n.NextHop.To4() == nil {
+		p.add(path+".NextHop", "%s is not an IPv4 address", n.NextHop)
+	}
+	if n.NextHop6 != nil && n.NextHop6.To4() != nil {
+		p.add(path+".NextHop6", "%s is not an IPv6 address", n.NextHop6)
+	}
+	if n.LocalAddress != nil && n.Addr.IP != nil && (n.LocalAddress.To4() == nil) != (n.Addr.IP.To4() == nil) {
+		p.add(path+".LocalAddress", "%s and the peer address %s are of different families", n.LocalAddress,
+			n.Addr.IP)
+	}
+	if n.TtlMin > 255 {
+		p.add(path+".TtlMin", "%d is out of range 0..255", n.TtlMin)
+	}
+	if n.MultihopTtl > 255 {
+		p.add(path+".MultihopTtl", "%d is out of range 0..255", n.MultihopTtl)
+	}
+	// RFC 4271: the hold time is either zero or at least three seconds
+	if n.HoldTime < 0 || n.HoldTime > 65535 || n.HoldTime == 1 || n.HoldTime == 2 {
+		p.add(path+".HoldTime", "%d is neither 0 nor in range 3..65535", n.HoldTime)
+	}
+}
+
+func checkAsn(p *problems, path string, asn uint32) {
+	switch asn {
+	case 0:
+		p.add(path, "not set")
+	case asTrans:
+		p.add(path, "%d is AS_TRANS, which is reserved", asn)
+	case asLast16, asLast32:
+		p.add(path, "%d is reserved", asn)
+	}
+}
+
+// checkListen checks the address a server binds to, port 0 is not allowed as clients could not find it.
+func checkListen(p *problems, path string, ip net.IP, port int) {
+	if ip == nil {
+		p.add(path+".Ip", "not set")
+	}
+	if port <= 0 || port > 65535 {
+		p.add(path+".Port", "%d is not a port", port)
+	}
+}
+
+func (c *DnsCfg) validate(p *problems) {
+	if c.Listen == nil {
+		p.add("Dns.Listen", "not set")
+	} else {
+		checkListen(p, "Dns.Listen", c.Listen.IP, c.Listen.Port)
+	}
+	if len(c.Resolvers) == 0 {
+		p.add("Dns.Resolvers", "no resolvers configured")
+	}
+	checkResolvers(p, "Dns.Resolvers", c.Resolvers)
+	if c.Cache.MaxEntries <= 0 {
+		p.add("Dns.Cache.MaxEntries", "%d is not positive", c.Cache.MaxEntries)
+	}
+	if c.Cache.MinTtl < 0 {
+		p.add("Dns.Cache.MinTtl", "%d is negative", c.Cache.MinTtl)
+	}
+	if c.Cache.StateInterval < 0 {
+		p.add("Dns.Cache.StateInterval", "%d is negative", c.Cache.StateInterval)
+	}
+	if fn := c.Cache.StateFile; fn != "" {
+		checkDir(p, "Dns.Cache.StateFile", fn)
+	}
+	if c.Tls != nil {
+		c.Tls.validate(p, "Dns.Tls")
+	}
+	if c.Https != nil {
+		c.Https.validate(p, "Dns.Https")
+		if c.Https.Path != "" && !strings.HasPrefix(c.Https.Path, "/") {
+			p.add("Dns.Https.Path", "%s does not start with /", c.Https.Path)
+		}
+	}
+
+	seen := make(map[string]string)
+	check := func(path string, l *ListCfg) {
+		if l.File == "" {
+			if l.Url != "" {
+				p.add(path+".File", "not set, list %s has no file to keep the downloaded copy in", l.Url)
+			} else {
+				p.add(path+".File", "not set")
+			}
+			return
+		}
+		l.validate(p, path)
+		if fn := filepath.Clean(l.File); seen[fn] != "" {
+			p.add(path+".File", "%s is already configured as %s", l.File, seen[fn])
+		} else {
+			seen[fn] = path
+		}
+	}
+	if c.List.File != "" || c.List.Url != "" {
+		check("Dns.List", &c.List)
+	} else {
+		// Dns.List may only hold the resolvers of the other lists
+		checkResolvers(p, "Dns.List.Resolvers", c.List.Resolvers)
+	}
+	for i, l := range c.Lists {
+		path := fmt.Sprintf("Dns.Lists[%d]", i)
+		if l == nil {
+			p.add(path, "empty")
+			continue
+		}
+		check(path, l)
+	}
+}
+
+func (l *ListCfg) validate(p *problems, path string) {
+	checkResolvers(p, path+".Resolvers", l.Resolvers)
+	if _, e := utils.NormalizeCommunities(l.Communities); e != nil {
+		p.add(path+".Communities", "%v", e)
+	}
+	if l.CaFile != "" {
+		checkFile(p, path+".CaFile", l.CaFile)
+	}
+	if l.Url == "" {
+		checkFile(p, path+".File", l.File)
+		return
+	}
+	// the file of a remote list is written once downloaded, its directory has to be there
+	checkDir(p, path+".File", l.File)
+	if u, e := url.Parse(l.Url); e != nil {
+		p.add(path+".Url", "%v", e)
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		p.add(path+".Url", "%s is not an http(s) URL", l.Url)
+	}
+	if l.Refresh < 0 {
+		p.add(path+".Refresh", "%d is negative", l.Refresh)
+	}
+	if l.MaxSize < 0 {
+		p.add(path+".MaxSize", "%d is negative", l.MaxSize)
+	}
+}
+
+func (c *EncryptedListenCfg) validate(p *problems, path string) {
+	if c.Listen == nil {
+		p.add(path+".Listen", "not set")
+	} else {
+		checkListen(p, path+".Listen", c.Listen.IP, c.Listen.Port)
+	}
+	for _, f := range []struct{ key, fn string }{{"CertFile", c.CertFile}, {"KeyFile", c.KeyFile}} {
+		if f.fn == "" {
+			p.add(path+"."+f.key, "not set")
+		} else {
+			checkFile(p, path+"."+f.key, f.fn)
+		}
+	}
+}
+
+func checkResolvers(p *problems, path string, rs []*ResolverCfg) {
+	for i, r := range rs {
+		rp := fmt.Sprintf("%s[%d]", path, i)
+		if r == nil {
+			p.add(rp, "empty")
+			continue
+		}
+		if _, _, e := r.Endpoint(); e != nil {
+			p.add(rp, "%v", e)
+		}
+		if r.Port < 0 || r.Port > 65535 {
+			p.add(rp+".Port", "%d is not a port", r.Port)
+		}
+		if r.CaFile != "" {
+			checkFile(p, rp+".CaFile", r.CaFile)
+		}
+	}
+}
+
+// checkFile reports a file, or a directory, which is not there.
+func checkFile(p *problems, path string, fn string) {
+	if _, e := os.Stat(fn); errors.Is(e, os.ErrNotExist) {
+		p.add(path, "%s does not exist", fn)
+	} else if e != nil {
+		p.add(path, "%v", e)
+	}
+}
+
+// checkDir reports a file which cannot be created because its directory is not there.
+func checkDir(p *problems, path string, fn string) {
+	dir := filepath.Dir(fn)
+	if inf, e := os.Stat(dir); errors.Is(e, os.ErrNotExist) {
+		p.add(path, "directory %s of %s does not exist", dir, fn)
+	} else if e != nil {
+		p.add(path, "%v", e)
+	} else if !inf.IsDir() {
+		p.add(path, "%s of %s is not a directory", dir, fn)
+	}
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// validConfig is a configuration Validate accepts, the list file lives in dir.
+const validConfig = `
+Bgp:
+  Asn: 65530
+  Id: 192.0.2.1
+  Listen: { Ip: 192.0.2.1, Port: 179 }
+  Peers:
+    - Asn: 65531
+      Address: { Ip: 192.0.2.2, Port: 179 }
+Dns:
+  Listen: { Ip: 127.0.0.1, Port: 53 }
+  Resolvers:
+    - { Ip: 192.0.2.53, Port: 53 }
+  Cache: { MaxEntries: 1024 }
+  Lists:
+    - File: {{dir}}/a.lst
+`
+
+// write writes the configuration, with {{dir}} replaced by a temporary directory holding a.lst, and returns
+// its file name.
+func write(t *testing.T, y string) string {
+	dir := t.TempDir()
+	if e := os.WriteFile(filepath.Join(dir, "a.lst"), nil, 0o644); e != nil {
+		t.Fatal(e)
+	}
+	fn := filepath.Join(dir, "config.yaml")
+	if e := os.WriteFile(fn, []byte(strings.ReplaceAll(y, "{{dir}}", dir)), 0o644); e != nil {
+		t.Fatal(e)
+	}
+	return fn
+}
+
+// load writes the configuration and reads it.
+func load(t *testing.T, y string) *AppCfg {
+	cfg, e := Init(write(t, y))
+	if e != nil {
+		t.Fatal(e)
+	}
+	return cfg
+}
+
+func TestValidate(t *testing.T) {
+	if e := load(t, validConfig).Validate(); e != nil {
+		t.Fatalf("a valid configuration was rejected: %v", e)
+	}
+}
+
+func TestValidateProblems(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *AppCfg)
+		want   []string
+	}{
+		{"ASN not set", func(c *AppCfg) { c.Bgp.Asn = 0 }, []string{"Bgp.Asn: not set"}},
+		{"reserved ASNs", func(c *AppCfg) {
+			c.Bgp.Asn = 23456
+			c.Bgp.Peers[0].Asn = 4294967295
+		}, []string{"Bgp.Asn: 23456 is AS_TRANS, which is reserved", "Bgp.Peers[0].Asn: 4294967295 is reserved"}},
+		{"router ID not set", func(c *AppCfg) { c.Bgp.Id = nil }, []string{"Bgp.Id: not set"}},
+		{"IPv6 router ID", func(c *AppCfg) { c.Bgp.Id = net.ParseIP("2001:db8::1") },
+			[]string{"Bgp.Id: router ID 2001:db8::1 is not an IPv4 address"}},
+		{"unspecified router ID", func(c *AppCfg) { c.Bgp.Id = net.IPv4zero },
+			[]string{"Bgp.Id: router ID 0.0.0.0 is not a valid one"}},
+		{"multicast router ID", func(c *AppCfg) { c.Bgp.Id = net.ParseIP("224.0.0.1") },
+			[]string{"Bgp.Id: router ID 224.0.0.1 is not a valid one"}},
+		{"peer at the router ID", func(c *AppCfg) {
+			c.Bgp.Listen.IP = net.IPv4zero
+			c.Bgp.Peers[0].Addr.IP = net.ParseIP("192.0.2.1")
+		}, []string{"Bgp.Peers[0].Address.Ip: 192.0.2.1 is the router ID of this speaker (Bgp.Id)"}},
+		{"peer at the listen address", func(c *AppCfg) {
+			c.Bgp.Listen.IP = net.ParseIP("192.0.2.9")
+			c.Bgp.Peers[0].Addr.IP = net.ParseIP("192.0.2.9")
+		}, []string{"Bgp.Peers[0].Address.Ip: 192.0.2.9 is the listen address of this speaker (Bgp.Listen.Ip)"}},
+		{"LocalPref to an eBGP peer", func(c *AppCfg) {
+			lp := uint32(100)
+			c.Bgp.Peers[0].LocalPref = &lp
+		}, []string{"Bgp.Peers[0].LocalPref: sent to iBGP peers only, Asn 65531 is not Bgp.Asn 65530"}},
+		{"IPv6 without a next hop", func(c *AppCfg) { c.Bgp.Peers[0].Ipv6 = true },
+			[]string{"Bgp.Peers[0].Ipv6: IPv6 is enabled and Bgp.NextHop6 is not set"}},
+		{"IPv6 with a peer next hop only", func(c *AppCfg) {
+			c.Bgp.Peers[0].Ipv6 = true
+			c.Bgp.Peers[0].NextHop6 = net.ParseIP("2001:db8::1")
+		}, []string{"Bgp.Peers[0].Ipv6: IPv6 is enabled and Bgp.NextHop6 is not set"}},
+		{"IPv4 next hops for IPv6", func(c *AppCfg) {
+			c.Bgp.NextHop6 = net.ParseIP("192.0.2.1")
+			c.Bgp.Peers[0].NextHop6 = net.ParseIP("192.0.2.1")
+		}, []string{"Bgp.NextHop6: 192.0.2.1 is not an IPv6 address",
+			"Bgp.Peers[0].NextHop6: 192.0.2.1 is not an IPv6 address"}},
+		{"no peers", func(c *AppCfg) { c.Bgp.Peers = nil }, []string{"Bgp.Peers: no peers configured"}},
+		{"duplicate peer", func(c *AppCfg) {
+			c.Bgp.Peers = append(c.Bgp.Peers, &BgpNeighbor{Asn: 65532, Addr: c.Bgp.Peers[0].Addr})
+		}, []string{"Bgp.Peers[1].Address.Ip: peer 192.0.2.2 is already configured as Bgp.Peers[0]"}},
+		{"ports", func(c *AppCfg) {
+			c.Bgp.Listen.Port = 65536
+			c.Bgp.Peers[0].Addr.Port = -1
+			c.Dns.Listen.Port = 0
+		}, []string{"Bgp.Listen.Port: 65536 is not a port", "Bgp.Peers[0].Address.Port: -1 is not a port",
+			"Dns.Listen.Port: 0 is not a port"}},
+		{"hold time", func(c *AppCfg) { c.Bgp.Peers[0].HoldTime = 2 },
+			[]string{"Bgp.Peers[0].HoldTime: 2 is neither 0 nor in range 3..65535"}},
+		{"no resolvers", func(c *AppCfg) { c.Dns.Resolvers = nil }, []string{"Dns.Resolvers: no resolvers configured"}},
+		{"missing list file", func(c *AppCfg) { c.Dns.Lists[0].File = filepath.Join(t.TempDir(), "b.lst") },
+			[]string{"Dns.Lists[0].File: ", "b.lst does not exist"}},
+		{"bad community", func(c *AppCfg) { c.Bgp.Communities = []string{"65536:1"} },
+			[]string{"Bgp.Communities: "}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := load(t, validConfig)
+			tt.modify(c)
+			e := c.Validate()
+			if e == nil {
+				t.Fatal("the configuration was accepted")
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(e.Error(), w) {
+					t.Errorf("%q is not reported in:\n%v", w, e)
+				}
+			}
+		})
+	}
+}
@@ -8,12 +8,13 @@ import (
 	"github.com/beevik/prefixtree/v2"
 	"github.com/bluele/gcache"
 	"github.com/miekg/dns"
-	"github.com/red55/bgp-dns/internal/bgp"
 	"github.com/red55/bgp-dns/internal/log"
 	"github.com/red55/bgp-dns/internal/loop"
+	"github.com/red55/bgp-dns/internal/metrics"
 	"github.com/red55/bgp-dns/internal/utils"
-	"github.com/rs/zerolog"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -28,32 +29,118 @@ type cache struct {
 	m sync.RWMutex
 	wg sync.WaitGroup
 	pref entries
-	entries gcache.Cache
+	// store holds the gcache.Cache of the entries, replaced when MaxEntries changes
+	store atomic.Value
+	max int
 	cancel context.CancelFunc
 	rs *resolvers
-	minTtl time.Duration
+	mux *dns.ServeMux
+	bgp Announcer
+	metrics *metrics.Metrics
+	// failures are the holders Advance or Withdraw failed for
+	failures *failures
+	// minTtl is seconds
+	minTtl atomic.Int64
 	gen 	atomic.Uint64
+	rules   map[string]*rule
+	// restored are the entries read from the state file by rule, waiting for the rule to be registered, and
+	// registered the rules which were registered through the API
+	restored   map[string]map[string]*cacheEntry
+	registered []string
 }
 
-func newCache(max int, minTtl time.Duration, rs *resolvers, l *zerolog.Logger) (r *cache) {
+func newCache(max int, minTtl time.Duration, rs *resolvers, mux *dns.ServeMux, bgp Announcer, m *metrics.Metrics,
+	logs *log.Logs) (r *cache) {
 	r = &cache{
-		Loop: loop.NewLoop(1),
-		Log: log.NewLog(l, "dns"),
+		Loop: loop.NewLoop(1, logs),
+		Log: logs.NewLog("dns"),
 		pref: prefixtree.New[cacheEntry](),
 		cancel: nil,
 		rs:     rs,
-		minTtl: minTtl,
+		mux:    mux,
+		bgp:    bgp,
+		metrics: m,
+		failures: newFailures(),
 		gen:    atomic.Uint64{},
+		rules:  make(map[string]*rule),
 	}
-	r.entries = gcache.New(max).LFU().EvictedFunc(r.onEntryEvicted).Build()
+	r.minTtl.Store(int64(minTtl))
+	r.max = max
+	r.store.Store(r.newStore(max))
+	m.SetCacheSize(func() int {
+		return r.entries().Len(false)
+	})
 
 	return
 }
 
+func (c *cache) newStore(max int) gcache.Cache {
+	return gcache.New(max).LFU().EvictedFunc(c.onEntryEvicted).Build()
+}
+
+func (c *cache) entries() gcache.Cache {
+	return c.store.Load().(gcache.Cache)
+}
+
+// ttlFloor returns MinTtl in seconds.
+func (c *cache) ttlFloor() time.Duration {
+	return time.Duration(c.minTtl.Load())
+}
+
+// setLimits applies new MaxEntries and MinTtl. Entries are moved into a cache of the new size, the least
+// frequently used ones beyond it are evicted and withdrawn; entries written while they are being moved are
+// copied in a second pass. Called by one goroutine at a time.
+func (c *cache) setLimits(max int, minTtl time.Duration) {
+	c.minTtl.Store(int64(minTtl))
+	if max == c.max {
+		return
+	}
+	c.L().Info().Msgf("Resizing cache from %d to %d entries", c.max, max)
+	c.max = max
+
+	old := c.entries()
+	nc := c.newStore(max)
+	moved := old.GetALL(false)
+	for k, v := range moved {
+		_ = nc.Set(k, v)
+	}
+	c.store.Store(nc)
+	for k, v := range old.GetALL(false) {
+		if m, ok := moved[k]; !ok || m != v {
+			_ = nc.Set(k, v)
+		}
+	}
+}
+
 func (c *cache) onEntryEvicted(k interface{}, v interface{}) {
 	c.L().Debug().Msgf("Evicting %s", k.(string))
-	if e := bgp.Withdraw(v.(*cacheEntry).Ip4s()); e != nil {
-		c.L().Error().Err(e).Msgf("Failed to withdraw IPs for %s", k.(string))
+	c.metrics.CacheEvictions.Inc()
+	ce := v.(*cacheEntry)
+	c.withdraw(dns.CanonicalName(k.(string)), ce.Ips(), ce.communities)
+}
+
+// advance passes the addresses of holder on to the announcer, if any. A failure is logged, counted and marked for
+// the reconciler, which corrects the prefixes of holder right away.
+func (c *cache) advance(holder string, ips []string, communities []string) {
+	if len(ips) == 0 {
+		return
+	}
+	if e := c.bgp.Advance(holder, ips, communities); e != nil {
+		c.L().Error().Err(e).Msgf("Failed to announce %v for %s, reconciling", ips, holder)
+		c.metrics.AnnounceFailures.WithLabelValues("advance").Inc()
+		c.failures.add(holder)
+	}
+}
+
+// withdraw is advance for the addresses holder lets go of.
+func (c *cache) withdraw(holder string, ips []string, communities []string) {
+	if len(ips) == 0 {
+		return
+	}
+	if e := c.bgp.Withdraw(holder, ips, communities); e != nil {
+		c.L().Error().Err(e).Msgf("Failed to withdraw %v for %s, reconciling", ips, holder)
+		c.metrics.AnnounceFailures.WithLabelValues("withdraw").Inc()
+		c.failures.add(holder)
 	}
 }
 
@@ -88,35 +175,42 @@ func (c *cache) shutdown() error {
 	return nil
 }
 
-func (c *cache) upsert(fqdn string, answer *dns.Msg) error {
+func (c *cache) upsert(fqdn string, answer *dns.Msg, r *rule) error {
 	c.L().Trace().Msgf("-> upsert(%s)", fqdn)
 	defer c.L().Trace().Msgf("<- upsert(%s)", fqdn)
 	var ce *cacheEntry
 	var cn = dns.CanonicalName(fqdn)
-	if t, e := c.entries.Get(cn); t == nil && !errors.Is(e, gcache.KeyNotFoundError) {
+	if t, e := c.entries().Get(cn); t == nil && !errors.Is(e, gcache.KeyNotFoundError) {
 		return e
 	} else if t != nil {
 		ce = t.(*cacheEntry)
 	}
 	var gen = c.generation()
 	var prevIps [] string
+	var prevCommunities []string
 	if ce == nil {
-		ce = newCacheEntry(answer, c.minTtl, gen)
+		ce = newCacheEntry(answer, c.ttlFloor(), gen)
 	} else {
-		prevIps = ce.Ip4s()
-		ce.answer = answer
+		prevIps = ce.Ips()
+		prevCommunities = ce.communities
+		ce.setAnswer(answer)
 		ce.gen.Store(gen)
-		ce.updateTtl(c.minTtl)
+		ce.updateTtl(c.ttlFloor())
 	}
+	ce.rule = r.key()
+	ce.communities = c.communitiesOf(r)
 
-	var ips = ce.Ip4s()
-	var gone = utils.Difference(prevIps, ips)
-	var arrived = utils.Difference(ips, prevIps)
-
-	_ = bgp.Advance(arrived)
-	_ = bgp.Withdraw(gone)
+	var ips = ce.Ips()
+	if slices.Equal(prevCommunities, ce.communities) {
+		c.advance(cn, utils.Difference(ips, prevIps), ce.communities)
+		c.withdraw(cn, utils.Difference(prevIps, ips), ce.communities)
+	} else {
+		// every address carries other communities now, hold all of them again before letting the old go
+		c.advance(cn, ips, ce.communities)
+		c.withdraw(cn, prevIps, prevCommunities)
+	}
 
-	if e := c.entries.Set(fqdn, ce); e != nil {
+	if e := c.entries().Set(fqdn, ce); e != nil {
 		c.L().Error().Err(e)
 		return e
 	}
@@ -124,81 +218,414 @@ func (c *cache) upsert(fqdn string, answer *dns.Msg) error {
 
 }
 
-func (c* cache) findKeysByGeneration(gen uint64) []string {
-	// GetALL returns a map with a copy of cache contents
-	all := c.entries.GetALL(true)
-	r := make([]string, 0, len(all) / 2)
-	for k,v := range all {
+// dropRulesByGeneration detaches the rules from the lists matching list ("" matches any) loaded with
+// generation gen or earlier. It returns the rules no list holds anymore and the ones left with other
+// communities.
+func (c* cache) dropRulesByGeneration(list string, gen uint64) (gone []*rule, changed []*rule) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	for _, rl := range c.rules {
+		before := rl.union()
+		if rl.drop(list, gen) {
+			gone = append(gone, rl)
+		} else if !slices.Equal(before, rl.union()) {
+			changed = append(changed, rl)
+		}
+	}
+
+	return
+}
+
+// communitiesOf returns the communities the prefixes of rule r carry.
+func (c *cache) communitiesOf(r *rule) []string {
+	c.m.RLock()
+	defer c.m.RUnlock()
+
+	return r.union()
+}
+
+// recommunity announces the addresses of rule r again after its communities have changed.
+func (c *cache) recommunity(r *rule) {
+	c.m.Lock()
+	cs := r.union()
+	if r.isStatic() {
+		old := r.announced
+		r.announced = cs
+		c.m.Unlock()
+		c.advance(r.key(), []string{r.name}, cs)
+		c.withdraw(r.key(), []string{r.name}, old)
+		return
+	}
+	c.m.Unlock()
+
+	for k, v := range c.entries().GetALL(false) {
 		ce := v.(*cacheEntry)
-		ceGen := (&ce.gen).Load()
-		if ceGen <= gen {
-			r = append(r, k.(string))
+		if ce.rule != r.key() || slices.Equal(ce.communities, cs) {
+			continue
+		}
+		old := ce.communities
+		ce.communities = cs
+		cn := dns.CanonicalName(k.(string))
+		c.advance(cn, ce.Ips(), cs)
+		c.withdraw(cn, ce.Ips(), old)
+	}
+}
+
+// setListResolvers makes the rules resolved with old, the resolvers list path had, use rs instead. When
+// the list had no resolvers of its own, its rules resolved with the default ones switch to rs.
+func (c *cache) setListResolvers(path string, old, rs *resolvers) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	for _, rl := range c.rules {
+		if rl.rs == old && (old != nil || rl.holds(path)) {
+			rl.rs = rs
 		}
 	}
+}
+
+// dropList drops the domains of the list file or directory path.
+func (c *cache) dropList(path string) {
+	for _, l := range append(c.listsIn(path), path) {
+		_ = c.evictByGeneration(l, c.generation())
+	}
+}
 
+// listsIn returns the names of the lists with rules which are files in directory dir.
+func (c *cache) listsIn(dir string) []string {
+	c.m.RLock()
+	defer c.m.RUnlock()
+
+	var r []string
+	for _, rl := range c.rules {
+		for l := range rl.lists {
+			if filepath.Dir(l) == dir && !slices.Contains(r, l) {
+				r = append(r, l)
+			}
+		}
+	}
 	return r
 }
 
-func (c *cache) has(k string) bool{
-	return c.entries.Has(k)
+// loaded reports whether a rule was loaded from the list file fn.
+func (c *cache) loaded(fn string) bool {
+	c.m.RLock()
+	defer c.m.RUnlock()
+
+	for _, rl := range c.rules {
+		if _, ok := rl.lists[fn]; ok {
+			return true
+		}
+	}
+	return false
 }
 
-func (c* cache) register(fqdn string) error {
-	if len (fqdn) < 2 {
-		return fmt.Errorf("'%s'. %w", fqdn, EInvalidFQDN)
+// unresolvedRules returns the rules tracking their own name which have no entry, e.g. after NXDOMAIN.
+func (c *cache) unresolvedRules() (r []*rule) {
+	c.m.RLock()
+	defer c.m.RUnlock()
+
+	for _, rl := range c.rules {
+		if rl.tracksApex() && !c.has(rl.name) {
+			r = append(r, rl)
+		}
 	}
-	cn := dns.CanonicalName(fqdn)
-	dns.HandleFunc(dns.CanonicalName(fqdn), func (rw dns.ResponseWriter, m* dns.Msg) {
-		c.resolve (rw, m, true)
+	return r
+}
+
+// rule returns the rule by its key, nil when it is gone.
+func (c *cache) rule(key string) *rule {
+	c.m.RLock()
+	defer c.m.RUnlock()
+
+	return c.rules[key]
+}
+
+// match finds the most specific rule tracking cn: an exact rule first, then wildcard and suffix rules
+// anchored at the closest parent.
+func (c *cache) match(cn string) *rule {
+	c.m.RLock()
+	defer c.m.RUnlock()
+
+	if r, ok := c.rules[cn]; ok {
+		return r
+	}
+	for off, end := 0, false; !end; off, end = dns.NextLabel(cn, off) {
+		parent := cn[off:]
+		for _, k := range []string{ruleKey(parent, ruleWildcard), ruleKey(parent, ruleSuffix)} {
+			if r, ok := c.rules[k]; ok && r.matches(cn) {
+				return r
+			}
+		}
+	}
+
+	return nil
+}
+
+// handle answers a client query for a name below a rule anchor and tracks the name when a rule matches it.
+func (c *cache) handle(rw dns.ResponseWriter, m *dns.Msg) {
+	c.metrics.Queries.WithLabelValues("tracked", dns.TypeToString[m.Question[0].Qtype]).Inc()
+	c.resolve(rw, m, c.match(dns.CanonicalName(m.Question[0].Name)), true)
+}
+
+// snapshot returns copies of all cache entries ordered by name.
+func (c *cache) snapshot() []Entry {
+	all := c.entries().GetALL(true)
+	r := make([]Entry, 0, len(all))
+	c.m.RLock()
+	for k, v := range all {
+		en := v.(*cacheEntry).entry(k.(string))
+		if rl, ok := c.rules[en.Rule]; ok {
+			en.Lists = rl.sources()
+		}
+		r = append(r, en)
+	}
+	c.m.RUnlock()
+	slices.SortFunc(r, func(a, b Entry) int {
+		return strings.Compare(a.Fqdn, b.Fqdn)
 	})
 
-	q := new(dns.Msg)
-	q.SetQuestion(cn, dns.TypeA)
-	// resolve will call cache.upsert on resolved IPs
-	c.resolve(nil, q, false)
+	return r
+}
+
+// lookup returns a copy of the entry of cn.
+func (c *cache) lookup(cn string) (Entry, bool) {
+	t, e := c.entries().Get(cn)
+	if e != nil || t == nil {
+		return Entry{}, false
+	}
+	en := t.(*cacheEntry).entry(cn)
+	c.m.RLock()
+	if rl, ok := c.rules[en.Rule]; ok {
+		en.Lists = rl.sources()
+	}
+	c.m.RUnlock()
+
+	return en, true
+}
+
+// domains returns copies of all rules ordered by key.
+func (c *cache) domains() []Domain {
+	c.m.RLock()
+	r := make([]Domain, 0, len(c.rules))
+	for _, rl := range c.rules {
+		r = append(r, rl.domain())
+	}
+	c.m.RUnlock()
+	slices.SortFunc(r, func(a, b Domain) int {
+		return strings.Compare(a.Rule, b.Rule)
+	})
+
+	return r
+}
+
+// Hold is what a holder, a cache entry or a static rule, announces: addresses or prefixes and the communities
+// it attaches to them.
+type Hold struct {
+	Ips         []string
+	Communities []string
+}
+
+// holds returns what every cache entry, restored entry waiting for its rule and static rule announces, by
+// holder.
+func (c *cache) holds() map[string]Hold {
+	r := make(map[string]Hold)
+	for k, v := range c.entries().GetALL(false) {
+		ce := v.(*cacheEntry)
+		if ips := ce.Ips(); len(ips) > 0 {
+			r[dns.CanonicalName(k.(string))] = Hold{Ips: ips, Communities: ce.communities}
+		}
+	}
+	c.m.RLock()
+	defer c.m.RUnlock()
+	for _, es := range c.restored {
+		for fqdn, ce := range es {
+			r[fqdn] = Hold{Ips: ce.Ips(), Communities: ce.communities}
+		}
+	}
+	for _, rl := range c.rules {
+		if rl.isStatic() {
+			r[rl.key()] = Hold{Ips: []string{rl.name}, Communities: rl.announced}
+		}
+	}
+
+	return r
+}
+
+// refreshAll resolves every entry again regardless of its TTL and returns the number of entries resolved. The
+// addresses stay announced while the name keeps resolving to them, an entry which resolves to nothing is
+// removed.
+func (c *cache) refreshAll() int {
+	n := 0
+	for k, v := range c.entries().GetALL(false) {
+		cn := dns.CanonicalName(k.(string))
+		r := c.rule(v.(*cacheEntry).rule)
+		if r == nil {
+			_ = c.entries().Remove(k)
+			continue
+		}
+		c.L().Debug().Msgf("Refreshing %s", cn)
+		c.refresh(cn, r)
+		n++
+	}
+	c.notfiyChanged(".")
+
+	return n
+}
+
+func (c *cache) has(k string) bool{
+	return c.entries().Has(k)
+}
+
+// hasIps reports whether the entry k still holds any A or AAAA record.
+func (c *cache) hasIps(k string) bool {
+	if t, e := c.entries().Get(k); e == nil && t != nil {
+		return len(t.(*cacheEntry).Ips()) > 0
+	}
+	return false
+}
+
+// refresh resolves both A and AAAA records of cn, resolve will call cache.upsert on resolved IPs
+func (c *cache) refresh(cn string, r *rule) {
+	for _, t := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		q := new(dns.Msg)
+		q.SetQuestion(cn, t)
+		c.resolve(nil, q, r, false)
+	}
+}
+
+// register adds a rule of list loaded with generation gen from a list line: example.com, *.example.com,
+// .example.com or an address or prefix, optionally annotated with communities. Names below the rule anchor
+// become tracked entries once clients query them, resolved with the resolvers of src when set.
+func (c* cache) register(line string, list string, gen uint64, src *listSource) error {
+	fqdn, communities, e := parseEntry(line)
+	if e != nil {
+		return e
+	}
+	r, e := parseRule(fqdn)
+	if e != nil {
+		return e
+	}
+	if src != nil {
+		communities = append(communities, src.communities...)
+		slices.Sort(communities)
+		communities = slices.Compact(communities)
+	}
+
+	c.m.Lock()
+	if existing, ok := c.rules[r.key()]; ok {
+		before := existing.union()
+		existing.lists[list] = gen
+		existing.communities[list] = communities
+		changed := !slices.Equal(before, existing.union())
+		c.m.Unlock()
+		if changed {
+			c.recommunity(existing)
+		}
+		return nil
+	}
+	r.lists[list] = gen
+	r.communities[list] = communities
+	if src != nil {
+		r.rs = src.rs
+	}
+	r.announced = r.union()
+	c.rules[r.key()] = r
+	c.m.Unlock()
+
+	c.L().Debug().Msgf("Registering %s", r.key())
+	if r.isStatic() {
+		// reference counted along with the resolved addresses, a host entry shares the route with them
+		return c.bgp.Advance(r.key(), []string{r.name}, r.announced)
+	}
+	c.mux.HandleFunc(r.name, c.handle)
+
+	// a restored entry is refreshed when it expires
+	if c.adopt(r) {
+		return nil
+	}
+	if r.tracksApex() {
+		c.refresh(r.name, r)
+	}
 
 	return nil
 }
 
+// unregister removes a rule together with every entry tracked through it, whichever lists hold the rule.
+// A name which is not a rule drops just its own entry.
 func (c*cache) unregister(fqdn string) error {
-	if len (fqdn) < 2 {
-		return fmt.Errorf("'%s'. %w", fqdn, EInvalidFQDN)
+	r, e := parseRule(fqdn)
+	if e != nil {
+		return e
+	}
+
+	c.m.Lock()
+	existing, ok := c.rules[r.key()]
+	if !ok {
+		c.m.Unlock()
+		c.L().Debug().Msgf("Removing cache entry %s", r.name)
+		_ = c.entries().Remove(r.name)
+		return nil
+	}
+	delete(c.rules, r.key())
+	announced := existing.announced
+	anchored := false
+	for _, k := range []ruleKind{ruleExact, ruleWildcard, ruleSuffix} {
+		if _, ok = c.rules[ruleKey(r.name, k)]; ok {
+			anchored = true
+		}
+	}
+	c.m.Unlock()
+
+	c.L().Debug().Msgf("Unregistering %s", existing.key())
+	if existing.isStatic() {
+		return c.bgp.Withdraw(existing.key(), []string{existing.name}, announced)
+	}
+	if !anchored {
+		c.mux.HandleRemove(existing.name)
 	}
-	cn := dns.CanonicalName(fqdn)
-	c.L().Debug().Msgf("Unregistering %s", cn)
-	dns.HandleRemove(cn)
 
 	var kr [] string
-	for _, k := range c.entries.Keys(true) {
-		s := k.(string)
-		if strings.HasSuffix(s, cn) {
-			kr = append(kr, s)
+	for k, v := range c.entries().GetALL(false) {
+		if v.(*cacheEntry).rule == existing.key() {
+			kr = append(kr, k.(string))
 		}
 	}
 
 	for _, k := range kr {
 		c.L().Trace().Msgf("Removing cache entry %s", k)
-		_ = c.entries.Remove(k);
+		_ = c.entries().Remove(k);
 	}
 
 	return nil
 }
 
-func (c* cache) load(fn string) error {
+// load (re)loads the list file fn with the settings of src when set. Rules which are gone from the file are
+// unregistered unless another list still holds them. A missing file keeps the rules it was last loaded with,
+// an editor replacing it or a failed download must not withdraw its prefixes.
+func (c* cache) load(fn string, src *listSource) error {
 	f, e := os.Open(fn)
+	if errors.Is(e, os.ErrNotExist) {
+		if c.loaded(fn) {
+			c.L().Error().Err(e).Msgf("%s is missing, keeping the domains it was last loaded with", fn)
+		} else {
+			c.L().Info().Msgf("%s is missing, nothing to load", fn)
+		}
+		return nil
+	}
 	if e != nil {
 		return e
 	}
 	defer func(f *os.File) {
 		err := f.Close()
 		if err != nil {
-			log.L().Warn().Msgf("Failed to close file: %v", err)
+			c.L().Warn().Msgf("Failed to close file: %v", err)
 		}
 	}(f)
 
 	scanner := bufio.NewScanner(f)
-	oldGeneration := c.generation()
-	_ = c.increaseGeneration()
+	gen := c.increaseGeneration()
 
 	for scanner.Scan() {
 		fqdn := strings.TrimSpace(scanner.Text())
@@ -208,22 +635,53 @@ func (c* cache) load(fn string) error {
 		if fqdn[0] == '#' || fqdn[0] == ';'{
 			continue
 		}
-		if e = c.register(fqdn); e != nil {
-			return e
+		if e = c.register(fqdn, fn, gen, src); e != nil {
+			c.L().Warn().Err(e).Msgf("Skipping line of %s", fn)
 		}
 
 	}
+	if e = scanner.Err(); e != nil {
+		// keep the rules of a list which could not be read completely
+		return e
+	}
 
-	return c.evictByGeneration(oldGeneration);
+	return c.evictByGeneration(fn, gen - 1);
 }
 
-func (c *cache) evictByGeneration(gen uint64) error {
-	c.L().Debug().Msgf("Evicting generation %d...", gen)
-	defer c.L().Debug().Msgf("Evicting generation %d done.", gen)
-	keys := c.findKeysByGeneration(gen)
+// loadDir loads every *.lst file of dir as a list of its own and drops the lists whose files are gone.
+func (c *cache) loadDir(dir string, src *listSource) error {
+	files, e := filepath.Glob(filepath.Join(dir, "*.lst"))
+	if e != nil {
+		return e
+	}
+	var errs []error
+	for _, fn := range files {
+		if e = c.load(fn, src); e != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", fn, e))
+		}
+	}
+	for _, l := range c.listsIn(dir) {
+		if !slices.Contains(files, l) {
+			c.L().Info().Msgf("%s is gone, dropping its domains", l)
+			_ = c.evictByGeneration(l, c.generation())
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// evictByGeneration unregisters the rules which only the lists matching list ("" matches any) loaded with
+// generation gen or earlier were holding.
+func (c *cache) evictByGeneration(list string, gen uint64) error {
+	c.L().Debug().Msgf("Evicting generation %d of '%s'...", gen, list)
+	defer c.L().Debug().Msgf("Evicting generation %d of '%s' done.", gen, list)
+	rules, changed := c.dropRulesByGeneration(list, gen)
+	for _, r := range changed {
+		c.recommunity(r)
+	}
 
-	for _, k := range keys {
-		if e := c.unregister(k); e != nil{
+	for _, r := range rules {
+		if e := c.unregister(r.key()); e != nil{
 			c.L().Error().Err(e).Msgf("Failed to unregister by generation")
 		}
 	}
@@ -10,8 +10,24 @@ type cacheEntry struct {
 	gen atomic.Uint64
 	ttl time.Duration
 	answer 	*dns.Msg
+	answer6 *dns.Msg
 	expiration time.Time
+	rule string
+	// communities are the ones the addresses were announced with
+	communities []string
 }
+// Entry is a point in time copy of a cache entry.
+type Entry struct {
+	Fqdn       string    `json:"fqdn"`
+	Ips        []string  `json:"ips"`
+	Ttl        uint32    `json:"ttl"`
+	Expiration time.Time `json:"expiration"`
+	Generation uint64    `json:"generation"`
+	Rule       string    `json:"rule"`
+	Lists      []string  `json:"lists"`
+	Communities []string `json:"communities"`
+}
+
 var (
 	ENotAddressAnswer = errors.New("not an A/AAAA answer")
 )
@@ -31,14 +47,42 @@ func minTtl (m *dns.Msg, minTtl time.Duration) (r time.Duration) {
 
 func newCacheEntry(m *dns.Msg, mTtl time.Duration, gen uint64) *cacheEntry{
 	ce := new(cacheEntry)
-	ce.answer = m
+	ce.setAnswer(m)
 	ce.updateTtl(mTtl)
 	ce.setGeneration(gen)
 
 	return ce;
 }
+// setAnswer stores m as the A or the AAAA answer depending on its question type.
+func (ce *cacheEntry) setAnswer(m *dns.Msg) {
+	if len(m.Question) > 0 && m.Question[0].Qtype == dns.TypeAAAA {
+		ce.answer6 = m
+	} else {
+		ce.answer = m
+	}
+}
+
+func (ce *cacheEntry) answers() (r []*dns.Msg) {
+	for _, m := range []*dns.Msg{ce.answer, ce.answer6} {
+		if m != nil {
+			r = append(r, m)
+		}
+	}
+	return r
+}
+
 func (ce *cacheEntry) updateTtl(mTtlSeconds time.Duration) {
-	mTtlSeconds = minTtl(ce.answer, mTtlSeconds)
+	var ttl time.Duration
+	// the entry is refreshed as soon as the first of its answers expires
+	for _, m := range ce.answers() {
+		if t := minTtl(m, mTtlSeconds); ttl == 0 || t < ttl {
+			ttl = t
+		}
+	}
+	if ttl == 0 {
+		ttl = mTtlSeconds
+	}
+	mTtlSeconds = ttl
 	ce.ttl = mTtlSeconds
 	ce.expiration = time.Now().Add(ce.ttl * time.Second)
 }
@@ -53,23 +97,42 @@ func (ce *cacheEntry) setGeneration(gen uint64)  {
 
 
 func (ce *cacheEntry) Ip4s() (ips []string)  {
-	ips = make([]string, 0, len(ce.answer.Answer))
-	for _, rr := range ce.answer.Answer {
-		if a, ok := rr.(*dns.A); ok {
-			ips = append(ips, a.A.String())
+	ips = make([]string, 0)
+	for _, m := range ce.answers() {
+		for _, rr := range m.Answer {
+			if a, ok := rr.(*dns.A); ok {
+				ips = append(ips, a.A.String())
+			}
 		}
 	}
 	return ips
 }
 
 func (ce *cacheEntry) Ip6s() (ips []string)  {
-	ips = make([]string, 0, len(ce.answer.Answer))
-	for _, rr := range ce.answer.Answer {
-		if a, ok := rr.(*dns.AAAA); ok {
-			ips = append(ips, a.AAAA.String())
+	ips = make([]string, 0)
+	for _, m := range ce.answers() {
+		for _, rr := range m.Answer {
+			if a, ok := rr.(*dns.AAAA); ok {
+				ips = append(ips, a.AAAA.String())
+			}
 		}
 	}
 	return ips
 }
 
+// Ips returns both IPv4 and IPv6 addresses of the entry.
+func (ce *cacheEntry) Ips() []string {
+	return append(ce.Ip4s(), ce.Ip6s()...)
+}
 
+func (ce *cacheEntry) entry(fqdn string) Entry {
+	return Entry{
+		Fqdn:       fqdn,
+		Ips:        ce.Ips(),
+		Ttl:        uint32(ce.ttl),
+		Expiration: ce.expiration,
+		Generation: ce.generation(),
+		Rule:       ce.rule,
+		Communities: ce.communities,
+	}
+}
@@ -0,0 +1,220 @@
+package dns
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/miekg/dns"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/red55/bgp-dns/internal/log"
+	"github.com/red55/bgp-dns/internal/metrics"
+)
+
+// announcer counts the holds of every holder on every address, as the speaker does. It fails every call with
+// fail when set.
+type announcer struct {
+	m     sync.Mutex
+	holds map[string]map[string]int
+	fail  error
+}
+
+func newAnnouncer() *announcer {
+	return &announcer{holds: make(map[string]map[string]int)}
+}
+
+func (a *announcer) Advance(holder string, ips []string, _ []string) error {
+	a.m.Lock()
+	defer a.m.Unlock()
+	if a.fail != nil {
+		return a.fail
+	}
+	for _, ip := range ips {
+		if a.holds[holder] == nil {
+			a.holds[holder] = make(map[string]int)
+		}
+		a.holds[holder][ip]++
+	}
+	return nil
+}
+
+func (a *announcer) Withdraw(holder string, ips []string, _ []string) error {
+	a.m.Lock()
+	defer a.m.Unlock()
+	if a.fail != nil {
+		return a.fail
+	}
+	for _, ip := range ips {
+		if a.holds[holder][ip]--; a.holds[holder][ip] <= 0 {
+			delete(a.holds[holder], ip)
+		}
+	}
+	return nil
+}
+
+// announced returns the addresses held by anyone.
+func (a *announcer) announced() (r []string) {
+	a.m.Lock()
+	defer a.m.Unlock()
+	for _, ips := range a.holds {
+		for ip := range ips {
+			if !slices.Contains(r, ip) {
+				r = append(r, ip)
+			}
+		}
+	}
+	slices.Sort(r)
+	return
+}
+
+// newListCache returns a cache announcing to a, with nothing to resolve with.
+func newListCache(a *announcer) *cache {
+	return newCache(16, 60, nil, dns.NewServeMux(), a, metrics.New(), log.NewLogs(nil))
+}
+
+// writeList writes the lines of a list file.
+func writeList(t *testing.T, fn string, lines ...string) {
+	var b []byte
+	for _, l := range lines {
+		b = append(b, l+"\n"...)
+	}
+	if e := os.WriteFile(fn, b, 0o644); e != nil {
+		t.Fatal(e)
+	}
+}
+
+func TestListGenerations(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.lst"), filepath.Join(dir, "b.lst")
+	ann := newAnnouncer()
+	c := newListCache(ann)
+
+	writeList(t, a, "192.0.2.1", "192.0.2.2")
+	writeList(t, b, "192.0.2.2", "192.0.2.3")
+	for _, fn := range []string{a, b} {
+		if e := c.load(fn, nil); e != nil {
+			t.Fatal(e)
+		}
+	}
+	if got, want := ann.announced(), []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"}; !slices.Equal(got, want) {
+		t.Fatalf("announced %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		name  string
+		fn    string
+		lines []string
+		want  []string
+	}{
+		{"entry still held by the other list", a, []string{"192.0.2.1"},
+			[]string{"192.0.2.1", "192.0.2.2", "192.0.2.3"}},
+		{"entry left by both lists", b, []string{"192.0.2.3"}, []string{"192.0.2.1", "192.0.2.3"}},
+		{"entry moved to the other list", a, []string{"192.0.2.4"}, []string{"192.0.2.3", "192.0.2.4"}},
+		{"unchanged list", b, []string{"192.0.2.3"}, []string{"192.0.2.3", "192.0.2.4"}},
+		{"emptied list", b, nil, []string{"192.0.2.4"}},
+	}
+	for _, tt := range tests {
+		writeList(t, tt.fn, tt.lines...)
+		if e := c.load(tt.fn, nil); e != nil {
+			t.Fatalf("%s: %v", tt.name, e)
+		}
+		if got := ann.announced(); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: announced %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMissingListKeepsGeneration(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "a.lst")
+	ann := newAnnouncer()
+	c := newListCache(ann)
+
+	// a list which was never there loads nothing
+	if e := c.load(fn, nil); e != nil || c.loaded(fn) {
+		t.Fatalf("missing list loaded: %t %v", c.loaded(fn), e)
+	}
+
+	writeList(t, fn, "192.0.2.1", "198.51.100.0/24")
+	if e := c.load(fn, nil); e != nil {
+		t.Fatal(e)
+	}
+	if e := os.Remove(fn); e != nil {
+		t.Fatal(e)
+	}
+	if e := c.load(fn, nil); e != nil {
+		t.Fatal(e)
+	}
+	want := []string{"192.0.2.1", "198.51.100.0/24"}
+	if got := ann.announced(); !slices.Equal(got, want) {
+		t.Errorf("announced %v after the list went missing, want %v", got, want)
+	}
+
+	// the file is back, the generation it brings replaces the kept one
+	writeList(t, fn, "192.0.2.1")
+	if e := c.load(fn, nil); e != nil {
+		t.Fatal(e)
+	}
+	if got, want := ann.announced(), []string{"192.0.2.1"}; !slices.Equal(got, want) {
+		t.Errorf("announced %v after the list is back, want %v", got, want)
+	}
+
+	// dropping the list from the configuration lets go of it
+	c.dropList(fn)
+	if got := ann.announced(); len(got) > 0 {
+		t.Errorf("announced %v after the list was dropped", got)
+	}
+}
+
+func TestAnnounceFailure(t *testing.T) {
+	ann := newAnnouncer()
+	c := newListCache(ann)
+	r, e := parseRule("*.example.com")
+	if e != nil {
+		t.Fatal(e)
+	}
+	failures := func(op string) float64 {
+		var m dto.Metric
+		if e := c.metrics.AnnounceFailures.WithLabelValues(op).Write(&m); e != nil {
+			t.Fatal(e)
+		}
+		return m.GetCounter().GetValue()
+	}
+
+	if e = c.upsert("a.example.com.", answerOf("a.example.com.", dns.TypeA, "192.0.2.1"), r); e != nil {
+		t.Fatal(e)
+	}
+	select {
+	case <-c.failures.c:
+		t.Fatal("signaled without a failure")
+	default:
+	}
+
+	ann.fail = errors.New("speaker is down")
+	for _, a := range []struct{ fqdn, ip string }{{"a.example.com.", "192.0.2.2"}, {"b.example.com.", "192.0.2.3"}} {
+		if e = c.upsert(a.fqdn, answerOf(a.fqdn, dns.TypeA, a.ip), r); e != nil {
+			t.Fatal(e)
+		}
+	}
+	// the entries are cached all the same, the reconciler corrects what the speaker holds
+	if ce := cached(c, "b.example.com."); ce == nil || !slices.Equal(ce.Ips(), []string{"192.0.2.3"}) {
+		t.Errorf("b.example.com. is cached as %v", ce)
+	}
+	if got, want := [2]float64{failures("advance"), failures("withdraw")}, [2]float64{2, 1}; got != want {
+		t.Errorf("counted %v advance and withdraw failures, want %v", got, want)
+	}
+	select {
+	case <-c.failures.c:
+	default:
+		t.Fatal("the failures were not signaled")
+	}
+	if got, want := c.failures.take(), []string{"a.example.com.", "b.example.com."}; !slices.Equal(got, want) {
+		t.Errorf("marked %v, want %v", got, want)
+	}
+	if got := c.failures.take(); len(got) > 0 {
+		t.Errorf("marked %v again", got)
+	}
+}
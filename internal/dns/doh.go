@@ -0,0 +1,129 @@
+package dns
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/miekg/dns"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// dohWriter adapts an HTTP exchange to dns.ResponseWriter, so DoH queries go through the same mux
+// as UDP and TCP ones.
+type dohWriter struct {
+	local  net.Addr
+	remote net.Addr
+	answer *dns.Msg
+}
+
+func (w *dohWriter) LocalAddr() net.Addr {
+	return w.local
+}
+
+func (w *dohWriter) RemoteAddr() net.Addr {
+	return w.remote
+}
+
+func (w *dohWriter) WriteMsg(m *dns.Msg) error {
+	w.answer = m
+	return nil
+}
+
+func (w *dohWriter) Write(b []byte) (int, error) {
+	m := new(dns.Msg)
+	if e := m.Unpack(b); e != nil {
+		return 0, e
+	}
+	w.answer = m
+	return len(b), nil
+}
+
+func (w *dohWriter) Close() error {
+	return nil
+}
+
+func (w *dohWriter) TsigStatus() error {
+	return nil
+}
+
+func (w *dohWriter) TsigTimersOnly(bool) {
+}
+
+func (w *dohWriter) Hijack() {
+}
+
+// parseDohQuery extracts the DNS message of an RFC 8484 GET or POST request.
+func parseDohQuery(r *http.Request) (*dns.Msg, error) {
+	var b []byte
+	var e error
+
+	switch r.Method {
+	case http.MethodGet:
+		if b, e = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns")); e != nil {
+			return nil, fmt.Errorf("invalid dns parameter: %w", e)
+		}
+	case http.MethodPost:
+		if ct := r.Header.Get("Content-Type"); ct != dohMediaType {
+			return nil, fmt.Errorf("unsupported content type %s", ct)
+		}
+		if b, e = io.ReadAll(io.LimitReader(r.Body, dns.MaxMsgSize)); e != nil {
+			return nil, e
+		}
+	default:
+		return nil, fmt.Errorf("unsupported method %s", r.Method)
+	}
+
+	m := new(dns.Msg)
+	if e = m.Unpack(b); e != nil {
+		return nil, fmt.Errorf("invalid DNS message: %w", e)
+	}
+	if len(m.Question) != 1 {
+		return nil, fmt.Errorf("exactly one question expected, got %d", len(m.Question))
+	}
+
+	return m, nil
+}
+
+func (s *dnsSrv) serveDoh(w http.ResponseWriter, r *http.Request) {
+	q, e := parseDohQuery(r)
+	if e != nil {
+		http.Error(w, e.Error(), http.StatusBadRequest)
+		return
+	}
+
+	dw := &dohWriter{
+		local:  r.Context().Value(http.LocalAddrContextKey).(net.Addr),
+		remote: &net.TCPAddr{},
+	}
+	if ap, e := net.ResolveTCPAddr("tcp", r.RemoteAddr); e == nil {
+		dw.remote = ap
+	}
+	s.mux.ServeDNS(dw, q)
+
+	a := dw.answer
+	if a == nil {
+		a = new(dns.Msg)
+		a.SetRcode(q, dns.RcodeServerFailure)
+	}
+	b, e := a.Pack()
+	if e != nil {
+		http.Error(w, e.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", dohMediaType)
+	w.Header().Set("Cache-Control", "max-age="+strconv.Itoa(int(answerTtl(a))))
+	_, _ = w.Write(b)
+}
+
+// answerTtl is the lowest TTL of the answer section, used as the HTTP freshness lifetime.
+func answerTtl(m *dns.Msg) (r uint32) {
+	for i, rr := range m.Answer {
+		if t := rr.Header().Ttl; i == 0 || t < r {
+			r = t
+		}
+	}
+	return r
+}
@@ -0,0 +1,44 @@
+package dns
+
+import (
+	"slices"
+	"sync"
+)
+
+// failures are the holders an Advance or a Withdraw failed for, the reconciler corrects their prefixes as
+// soon as c signals one.
+type failures struct {
+	m       sync.Mutex
+	holders map[string]struct{}
+	c       chan struct{}
+}
+
+func newFailures() *failures {
+	return &failures{holders: make(map[string]struct{}), c: make(chan struct{}, 1)}
+}
+
+// add marks holder and signals the reconciler, unless a signal is already waiting.
+func (f *failures) add(holder string) {
+	f.m.Lock()
+	f.holders[holder] = struct{}{}
+	f.m.Unlock()
+
+	select {
+	case f.c <- struct{}{}:
+	default:
+	}
+}
+
+// take returns the sorted holders marked since the last call.
+func (f *failures) take() []string {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	r := make([]string, 0, len(f.holders))
+	for h := range f.holders {
+		r = append(r, h)
+	}
+	clear(f.holders)
+	slices.Sort(r)
+	return r
+}
@@ -4,8 +4,6 @@ import (
 	"context"
 	"errors"
 	"github.com/miekg/dns"
-	"github.com/red55/bgp-dns/internal/config"
-	"github.com/red55/bgp-dns/internal/log"
 	"time"
 )
 
@@ -14,18 +12,23 @@ func (c *cache) loop(ctx context.Context) {
 	c.wg.Add(1)
 	defer c.wg.Done()
 
-	cfg := ctx.Value("cfg").(*config.AppCfg)
+	var lastRetry time.Time
 	L:
 	for {
 		var sleepUntil time.Time
 		now := time.Now()
 
-		all := c.entries.GetALL(true)
+		all := c.entries().GetALL(true)
+		c.metrics.CacheCycles.Inc()
 
-		if len(all) > 0 {
-			sleepUntil = all[c.entries.Keys(true)[0]].(*cacheEntry).expiration
-		} else {
-			sleepUntil = time.Now().Add(cfg.Dns.Cache.MinTtl * time.Second)
+		// an entry may expire between GetALL and Keys, the ones left in all still bring sleepUntil forward
+		if keys := c.entries().Keys(true); len(keys) > 0 {
+			if ce, ok := all[keys[0]].(*cacheEntry); ok {
+				sleepUntil = ce.expiration
+			}
+		}
+		if sleepUntil.IsZero() {
+			sleepUntil = time.Now().Add(c.ttlFloor() * time.Second)
 		}
 
 		for k,v := range all {
@@ -33,12 +36,16 @@ func (c *cache) loop(ctx context.Context) {
 
 			c.L().Trace().Msgf("%s, ttl:%d, expire: %s", k.(string), ce.ttl, ce.expiration.Format(time.RFC3339))
 			if ce.expiration.Before(now) {
-				q := new(dns.Msg)
 				cn := dns.CanonicalName(k.(string))
-				q.SetQuestion(cn, dns.TypeA)
+				r := c.rule(ce.rule)
+				if r == nil {
+					c.L().Debug().Msgf("Rule %s of %s is gone, removing", ce.rule, cn)
+					_ = c.entries().Remove(k)
+					continue
+				}
 				c.L().Debug().Msgf("Resolving cached %s", k.(string))
-				// resolve will call cache.upsert on resolved IPs
-				c.resolve(nil, q, false)
+				c.metrics.CacheRefreshes.Inc()
+				c.refresh(cn, r)
 				c.L().Trace().Msgf("New %s, ttl:%d, expire: %s", k.(string), ce.ttl,ce.expiration.Format(time.RFC3339))
 			}
 
@@ -47,8 +54,17 @@ func (c *cache) loop(ctx context.Context) {
 			}
 
 		}
-		if sleepUntil.Sub(now) < cfg.Dns.Cache.MinTtl * time.Second {
-			sleepUntil = now.Add(cfg.Dns.Cache.MinTtl * time.Second)
+		// operations wake the loop up as well, names which failed to resolve are retried once per MinTtl
+		if now.Sub(lastRetry) >= c.ttlFloor() * time.Second {
+			lastRetry = now
+			for _, r := range c.unresolvedRules() {
+				c.L().Debug().Msgf("Resolving %s again", r.name)
+				c.refresh(r.name, r)
+			}
+		}
+
+		if sleepUntil.Sub(now) < c.ttlFloor() * time.Second {
+			sleepUntil = now.Add(c.ttlFloor() * time.Second)
 		}
 
 		c.L().Info().Msgf("DNS Refresher will sleep until %s for %d seconds", sleepUntil.Format(time.RFC3339),
@@ -66,7 +82,7 @@ func (c *cache) loop(ctx context.Context) {
 		case <- ctx.Done():
 			cancelTimeout()
 			if !errors.Is(ctx.Err(), context.Canceled) {
-				log.L().Error().Err(ctx.Err())
+				c.L().Error().Err(ctx.Err())
 			}
 			break L
 		}
@@ -2,96 +2,467 @@ package dns
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"github.com/miekg/dns"
 	"github.com/red55/bgp-dns/internal/config"
 	"github.com/red55/bgp-dns/internal/log"
+	"github.com/red55/bgp-dns/internal/metrics"
+	"github.com/red55/bgp-dns/internal/utils"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
 	"sync"
 	"time"
 )
 
-var (
-	_server *dns.Server
-	_wg     sync.WaitGroup
-	_resolvers *resolvers
-	_cancel context.CancelFunc
-	_cache *cache
+// Announcer receives addresses which appeared in or disappeared from the cache, along with the holder, the
+// name of the cache entry or the key of the static rule, and the BGP communities attached to them. Every
+// Withdraw mirrors an earlier Advance of the same holder with the same communities.
+type Announcer interface {
+	Advance(holder string, ips []string, communities []string) error
+	Withdraw(holder string, ips []string, communities []string) error
+}
+
+// Service is a caching DNS proxy which announces the addresses of the listed domains.
+type Service interface {
+	Serve(ctx context.Context) error
+	Shutdown(ctx context.Context) error
+	Register(fqdn string) error
+	Unregister(fqdn string) error
+	Load(fn string) error
+	Reload(cfg *config.AppCfg) error
+	Settle() error
+	Entries() ([]Entry, error)
+	Entry(fqdn string) (Entry, error)
+	Domains() ([]Domain, error)
+	Refresh() (int, error)
+	Holds() (map[string]Hold, error)
+	Failed() <-chan struct{}
+	TakeFailed() []string
+}
+
+type dnsSrv struct {
+	log.Log
+	// m guards lists and cfg against Reload
+	m         sync.RWMutex
+	cfg       *config.AppCfg
+	bgp       Announcer
+	logs      *log.Logs
+	metrics   *metrics.Metrics
+	mux       *dns.ServeMux
+	servers   []*dns.Server
+	https     *http.Server
+	wg        sync.WaitGroup
+	resolvers *resolvers
+	cancel    context.CancelFunc
+	cache     *cache
+	lists     []*listSource
+	// failures outlive the cache, which is created again by Serve
+	failures *failures
+}
+
+// listSource is a configured list with the resolvers of its domains, nil for the default ones, and the
+// communities attached to them.
+type listSource struct {
+	path        string
+	rs          *resolvers
+	communities []string
+}
 
+var (
 	EInvalidFQDN = errors.New("invalid FQDN")
 	ENotInitialized = errors.New("cache subsystemd is not initialized")
+	ENotCached = errors.New("not cached")
 )
 
-func Serve(ctx context.Context) error {
-	var cfg = ctx.Value("cfg").(*config.AppCfg)
+func New(cfg *config.AppCfg, bgp Announcer, m *metrics.Metrics, logs *log.Logs) Service {
+	return &dnsSrv{
+		Log: logs.NewLog("dns"),
+		cfg: cfg,
+		bgp: bgp,
+		logs: logs,
+		metrics: m,
+		mux: dns.NewServeMux(),
+		failures: newFailures(),
+	}
+}
+
+func (s *dnsSrv) Serve(ctx context.Context) error {
+	var cfg = s.cfg
+
+	if nil != s.cancel {
+		s.cancel()
+	}
+	ctx, s.cancel = context.WithCancel(ctx)
+
+	s.resolvers = newResolvers(cfg.Dns.Resolvers, s.metrics, s.logs)
+
+	var e error
+	if s.lists, e = s.sources(cfg); e != nil {
+		s.cancel()
+		return e
+	}
+
+	// bind synchronously, so the caller learns about a busy port
+	addr := fmt.Sprintf("%s:%d", cfg.Dns.Listen.IP.String(), cfg.Dns.Listen.Port)
+	pc, e := net.ListenPacket("udp", addr)
+	if e != nil {
+		s.cancel()
+		return fmt.Errorf("failed to bind DNS resolver: %w", e)
+	}
+	// answers too large for UDP are truncated, clients retry them over TCP on the same address
+	l, e := net.Listen("tcp", addr)
+	if e != nil {
+		_ = pc.Close()
+		s.cancel()
+		return fmt.Errorf("failed to bind DNS resolver: %w", e)
+	}
+	var dot, doh net.Listener
+	if cfg.Dns.Tls != nil {
+		if dot, e = listenTls(cfg.Dns.Tls, "dot"); e != nil {
+			_ = pc.Close()
+			_ = l.Close()
+			s.cancel()
+			return fmt.Errorf("failed to bind DNS-over-TLS: %w", e)
+		}
+	}
+	if cfg.Dns.Https != nil {
+		if doh, e = listenTls(cfg.Dns.Https, "h2", "http/1.1"); e != nil {
+			_ = pc.Close()
+			_ = l.Close()
+			if dot != nil {
+				_ = dot.Close()
+			}
+			s.cancel()
+			return fmt.Errorf("failed to bind DNS-over-HTTPS: %w", e)
+		}
+	}
+	s.mux.HandleFunc(".", s.resolvers.proxyQuery)
+
+	s.start(&dns.Server{
+		PacketConn: pc,
+		Net:        "udp",
+		Handler:    s.mux,
+	})
+	s.start(&dns.Server{
+		Listener: l,
+		Net:      "tcp",
+		Handler:  s.mux,
+	})
+	if dot != nil {
+		s.L().Info().Msgf("DNS-over-TLS is listening on %s", dot.Addr().String())
+		s.start(&dns.Server{
+			Listener: dot,
+			Net:      "tcp-tls",
+			Handler:  s.mux,
+		})
+	}
+	if doh != nil {
+		s.startDoh(doh, cfg.Dns.Https.Path)
+	}
+
+	s.cache = newCache(cfg.Dns.Cache.MaxEntries, cfg.Dns.Cache.MinTtl, newResolvers(defaultResolvers(cfg), s.metrics, s.logs),
+		s.mux, s.bgp, s.metrics, s.logs)
+	s.cache.failures = s.failures
 
-	if nil != _cancel {
-		_cancel()
+	if fn := cfg.Dns.Cache.StateFile; fn != "" {
+		if e = s.cache.restore(fn); e != nil {
+			s.L().Warn().Err(e).Msg("Starting with an empty cache")
+		}
+		s.wg.Add(1)
+		go s.persist(ctx, fn, cfg.Dns.Cache.StateInterval)
 	}
-	ctx, _cancel = context.WithCancel(ctx)
 
-	_resolvers = newResolvers(cfg.Dns.Resolvers)
+	return s.cache.serve(ctx)
+}
+
+// defaultResolvers returns the resolvers of the domains of lists without resolvers of their own.
+func defaultResolvers(cfg *config.AppCfg) []*config.ResolverCfg {
+	if len(cfg.Dns.List.Resolvers) > 0 {
+		return cfg.Dns.List.Resolvers
+	}
+	return cfg.Dns.Resolvers
+}
 
-	go func(c context.Context) {
-		_server = &dns.Server{
-			Addr:      fmt.Sprintf("%s:%d", cfg.Dns.Listen.IP.String(), cfg.Dns.Listen.Port),
-			Net:       "udp",
-			ReusePort: true,
+// sources builds the list sources of cfg.
+func (s *dnsSrv) sources(cfg *config.AppCfg) ([]*listSource, error) {
+	var r []*listSource
+	for _, l := range cfg.Dns.AllLists() {
+		src := &listSource{path: filepath.Clean(l.File)}
+		var e error
+		if src.communities, e = utils.NormalizeCommunities(l.Communities); e != nil {
+			return nil, fmt.Errorf("list %s: %w", l.File, e)
+		}
+		if l != &cfg.Dns.List && len(l.Resolvers) > 0 {
+			src.rs = newResolvers(l.Resolvers, s.metrics, s.logs)
 		}
-		_wg.Add(1)
-		defer _wg.Done()
+		r = append(r, src)
+	}
+	return r, nil
+}
+
+// Reload applies the resolvers, the cache limits and the lists of cfg. Lists which are gone are dropped, new
+// ones and the ones attaching other communities are loaded, the rules of a list follow its new resolvers.
+func (s *dnsSrv) Reload(cfg *config.AppCfg) error {
+	if s.cache == nil {
+		return ENotInitialized
+	}
+	lists, e := s.sources(cfg)
+	if e != nil {
+		return e
+	}
+
+	s.resolvers.setResolvers(cfg.Dns.Resolvers)
+	s.cache.rs.setResolvers(defaultResolvers(cfg))
+	s.cache.setLimits(cfg.Dns.Cache.MaxEntries, cfg.Dns.Cache.MinTtl)
+
+	s.m.Lock()
+	old := s.lists
+	s.lists = lists
+	s.cfg = cfg
+	s.m.Unlock()
 
-		dns.HandleFunc(".", _resolvers.proxyQuery)
-		if e := _server.ListenAndServe(); e != nil {
-			log.L().Fatal().Str("m", "dns").Err(e).Msg("Failed to bind DNS resolver")
+	var load []string
+	for _, src := range lists {
+		o := findSource(old, src.path)
+		if o == nil {
+			load = append(load, src.path)
+			continue
 		}
-	}(ctx)
+		if !slices.Equal(o.communities, src.communities) {
+			load = append(load, src.path)
+		}
+		if o.rs != src.rs {
+			s.cache.setListResolvers(src.path, o.rs, src.rs)
+		}
+	}
+	for _, o := range old {
+		if findSource(lists, o.path) == nil {
+			s.L().Info().Msgf("%s is no longer configured, dropping its domains", o.path)
+			s.cache.dropList(o.path)
+		}
+	}
 
-	_cache = newCache(cfg.Dns.Cache.MaxEntries, cfg.Dns.Cache.MinTtl, newResolvers(cfg.Dns.List.Resolvers), log.L())
+	var errs []error
+	for _, fn := range load {
+		if e = s.Load(fn); e != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", fn, e))
+		}
+	}
+	return errors.Join(errs...)
+}
 
-	return _cache.serve(ctx)
+func findSource(lists []*listSource, path string) *listSource {
+	for _, src := range lists {
+		if src.path == path {
+			return src
+		}
+	}
+	return nil
 }
 
-func Shutdown(ctx context.Context) error {
-	dns.HandleRemove(".")
+func (s *dnsSrv) start(srv *dns.Server) {
+	s.servers = append(s.servers, srv)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		if e := srv.ActivateAndServe(); e != nil {
+			s.L().Error().Err(e).Msgf("DNS resolver stopped (%s)", srv.Net)
+		}
+	}()
+}
+
+func (s *dnsSrv) startDoh(l net.Listener, path string) {
+	if path == "" {
+		path = "/dns-query"
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, s.serveDoh)
+	s.https = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	s.L().Info().Msgf("DNS-over-HTTPS is listening on https://%s%s", l.Addr().String(), path)
+
+	s.wg.Add(1)
+	go func(srv *http.Server) {
+		defer s.wg.Done()
+
+		if e := srv.Serve(l); e != nil && !errors.Is(e, http.ErrServerClosed) {
+			s.L().Error().Err(e).Msg("DNS-over-HTTPS stopped")
+		}
+	}(s.https)
+}
+
+// listenTls binds a TCP listener wrapped into TLS with the configured certificate.
+func listenTls(c *config.EncryptedListenCfg, protos ...string) (net.Listener, error) {
+	if c.Listen == nil {
+		return nil, fmt.Errorf("listen address is not set")
+	}
+	cert, e := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if e != nil {
+		return nil, e
+	}
+	return tls.Listen("tcp", c.Listen.String(), &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   protos,
+	})
+}
+
+func (s *dnsSrv) Shutdown(ctx context.Context) error {
+	if s.cache == nil {
+		return ENotInitialized
+	}
+	s.mux.HandleRemove(".")
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer func () {
 		cancel()
 	}()
 
-	if nil != _cancel {
-		_cancel()
-		_cancel = nil
+	if nil != s.cancel {
+		s.cancel()
+		s.cancel = nil
+	}
+	_ = s.cache.shutdown()
+	if fn := s.cfg.Dns.Cache.StateFile; fn != "" {
+		if e := s.cache.saveState(fn); e != nil {
+			s.L().Error().Err(e).Msgf("Failed to save %s", fn)
+		}
 	}
-	_ = _cache.shutdown()
 
-	if e := _server.ShutdownContext(ctx); e != nil && !errors.Is(e, context.Canceled) {
-		return e
+	for _, srv := range s.servers {
+		if e := srv.ShutdownContext(ctx); e != nil && !errors.Is(e, context.Canceled) {
+			return e
+		}
+	}
+	s.servers = nil
+	if s.https != nil {
+		if e := s.https.Shutdown(ctx); e != nil && !errors.Is(e, context.Canceled) {
+			return e
+		}
+		s.https = nil
 	}
 
-	_ = _cache.evictByGeneration(_cache.generation())
+	if s.cfg.Bgp.GracefulRestart == nil {
+		_ = s.cache.evictByGeneration("", s.cache.generation())
+	}
 
-	_wg.Wait()
+	s.wg.Wait()
 
 	return nil
 }
-func Register(fqdn string) error {
-	if _cache == nil {
+func (s *dnsSrv) Register(fqdn string) error {
+	if s.cache == nil {
 		return ENotInitialized
 	}
-	return _cache.register(fqdn)
+	return s.cache.register(fqdn, apiList, s.cache.generation(), nil)
 }
 
-func Unregister(fqdn string) error {
-	if _cache == nil {
+func (s *dnsSrv) Unregister(fqdn string) error {
+	if s.cache == nil {
 		return ENotInitialized
 	}
-	return _cache.unregister(fqdn)
+	return s.cache.unregister(fqdn)
 }
 
-func Load(fn string) error {
-	if _cache == nil {
+// Settle ends the start up: the domains restored from the state file which were registered through the API
+// are registered again, the restored entries no list or API domain tracks are withdrawn.
+func (s *dnsSrv) Settle() error {
+	if s.cache == nil {
 		return ENotInitialized
 	}
-	return _cache.load(fn)
+	s.cache.settle()
+	return nil
+}
+
+// Load (re)loads a list file, or every *.lst file of a list directory. A file of a configured directory is
+// loaded with the settings of the directory.
+func (s *dnsSrv) Load(fn string) error {
+	if s.cache == nil {
+		return ENotInitialized
+	}
+	fn = filepath.Clean(fn)
+	src := s.listFor(fn)
+	if inf, e := os.Stat(fn); e == nil && inf.IsDir() {
+		return s.cache.loadDir(fn, src)
+	}
+	return s.cache.load(fn, src)
+}
+
+// listFor finds the configured list fn is, or is a file of.
+func (s *dnsSrv) listFor(fn string) *listSource {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	if src := findSource(s.lists, fn); src != nil {
+		return src
+	}
+	for _, src := range s.lists {
+		if src.path == filepath.Dir(fn) {
+			return src
+		}
+	}
+	return nil
+}
+
+func (s *dnsSrv) Entries() ([]Entry, error) {
+	if s.cache == nil {
+		return nil, ENotInitialized
+	}
+	return s.cache.snapshot(), nil
+}
+
+// Entry returns the cache entry of fqdn.
+func (s *dnsSrv) Entry(fqdn string) (Entry, error) {
+	if s.cache == nil {
+		return Entry{}, ENotInitialized
+	}
+	if _, ok := dns.IsDomainName(fqdn); !ok {
+		return Entry{}, fmt.Errorf("'%s'. %w", fqdn, EInvalidFQDN)
+	}
+	if en, ok := s.cache.lookup(dns.CanonicalName(fqdn)); ok {
+		return en, nil
+	}
+	return Entry{}, fmt.Errorf("%s is %w", fqdn, ENotCached)
+}
+
+// Domains returns the rules of every list and the ones registered through the API.
+func (s *dnsSrv) Domains() ([]Domain, error) {
+	if s.cache == nil {
+		return nil, ENotInitialized
+	}
+	return s.cache.domains(), nil
+}
+
+// Refresh resolves every cached name again and returns how many there were.
+func (s *dnsSrv) Refresh() (int, error) {
+	if s.cache == nil {
+		return 0, ENotInitialized
+	}
+	return s.cache.refreshAll(), nil
+}
+
+// Holds returns what the cache announces by holder, the addresses Advance was last given for it.
+func (s *dnsSrv) Holds() (map[string]Hold, error) {
+	if s.cache == nil {
+		return nil, ENotInitialized
+	}
+	return s.cache.holds(), nil
+}
+
+// Failed receives a signal whenever an Advance or a Withdraw fails.
+func (s *dnsSrv) Failed() <-chan struct{} {
+	return s.failures.c
+}
+
+// TakeFailed returns the holders an Advance or a Withdraw failed for since the last call.
+func (s *dnsSrv) TakeFailed() []string {
+	return s.failures.take()
 }
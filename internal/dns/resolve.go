@@ -4,29 +4,39 @@ import (
 	"slices"
 )
 
-func (c *cache) resolve(w dns.ResponseWriter, q *dns.Msg, notfiyChanged bool)  {
+// resolve answers q and, when r is not nil, tracks the resolved addresses of the name as an entry of rule r.
+func (c *cache) resolve(w dns.ResponseWriter, q *dns.Msg, r *rule, notfiyChanged bool)  {
 	var a *dns.Msg
 	var e error
 
-	if a, e = c.rs.query(q); e != nil {
+	rs := c.rs
+	if r != nil && r.rs != nil {
+		rs = r.rs
+	}
+	if a, e = rs.query(q); e != nil {
 		c.L().Error().Err(e)
 		return
 	}
 
 	if w != nil {
-		if e = w.WriteMsg(a); e != nil {
+		if e = writeReply(w, q, a); e != nil {
 			c.L().Error().Err(e)
 			return
 		}
 	}
 
+	qt := q.Question[0].Qtype
+	if r == nil || (qt != dns.TypeA && qt != dns.TypeAAAA) {
+		return
+	}
+
 	i := slices.IndexFunc(a.Answer, func(rr dns.RR) bool {
-		return rr.Header().Rrtype == dns.TypeA
+		return rr.Header().Rrtype == qt
 	})
-	qn := q.Question[0].Name
+	qn := dns.CanonicalName(q.Question[0].Name)
 
 	if i > -1 {
-		if e = c.upsert(qn, a); e != nil {
+		if e = c.upsert(qn, a, r); e != nil {
 			c.L().Warn().Err(e)
 			return
 		}
@@ -37,10 +47,20 @@ func (c *cache) resolve(w dns.ResponseWriter, q *dns.Msg, notfiyChanged bool)  {
 	} else {
 		c.L().Trace().Msgf("Empty Answer for %s, RCode: %d", qn, a.Rcode)
 		if c.has(qn) {
-			if e = c.unregister(qn); e!=nil {
-				c.L().Error().Err(e).Msgf("Failed to unregister %s from resolve", qn)
+			// the other address family may still be there, drop only this one
+			if e = c.upsert(qn, a, r); e != nil {
+				c.L().Warn().Err(e)
+				return
+			}
+			if c.hasIps(qn) {
+				if notfiyChanged {
+					c.notfiyChanged(qn)
+				}
 				return
 			}
+			// the rule stays, the name is tracked again once it resolves
+			c.L().Debug().Msgf("Removing cache entry %s, nothing resolved", qn)
+			_ = c.entries().Remove(qn)
 			if notfiyChanged {
 				c.notfiyChanged(qn)
 			}
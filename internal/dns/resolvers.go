@@ -5,101 +5,124 @@ import (
     "errors"
     "fmt"
     "github.com/miekg/dns"
+    "github.com/red55/bgp-dns/internal/config"
     "github.com/red55/bgp-dns/internal/log"
-    "github.com/sourcegraph/conc/iter"
+    "github.com/red55/bgp-dns/internal/metrics"
     "net"
-    "os"
     "sync"
+    "sync/atomic"
+    "time"
 )
 
 type resolver struct {
-	addr *net.UDPAddr
-	ok   bool
+	addr upstream
+	ok   atomic.Bool
 }
 
 type resolvers struct {
 	log.Log
 	m  sync.RWMutex
 	rs *ring.Ring
+	metrics *metrics.Metrics
 }
 
-func newResolvers(c []*net.UDPAddr) *resolvers {
+func newResolvers(c []*config.ResolverCfg, m *metrics.Metrics, logs *log.Logs) *resolvers {
 	r := &resolvers{
-		Log: log.NewLog(log.L(), "resolvers"),
+		Log: logs.NewLog("resolvers"),
+		metrics: m,
 	}
 	r.setResolvers(c)
 
 	return r
 }
-func (rs *resolvers) setResolvers(c []*net.UDPAddr) {
+func (rs *resolvers) setResolvers(c []*config.ResolverCfg) {
+	ups := make([]upstream, 0, len(c))
+	for _, r := range c {
+		if u, e := newUpstream(r); e != nil {
+			rs.L().Error().Err(e).Msgf("Skipping resolver %s", r.String())
+		} else {
+			ups = append(ups, u)
+		}
+	}
+
 	rs.m.Lock()
 	defer rs.m.Unlock()
 
-	l := len(c)
-	rs.rs = ring.New(l)
-
-	iter.ForEach(c, func(a **net.UDPAddr) {
-		rs.rs.Value = &resolver{
-            addr: *a,
-            ok:   true,
-        }
+	rs.rs = ring.New(len(ups))
+	for _, u := range ups {
+		r := &resolver{addr: u}
+		r.ok.Store(true)
+		rs.rs.Value = r
 		rs.rs = rs.rs.Next()
-	})
+	}
 }
 
+// query asks the resolvers in turn, starting at the one which answered last, until one answers. A failed
+// resolver moves the ring on, so the next query starts at the one after it. Once every resolver failed the
+// errors of all of them are returned.
 func (rs *resolvers) query(q *dns.Msg) (*dns.Msg, error) {
 	rs.m.RLock()
-	defer rs.m.RUnlock()
+	head := rs.rs
+	rs.m.RUnlock()
 
-	if rs.rs == nil || rs.rs.Len() < 1 {
+	if head == nil || head.Len() < 1 {
 		return nil, fmt.Errorf("resolvers are empty, cannot resolve")
 	}
 
-	head := rs.rs
-	for {
-		srv := rs.rs.Value.(*resolver)
+	var errs []error
+	for cur := head; ; {
+		srv := cur.Value.(*resolver)
 		rs.L().Debug().Msgf("Using DNS %v for %s", srv.addr, q.Question[0].Name)
 
-		if a, e := dns.Exchange(q, srv.addr.String()); e == nil {
+		name := srv.addr.String()
+		start := time.Now()
+		a, e := srv.addr.exchange(q)
+		rs.metrics.UpstreamLatency.WithLabelValues(name).Observe(time.Since(start).Seconds())
+		if e == nil {
 			rs.L().Trace().Msgf("Got answer %d", len(a.Answer))
-			srv.ok = true
+			srv.ok.Store(true)
+			rs.metrics.UpstreamUp.WithLabelValues(name).Set(1)
 			return a, nil
-		} else {
-			srv.ok = false
-			rs.L().Error().Err(e).Msgf("queryDns failed for %v", q.Question)
-
-			rs.rs = rs.rs.Next()
-
-			if head == rs.rs {
-				rs.L().Error().Msg("All DNS Servers doesn't respond")
-
-				if errors.Is(e, os.ErrDeadlineExceeded) {
-					return nil, errors.Join(fmt.Errorf("DNS op for %v failed ", q.Question), e)
-				}
-
-				cause := e
-				if unwrap, ok := cause.(interface{ Unwrap() error }); ok {
-					cause = unwrap.Unwrap()
-				}
-
-				var opError *net.OpError
-
-				switch {
-				case errors.As(cause, &opError):
-					rs.L().Error().Msgf("DNS op %s failed with %s on destination %s", opError.Op, opError.Error(),
-						opError.Addr)
-					//return nil, cause
-				default:
-					var rCode = -1
-					if a != nil {
-						rCode = a.Rcode
-					}
-					rs.L().Error().Err(errors.Join(fmt.Errorf("failed to dail %s, Rcode: %x", srv.addr, rCode), e))
-					//return nil, errors.Join(fmt.Errorf("failed to dail %s, Rcode: %x", srv.addr, rCode), e)
-				}
-			}
 		}
+
+		srv.ok.Store(false)
+		rs.metrics.UpstreamFailures.WithLabelValues(name).Inc()
+		rs.metrics.UpstreamUp.WithLabelValues(name).Set(0)
+		rs.L().Error().Err(e).Msgf("queryDns failed for %v", q.Question)
+		errs = append(errs, fmt.Errorf("%s: %w", name, e))
+
+		next := cur.Next()
+		rs.advance(cur, next)
+		if next == head {
+			rs.L().Error().Msg("All DNS Servers doesn't respond")
+			return nil, errors.Join(append([]error{fmt.Errorf("DNS op for %v failed", q.Question)}, errs...)...)
+		}
+		cur = next
+	}
+}
+
+// advance moves the ring from the failed resolver to next, unless another query or setResolvers moved it
+// meanwhile.
+func (rs *resolvers) advance(failed, next *ring.Ring) {
+	rs.m.Lock()
+	defer rs.m.Unlock()
+
+	if rs.rs == failed {
+		rs.rs = next
+	}
+}
+
+// writeReply sends a to the client, truncating it to the client's UDP buffer size when needed.
+func writeReply(w dns.ResponseWriter, rq *dns.Msg, a *dns.Msg) error {
+	if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+		size := dns.MinMsgSize
+		if o := rq.IsEdns0(); o != nil {
+			size = int(o.UDPSize())
+		}
+		a = a.Copy()
+		a.Truncate(size)
 	}
+	return w.WriteMsg(a)
 }
 
 func (rs *resolvers) ResolveA(fqdn string) {
@@ -107,11 +130,12 @@ func (rs *resolvers) ResolveA(fqdn string) {
 }
 func (rs *resolvers) proxyQuery(w dns.ResponseWriter, rq *dns.Msg) {
 	rs.L().Debug().Msgf("Proxying request %s(%d) from: %s", rq.Question[0].Name, rq.Question[0].Qtype, w.RemoteAddr().String())
+	rs.metrics.Queries.WithLabelValues("proxy", dns.TypeToString[rq.Question[0].Qtype]).Inc()
 
 	if r, e := rs.query(rq); e != nil {
 		rs.L().Error().Msgf("Forwarding response to upstream responder failed %v", e)
 	} else {
-		if e = w.WriteMsg(r); e != nil {
+		if e = writeReply(w, rq, r); e != nil {
 			rs.L().Error().Msgf("Failed to write response to client %s, %v", w.RemoteAddr(), e)
 		}
 	}
@@ -0,0 +1,196 @@
+package dns
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/red55/bgp-dns/internal/config"
+	"github.com/red55/bgp-dns/internal/log"
+	"github.com/red55/bgp-dns/internal/metrics"
+)
+
+const testAnswer = "192.0.2.1"
+
+// answer replies to q with an A record of testAnswer.
+func answer(q *dns.Msg) *dns.Msg {
+	a := new(dns.Msg)
+	a.SetReply(q)
+	a.Answer = append(a.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+		A:   net.ParseIP(testAnswer),
+	})
+	return a
+}
+
+// newDoh starts a DNS-over-HTTPS stand-in answering with status, a DNS answer when it is 200.
+func newDoh(t *testing.T, status int) *httptest.Server {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		q := new(dns.Msg)
+		if e := q.Unpack(b); e != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		b, _ = answer(q).Pack()
+		w.Header().Set("Content-Type", dohMediaType)
+		_, _ = w.Write(b)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newDot starts a DNS-over-TLS stand-in with the certificate of srv and returns its address.
+func newDot(t *testing.T, srv *httptest.Server) string {
+	l, e := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: srv.TLS.Certificates})
+	if e != nil {
+		t.Fatal(e)
+	}
+	started := make(chan struct{})
+	s := &dns.Server{
+		Listener:          l,
+		Net:               "tcp-tls",
+		NotifyStartedFunc: func() { close(started) },
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, q *dns.Msg) {
+			_ = w.WriteMsg(answer(q))
+		}),
+	}
+	go func() {
+		_ = s.ActivateAndServe()
+	}()
+	<-started
+	t.Cleanup(func() {
+		_ = s.Shutdown()
+	})
+	return l.Addr().String()
+}
+
+// caFile writes the certificate of srv into a bundle resolvers verify the stand-ins with.
+func caFile(t *testing.T, srv *httptest.Server) string {
+	fn := filepath.Join(t.TempDir(), "ca.pem")
+	b := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if e := os.WriteFile(fn, b, 0600); e != nil {
+		t.Fatal(e)
+	}
+	return fn
+}
+
+// closedAddr returns an address nothing listens on.
+func closedAddr(t *testing.T) string {
+	l, e := net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+	return addr
+}
+
+func query(t *testing.T, rs *resolvers) (*dns.Msg, error) {
+	q := new(dns.Msg)
+	q.SetQuestion("example.com.", dns.TypeA)
+
+	type result struct {
+		a *dns.Msg
+		e error
+	}
+	done := make(chan result, 1)
+	go func() {
+		a, e := rs.query(q)
+		done <- result{a, e}
+	}()
+	select {
+	case r := <-done:
+		return r.a, r.e
+	case <-time.After(30 * time.Second):
+		t.Fatal("query did not return")
+		return nil, nil
+	}
+}
+
+func answered(t *testing.T, a *dns.Msg, e error) {
+	t.Helper()
+	if e != nil {
+		t.Fatalf("query failed: %v", e)
+	}
+	if len(a.Answer) != 1 || a.Answer[0].(*dns.A).A.String() != testAnswer {
+		t.Fatalf("unexpected answer %v", a.Answer)
+	}
+}
+
+func TestQueryDoh(t *testing.T) {
+	srv := newDoh(t, http.StatusOK)
+	rs := newResolvers([]*config.ResolverCfg{{Url: srv.URL, CaFile: caFile(t, srv)}}, metrics.New(), log.NewLogs(nil))
+
+	a, e := query(t, rs)
+	answered(t, a, e)
+}
+
+func TestQueryDot(t *testing.T) {
+	srv := newDoh(t, http.StatusOK)
+	rs := newResolvers([]*config.ResolverCfg{{Url: "tls://" + newDot(t, srv), CaFile: caFile(t, srv)}}, metrics.New(), log.NewLogs(nil))
+
+	a, e := query(t, rs)
+	answered(t, a, e)
+}
+
+func TestQueryFallsBack(t *testing.T) {
+	failing := newDoh(t, http.StatusServiceUnavailable)
+	srv := newDoh(t, http.StatusOK)
+	rs := newResolvers([]*config.ResolverCfg{
+		{Url: failing.URL, CaFile: caFile(t, failing)},
+		{Url: "tls://" + newDot(t, srv), CaFile: caFile(t, srv)},
+	}, metrics.New(), log.NewLogs(nil))
+
+	a, e := query(t, rs)
+	answered(t, a, e)
+	if u := rs.rs.Value.(*resolver).addr.String(); !strings.HasPrefix(u, "tls://") {
+		t.Fatalf("the next query starts at %s, not at the resolver which answered", u)
+	}
+}
+
+func TestQueryAllFail(t *testing.T) {
+	failing := newDoh(t, http.StatusInternalServerError)
+	srv := newDoh(t, http.StatusOK)
+	rs := newResolvers([]*config.ResolverCfg{
+		{Url: failing.URL, CaFile: caFile(t, failing)},
+		{Url: "tls://" + closedAddr(t), CaFile: caFile(t, srv)},
+	}, metrics.New(), log.NewLogs(nil))
+
+	_, e := query(t, rs)
+	if e == nil {
+		t.Fatal("query succeeded without a working resolver")
+	}
+	for _, s := range []string{"500 Internal Server Error", "tls://"} {
+		if !strings.Contains(e.Error(), s) {
+			t.Errorf("%q does not mention %s", e, s)
+		}
+	}
+
+	// a reload is not blocked by the failed query
+	done := make(chan struct{})
+	go func() {
+		rs.setResolvers([]*config.ResolverCfg{{Url: srv.URL, CaFile: caFile(t, srv)}})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("setResolvers is blocked")
+	}
+	a, e := query(t, rs)
+	answered(t, a, e)
+}
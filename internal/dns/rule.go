@@ -0,0 +1,202 @@
+package dns
+
+import (
+	"fmt"
+	"github.com/miekg/dns"
+	"github.com/red55/bgp-dns/internal/utils"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+type ruleKind int
+
+const (
+	// ruleExact tracks the name itself: example.com
+	ruleExact ruleKind = iota
+	// ruleWildcard tracks every name below, but not the name itself: *.example.com
+	ruleWildcard
+	// ruleSuffix tracks the name and every name below: .example.com
+	ruleSuffix
+	// ruleStatic announces an address or CIDR prefix as is: 192.0.2.1, 198.51.100.0/24 or 2001:db8::/32
+	ruleStatic
+)
+
+func (k ruleKind) String() string {
+	switch k {
+	case ruleWildcard:
+		return "wildcard"
+	case ruleSuffix:
+		return "suffix"
+	case ruleStatic:
+		return "static"
+	default:
+		return "exact"
+	}
+}
+
+// apiList is the list the domains registered through the API belong to, list reloads leave them alone.
+const apiList = "<api>"
+
+// rule is a line of a domain list. Every tracked cache entry is linked to the rule it was matched by.
+// A rule may come from several lists, lists maps each of them to the generation it was last loaded with;
+// the rule is unregistered once the last list drops it. Guarded by cache.m.
+type rule struct {
+	name  string
+	kind  ruleKind
+	lists map[string]uint64
+	// communities are the BGP communities each list attaches to the rule, the prefixes carry their union
+	communities map[string][]string
+	// announced are the communities a static rule was announced with
+	announced []string
+	// rs are the resolvers of the list which registered the rule first, nil for the default ones
+	rs *resolvers
+}
+
+// Domain is a point in time copy of a rule.
+type Domain struct {
+	Rule        string   `json:"rule"`
+	Kind        string   `json:"kind"`
+	Lists       []string `json:"lists"`
+	Communities []string `json:"communities"`
+}
+
+// parseEntry splits a list line into the entry and its annotations. The only annotation is
+// communities=65000:100,65000:1:2 which attaches BGP communities to the prefixes of the entry.
+func parseEntry(line string) (entry string, communities []string, e error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", nil, fmt.Errorf("'%s'. %w", line, EInvalidFQDN)
+	}
+	for _, a := range fields[1:] {
+		k, v, _ := strings.Cut(a, "=")
+		switch strings.ToLower(k) {
+		case "communities", "community":
+			communities = append(communities, strings.Split(v, ",")...)
+		default:
+			return "", nil, fmt.Errorf("'%s'. unknown annotation %s", line, k)
+		}
+	}
+	if communities, e = utils.NormalizeCommunities(communities); e != nil {
+		return "", nil, fmt.Errorf("'%s'. %w", line, e)
+	}
+	return fields[0], communities, nil
+}
+
+func parseRule(s string) (*rule, error) {
+	r := &rule{kind: ruleExact, lists: make(map[string]uint64), communities: make(map[string][]string)}
+	name := strings.TrimSpace(s)
+
+	if _, key, e := utils.ParsePrefix(name); e == nil {
+		r.kind = ruleStatic
+		r.name = key
+		return r, nil
+	}
+
+	switch {
+	case strings.HasPrefix(name, "*."):
+		r.kind = ruleWildcard
+		name = name[2:]
+	case strings.HasPrefix(name, "."):
+		r.kind = ruleSuffix
+		name = name[1:]
+	}
+
+	if len(name) < 2 {
+		return nil, fmt.Errorf("'%s'. %w", s, EInvalidFQDN)
+	}
+	if _, ok := dns.IsDomainName(name); !ok {
+		return nil, fmt.Errorf("'%s'. %w", s, EInvalidFQDN)
+	}
+	r.name = dns.CanonicalName(name)
+
+	return r, nil
+}
+
+func ruleKey(name string, kind ruleKind) string {
+	switch kind {
+	case ruleWildcard:
+		return "*." + name
+	case ruleSuffix:
+		return "." + name
+	default:
+		return name
+	}
+}
+
+// key identifies the rule, it is the rule as written in the list in canonical form.
+func (r *rule) key() string {
+	return ruleKey(r.name, r.kind)
+}
+
+// drop forgets the lists matching list ("" matches any) loaded with generation gen or earlier and reports
+// whether no list is left.
+func (r *rule) drop(list string, gen uint64) bool {
+	for l, g := range r.lists {
+		if (list == "" || l == list) && g <= gen {
+			delete(r.lists, l)
+			delete(r.communities, l)
+		}
+	}
+	return len(r.lists) == 0
+}
+
+// union returns the sorted communities attached by any list holding the rule.
+func (r *rule) union() []string {
+	var u []string
+	for _, cs := range r.communities {
+		u = append(u, cs...)
+	}
+	slices.Sort(u)
+	return slices.Compact(u)
+}
+
+// sources returns the sorted names of the lists holding the rule.
+func (r *rule) sources() []string {
+	l := make([]string, 0, len(r.lists))
+	for k := range r.lists {
+		l = append(l, k)
+	}
+	slices.Sort(l)
+	return l
+}
+
+func (r *rule) domain() Domain {
+	return Domain{
+		Rule:        r.key(),
+		Kind:        r.kind.String(),
+		Lists:       r.sources(),
+		Communities: r.union(),
+	}
+}
+
+// holds reports whether list file or directory path holds the rule.
+func (r *rule) holds(path string) bool {
+	for l := range r.lists {
+		if l == path || filepath.Dir(l) == path {
+			return true
+		}
+	}
+	return false
+}
+
+// tracksApex reports whether the name the rule is anchored at is tracked itself.
+func (r *rule) tracksApex() bool {
+	return r.kind == ruleExact || r.kind == ruleSuffix
+}
+
+// isStatic reports whether the rule is an address or prefix announced without resolving anything.
+func (r *rule) isStatic() bool {
+	return r.kind == ruleStatic
+}
+
+func (r *rule) matches(cn string) bool {
+	switch r.kind {
+	case ruleWildcard:
+		return cn != r.name && dns.IsSubDomain(r.name, cn)
+	case ruleSuffix:
+		return dns.IsSubDomain(r.name, cn)
+	default:
+		return cn == r.name
+	}
+}
@@ -0,0 +1,120 @@
+package dns
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		entry string
+		name  string
+		kind  ruleKind
+		key   string
+	}{
+		{"example.com", "example.com.", ruleExact, "example.com."},
+		{"Example.COM.", "example.com.", ruleExact, "example.com."},
+		{" example.com ", "example.com.", ruleExact, "example.com."},
+		{"*.example.com", "example.com.", ruleWildcard, "*.example.com."},
+		{".example.com", "example.com.", ruleSuffix, ".example.com."},
+	}
+	for _, tt := range tests {
+		r, e := parseRule(tt.entry)
+		if e != nil {
+			t.Errorf("%q: %v", tt.entry, e)
+			continue
+		}
+		if r.name != tt.name || r.kind != tt.kind || r.key() != tt.key {
+			t.Errorf("%q parsed as %q, kind %d, key %q", tt.entry, r.name, r.kind, r.key())
+		}
+	}
+}
+
+func TestParseRuleInvalid(t *testing.T) {
+	for _, entry := range []string{"", " ", "a", ".", "*.", "*.a", "example..com"} {
+		if _, e := parseRule(entry); !errors.Is(e, EInvalidFQDN) {
+			t.Errorf("%q: %v", entry, e)
+		}
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	tests := []struct {
+		entry string
+		name  string
+		want  bool
+	}{
+		{"example.com", "example.com.", true},
+		{"example.com", "www.example.com.", false},
+		{"example.com", "example.org.", false},
+		{"*.example.com", "example.com.", false},
+		{"*.example.com", "www.example.com.", true},
+		{"*.example.com", "a.b.example.com.", true},
+		{"*.example.com", "wwwexample.com.", false},
+		{".example.com", "example.com.", true},
+		{".example.com", "www.example.com.", true},
+		{".example.com", "a.b.example.com.", true},
+		{".example.com", "wwwexample.com.", false},
+		{".example.com", "com.", false},
+	}
+	for _, tt := range tests {
+		r, e := parseRule(tt.entry)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if got := r.matches(tt.name); got != tt.want {
+			t.Errorf("%q matches %q: %t, want %t", tt.entry, tt.name, got, tt.want)
+		}
+	}
+}
+
+// newRules returns a cache holding the rules of entries, nothing else is set up.
+func newRules(t *testing.T, entries ...string) *cache {
+	c := &cache{rules: make(map[string]*rule)}
+	for _, entry := range entries {
+		r, e := parseRule(entry)
+		if e != nil {
+			t.Fatal(e)
+		}
+		c.rules[r.key()] = r
+	}
+	return c
+}
+
+func TestMatchPrecedence(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		query   string
+		want    string
+	}{
+		{"exact", []string{"www.example.com"}, "www.example.com.", "www.example.com."},
+		{"exact does not cover below", []string{"example.com"}, "www.example.com.", ""},
+		{"exact over wildcard", []string{"*.example.com", "www.example.com"}, "www.example.com.",
+			"www.example.com."},
+		{"exact over suffix", []string{".example.com", "www.example.com"}, "www.example.com.", "www.example.com."},
+		{"wildcard over suffix of the same name", []string{".example.com", "*.example.com"}, "www.example.com.",
+			"*.example.com."},
+		{"suffix for the apex of a wildcard", []string{".example.com", "*.example.com"}, "example.com.",
+			".example.com."},
+		{"wildcard not for its apex", []string{"*.example.com"}, "example.com.", ""},
+		{"closest parent first", []string{".example.com", "*.b.example.com"}, "a.b.example.com.",
+			"*.b.example.com."},
+		{"farther parent", []string{"*.example.com", ".b.example.com"}, "a.c.example.com.", "*.example.com."},
+		{"suffix of a closer parent", []string{"*.example.com", ".b.example.com"}, "b.example.com.",
+			".b.example.com."},
+		{"nothing", []string{".example.com"}, "example.org.", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRules(t, tt.entries...).match(tt.query)
+			got := ""
+			if r != nil {
+				got = r.key()
+			}
+			if got != tt.want {
+				t.Errorf("%s matched %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,260 @@
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	stateVersion = 1
+	// defaultStateInterval is seconds
+	defaultStateInterval = 300
+)
+
+// state is the content of the state file: the cache entries with their answers in wire format and the
+// rules registered through the API, which no list brings back.
+type state struct {
+	Version    int          `json:"version"`
+	Generation uint64       `json:"generation"`
+	Registered []string     `json:"registered"`
+	Entries    []stateEntry `json:"entries"`
+}
+
+type stateEntry struct {
+	Fqdn        string    `json:"fqdn"`
+	Rule        string    `json:"rule"`
+	Lists       []string  `json:"lists"`
+	Generation  uint64    `json:"generation"`
+	Ttl         uint32    `json:"ttl"`
+	Expiration  time.Time `json:"expiration"`
+	Communities []string  `json:"communities"`
+	Answer      []byte    `json:"answer,omitempty"`
+	Answer6     []byte    `json:"answer6,omitempty"`
+}
+
+// cacheEntry unpacks the answers of the saved entry.
+func (se *stateEntry) cacheEntry() (*cacheEntry, error) {
+	ce := &cacheEntry{
+		ttl:         time.Duration(se.Ttl),
+		expiration:  se.Expiration,
+		rule:        se.Rule,
+		communities: se.Communities,
+	}
+	ce.setGeneration(se.Generation)
+	for _, b := range [][]byte{se.Answer, se.Answer6} {
+		if len(b) == 0 {
+			continue
+		}
+		m := new(dns.Msg)
+		if e := m.Unpack(b); e != nil {
+			return nil, fmt.Errorf("%s: %w", se.Fqdn, e)
+		}
+		ce.setAnswer(m)
+	}
+	if ce.answer == nil && ce.answer6 == nil {
+		return nil, fmt.Errorf("%s: %w", se.Fqdn, ENotAddressAnswer)
+	}
+	return ce, nil
+}
+
+// saveState writes the cache entries to fn, replacing it in one rename.
+func (c *cache) saveState(fn string) error {
+	st := state{
+		Version:    stateVersion,
+		Generation: c.generation(),
+	}
+	all := c.entries().GetALL(false)
+	c.m.RLock()
+	for k, rl := range c.rules {
+		if _, ok := rl.lists[apiList]; ok {
+			st.Registered = append(st.Registered, k)
+		}
+	}
+	for k, v := range all {
+		ce := v.(*cacheEntry)
+		se := stateEntry{
+			Fqdn:        k.(string),
+			Rule:        ce.rule,
+			Generation:  ce.generation(),
+			Ttl:         uint32(ce.ttl),
+			Expiration:  ce.expiration,
+			Communities: ce.communities,
+		}
+		if rl, ok := c.rules[ce.rule]; ok {
+			se.Lists = rl.sources()
+		}
+		var e error
+		if ce.answer != nil {
+			if se.Answer, e = ce.answer.Pack(); e != nil {
+				c.L().Warn().Err(e).Msgf("Not saving %s", se.Fqdn)
+				continue
+			}
+		}
+		if ce.answer6 != nil {
+			if se.Answer6, e = ce.answer6.Pack(); e != nil {
+				c.L().Warn().Err(e).Msgf("Not saving %s", se.Fqdn)
+				continue
+			}
+		}
+		st.Entries = append(st.Entries, se)
+	}
+	c.m.RUnlock()
+	slices.Sort(st.Registered)
+	slices.SortFunc(st.Entries, func(a, b stateEntry) int {
+		return strings.Compare(a.Fqdn, b.Fqdn)
+	})
+
+	b, e := json.Marshal(&st)
+	if e != nil {
+		return e
+	}
+	tmp, e := os.CreateTemp(filepath.Dir(fn), filepath.Base(fn)+".*")
+	if e != nil {
+		return e
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	if _, e = tmp.Write(b); e != nil {
+		_ = tmp.Close()
+		return e
+	}
+	if e = tmp.Sync(); e != nil {
+		_ = tmp.Close()
+		return e
+	}
+	if e = tmp.Close(); e != nil {
+		return e
+	}
+	c.L().Debug().Msgf("Saved %d entries to %s", len(st.Entries), fn)
+
+	return os.Rename(tmp.Name(), fn)
+}
+
+// restore reads the state file fn and announces the saved addresses right away. The entries wait for the
+// rules they were tracked through, register adopts them, settle drops the ones left. A missing file is an
+// empty state.
+func (c *cache) restore(fn string) error {
+	b, e := os.ReadFile(fn)
+	if errors.Is(e, os.ErrNotExist) {
+		return nil
+	}
+	if e != nil {
+		return e
+	}
+	var st state
+	if e = json.Unmarshal(b, &st); e != nil {
+		return fmt.Errorf("%s: %w", fn, e)
+	}
+	if st.Version != stateVersion {
+		return fmt.Errorf("%s: unsupported version %d", fn, st.Version)
+	}
+
+	c.gen.Store(st.Generation)
+	restored := make(map[string]map[string]*cacheEntry)
+	for i := range st.Entries {
+		se := &st.Entries[i]
+		ce, err := se.cacheEntry()
+		if err != nil {
+			c.L().Warn().Err(err).Msgf("Skipping entry of %s", fn)
+			continue
+		}
+		if restored[se.Rule] == nil {
+			restored[se.Rule] = make(map[string]*cacheEntry)
+		}
+		restored[se.Rule][se.Fqdn] = ce
+		c.advance(se.Fqdn, ce.Ips(), ce.communities)
+	}
+	c.m.Lock()
+	c.restored = restored
+	c.registered = st.Registered
+	c.m.Unlock()
+	c.L().Info().Msgf("Restored %d entries from %s", len(st.Entries), fn)
+
+	return nil
+}
+
+// adopt moves the restored entries tracked through rule r into the cache, announcing them again when r
+// attaches other communities than they were saved with. It reports whether the name r is anchored at was
+// among them.
+func (c *cache) adopt(r *rule) (apex bool) {
+	c.m.Lock()
+	pending := c.restored[r.key()]
+	delete(c.restored, r.key())
+	cs := r.union()
+	c.m.Unlock()
+
+	for fqdn, ce := range pending {
+		if !slices.Equal(ce.communities, cs) {
+			old := ce.communities
+			ce.communities = cs
+			c.advance(fqdn, ce.Ips(), cs)
+			c.withdraw(fqdn, ce.Ips(), old)
+		}
+		if e := c.entries().Set(fqdn, ce); e != nil {
+			c.L().Error().Err(e).Msgf("Failed to restore %s", fqdn)
+			continue
+		}
+		apex = apex || fqdn == r.name
+	}
+	return
+}
+
+// settle registers the restored API rules again and withdraws the restored entries no rule adopted. It is
+// called once the configured lists are loaded.
+func (c *cache) settle() {
+	c.m.Lock()
+	registered := c.registered
+	c.registered = nil
+	c.m.Unlock()
+
+	for _, k := range registered {
+		if e := c.register(k, apiList, c.generation(), nil); e != nil {
+			c.L().Warn().Err(e).Msgf("Failed to restore %s", k)
+		}
+	}
+
+	c.m.Lock()
+	left := c.restored
+	c.restored = nil
+	c.m.Unlock()
+
+	for k, es := range left {
+		for fqdn, ce := range es {
+			c.L().Debug().Msgf("Rule %s of restored %s is gone, withdrawing", k, fqdn)
+			c.withdraw(fqdn, ce.Ips(), ce.communities)
+		}
+	}
+}
+
+// persist saves the state file every StateInterval until ctx is done, Shutdown saves it a last time.
+func (s *dnsSrv) persist(ctx context.Context, fn string, interval time.Duration) {
+	defer s.wg.Done()
+
+	interval *= time.Second
+	if interval <= 0 {
+		interval = defaultStateInterval * time.Second
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if e := s.cache.saveState(fn); e != nil {
+				s.L().Error().Err(e).Msgf("Failed to save %s", fn)
+			}
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/red55/bgp-dns/internal/log"
+)
+
+// answerOf returns the answer to a query of type t for name holding ips.
+func answerOf(name string, t uint16, ips ...string) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(name, t)
+	for _, ip := range ips {
+		hdr := dns.RR_Header{Name: name, Rrtype: t, Class: dns.ClassINET, Ttl: 300}
+		if t == dns.TypeAAAA {
+			m.Answer = append(m.Answer, &dns.AAAA{Hdr: hdr, AAAA: net.ParseIP(ip)})
+		} else {
+			m.Answer = append(m.Answer, &dns.A{Hdr: hdr, A: net.ParseIP(ip)})
+		}
+	}
+	return m
+}
+
+// track adds the rule of entry from list to c and caches the answers for fqdn through it.
+func track(t *testing.T, c *cache, entry, list, fqdn string, answers ...*dns.Msg) *cacheEntry {
+	r, e := parseRule(entry)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if rl, ok := c.rules[r.key()]; ok {
+		r = rl
+	}
+	r.lists[list] = c.generation()
+	r.communities[list] = []string{"65000:1"}
+	c.rules[r.key()] = r
+
+	ce := newCacheEntry(answers[0], c.ttlFloor(), c.generation())
+	for _, a := range answers[1:] {
+		ce.setAnswer(a)
+	}
+	ce.rule = r.key()
+	ce.communities = r.union()
+	if e = c.entries().Set(fqdn, ce); e != nil {
+		t.Fatal(e)
+	}
+	return ce
+}
+
+// cached returns the entry of fqdn, nil when there is none.
+func cached(c *cache, fqdn string) *cacheEntry {
+	if v, e := c.entries().Get(fqdn); e == nil {
+		return v.(*cacheEntry)
+	}
+	return nil
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "state.json")
+	c := newListCache(newAnnouncer())
+	c.increaseGeneration()
+	saved := track(t, c, "example.com", "a.lst", "example.com.",
+		answerOf("example.com.", dns.TypeA, "192.0.2.1"), answerOf("example.com.", dns.TypeAAAA, "2001:db8::1"))
+	track(t, c, "*.example.org", "b.lst", "www.example.org.", answerOf("www.example.org.", dns.TypeA, "192.0.2.2"))
+	track(t, c, "*.example.net", apiList, "www.example.net.", answerOf("www.example.net.", dns.TypeA, "192.0.2.3"))
+	gen := c.increaseGeneration()
+	if e := c.saveState(fn); e != nil {
+		t.Fatal(e)
+	}
+
+	ann := newAnnouncer()
+	r := newListCache(ann)
+	if e := r.restore(fn); e != nil {
+		t.Fatal(e)
+	}
+	if r.generation() != gen {
+		t.Errorf("restored generation %d, want %d", r.generation(), gen)
+	}
+	// the saved addresses are announced before any list is loaded
+	want := []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "2001:db8::1"}
+	if got := ann.announced(); !slices.Equal(got, want) {
+		t.Fatalf("announced %v on restore, want %v", got, want)
+	}
+	if !slices.Equal(r.registered, []string{"*.example.net."}) {
+		t.Errorf("restored API rules %v", r.registered)
+	}
+
+	// the list brings the rule back, which adopts its entry as it was saved
+	if e := r.register("example.com", "a.lst", r.generation(), nil); e != nil {
+		t.Fatal(e)
+	}
+	ce := cached(r, "example.com.")
+	if ce == nil {
+		t.Fatal("example.com. was not adopted")
+	}
+	if !slices.Equal(ce.Ips(), saved.Ips()) || ce.ttl != saved.ttl || !ce.expiration.Equal(saved.expiration) ||
+		ce.generation() != saved.generation() || ce.rule != saved.rule {
+		t.Errorf("adopted %+v, saved %+v", ce.entry("example.com."), saved.entry("example.com."))
+	}
+
+	// the API rule is registered again, *.example.org is gone from the lists
+	r.settle()
+	if cached(r, "www.example.net.") == nil {
+		t.Error("www.example.net. was not adopted by the restored API rule")
+	}
+	if cached(r, "www.example.org.") != nil {
+		t.Error("www.example.org. was adopted without its rule")
+	}
+	want = []string{"192.0.2.1", "192.0.2.3", "2001:db8::1"}
+	if got := ann.announced(); !slices.Equal(got, want) {
+		t.Errorf("announced %v once settled, want %v", got, want)
+	}
+}
+
+func TestRestoreExpired(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "state.json")
+	c := newListCache(newAnnouncer())
+	ce := track(t, c, "*.example.com", "a.lst", "www.example.com.",
+		answerOf("www.example.com.", dns.TypeA, "192.0.2.1"))
+	expired := time.Now().Add(-time.Hour).Round(0)
+	ce.expiration = expired
+	if e := c.saveState(fn); e != nil {
+		t.Fatal(e)
+	}
+
+	ann := newAnnouncer()
+	r := newListCache(ann)
+	if e := r.restore(fn); e != nil {
+		t.Fatal(e)
+	}
+	// an expired entry is announced until it resolves again, it is due for a refresh right away
+	if got := ann.announced(); !slices.Equal(got, []string{"192.0.2.1"}) {
+		t.Errorf("announced %v, want the expired address", got)
+	}
+	if e := r.register("*.example.com", "a.lst", r.generation(), nil); e != nil {
+		t.Fatal(e)
+	}
+	ce = cached(r, "www.example.com.")
+	if ce == nil {
+		t.Fatal("the expired entry was not adopted")
+	}
+	if !ce.expiration.Equal(expired) {
+		t.Errorf("adopted with expiration %s, want %s", ce.expiration, expired)
+	}
+}
+
+func TestRestoreCorrupt(t *testing.T) {
+	c := newListCache(newAnnouncer())
+	track(t, c, "*.example.com", "a.lst", "www.example.com.", answerOf("www.example.com.", dns.TypeA, "192.0.2.1"))
+	good := filepath.Join(t.TempDir(), "state.json")
+	if e := c.saveState(good); e != nil {
+		t.Fatal(e)
+	}
+	b, e := os.ReadFile(good)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		content []byte
+	}{
+		{"truncated", b[:len(b)/2]},
+		{"empty", nil},
+		{"not JSON", []byte("entries: []")},
+		{"other version", []byte(`{"version":2,"entries":[]}`)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fn := filepath.Join(t.TempDir(), "state.json")
+			if e := os.WriteFile(fn, tt.content, 0o644); e != nil {
+				t.Fatal(e)
+			}
+			ann := newAnnouncer()
+			r := newListCache(ann)
+			if e := r.restore(fn); e == nil {
+				t.Fatal("restored a corrupt state file")
+			}
+			if got := ann.announced(); len(got) > 0 || len(r.restored) > 0 {
+				t.Errorf("announced %v from a corrupt state file", got)
+			}
+		})
+	}
+
+	t.Run("missing", func(t *testing.T) {
+		r := newListCache(newAnnouncer())
+		if e := r.restore(filepath.Join(t.TempDir(), "state.json")); e != nil {
+			t.Errorf("a missing state file failed: %v", e)
+		}
+	})
+
+	t.Run("bad entries skipped", func(t *testing.T) {
+		var st state
+		if e := json.Unmarshal(b, &st); e != nil {
+			t.Fatal(e)
+		}
+		broken, empty := st.Entries[0], st.Entries[0]
+		broken.Fqdn, broken.Answer = "broken.example.com.", broken.Answer[:5]
+		empty.Fqdn, empty.Answer = "empty.example.com.", nil
+		st.Entries = append(st.Entries, broken, empty)
+		fn := filepath.Join(t.TempDir(), "state.json")
+		if b, e = json.Marshal(&st); e == nil {
+			e = os.WriteFile(fn, b, 0o644)
+		}
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		ann := newAnnouncer()
+		r := newListCache(ann)
+		if e = r.restore(fn); e != nil {
+			t.Fatal(e)
+		}
+		if got := ann.announced(); !slices.Equal(got, []string{"192.0.2.1"}) {
+			t.Errorf("announced %v, want the address of the good entry", got)
+		}
+		if es := r.restored["*.example.com."]; len(es) != 1 || es["www.example.com."] == nil {
+			t.Errorf("restored %v, want www.example.com. alone", es)
+		}
+	})
+}
+
+func TestPersist(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "state.json")
+	c := newListCache(newAnnouncer())
+	track(t, c, "*.example.com", "a.lst", "www.example.com.", answerOf("www.example.com.", dns.TypeA, "192.0.2.1"))
+	s := &dnsSrv{Log: log.NewLogs(nil).NewLog("dns"), cache: c}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.wg.Add(1)
+	go s.persist(ctx, fn, 1)
+	defer func() {
+		cancel()
+		s.wg.Wait()
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		b, e := os.ReadFile(fn)
+		if e == nil {
+			var st state
+			if e = json.Unmarshal(b, &st); e != nil {
+				t.Fatalf("saved a corrupt state file: %v", e)
+			}
+			if len(st.Entries) != 1 || st.Entries[0].Fqdn != "www.example.com." {
+				t.Fatalf("saved %+v", st.Entries)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the state file was never saved")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	// nothing but the state file is left behind
+	if matches, _ := filepath.Glob(fn + ".*"); len(matches) > 0 {
+		t.Errorf("temporary files left behind: %v", matches)
+	}
+}
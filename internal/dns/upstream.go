@@ -0,0 +1,174 @@
+package dns
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/miekg/dns"
+	"github.com/red55/bgp-dns/internal/config"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	dohMediaType    = "application/dns-message"
+	upstreamTimeout = 5 * time.Second
+)
+
+// upstream is a transport to a single upstream resolver.
+type upstream interface {
+	exchange(q *dns.Msg) (*dns.Msg, error)
+	String() string
+}
+
+// plainUpstream talks classic DNS over UDP or TCP.
+type plainUpstream struct {
+	addr string
+	net  string
+}
+
+// tlsUpstream talks DNS over TLS (RFC 7858).
+type tlsUpstream struct {
+	addr   string
+	client *dns.Client
+}
+
+// httpsUpstream talks DNS over HTTPS (RFC 8484) using POST requests.
+type httpsUpstream struct {
+	url    string
+	client *http.Client
+}
+
+func newUpstream(c *config.ResolverCfg) (upstream, error) {
+	scheme, addr, e := c.Endpoint()
+	if e != nil {
+		return nil, e
+	}
+
+	switch scheme {
+	case config.SchemeUdp, config.SchemeTcp:
+		return &plainUpstream{addr: addr, net: scheme}, nil
+	}
+
+	tc, e := newTlsConfig(c)
+	if e != nil {
+		return nil, e
+	}
+	if scheme == config.SchemeTls {
+		return &tlsUpstream{
+			addr: addr,
+			client: &dns.Client{
+				Net:       "tcp-tls",
+				TLSConfig: tc,
+				Timeout:   upstreamTimeout,
+			},
+		}, nil
+	}
+
+	return &httpsUpstream{
+		url: addr,
+		client: &http.Client{
+			Timeout: upstreamTimeout,
+			Transport: &http.Transport{
+				TLSClientConfig:   tc,
+				ForceAttemptHTTP2: true,
+			},
+		},
+	}, nil
+}
+
+func newTlsConfig(c *config.ResolverCfg) (*tls.Config, error) {
+	tc := &tls.Config{
+		ServerName: c.TlsServerName(),
+		MinVersion: tls.VersionTLS12,
+	}
+	if c.CaFile == "" {
+		return tc, nil
+	}
+
+	pem, e := os.ReadFile(c.CaFile)
+	if e != nil {
+		return nil, fmt.Errorf("unable to read CA bundle of %s: %w", c.String(), e)
+	}
+	tc.RootCAs = x509.NewCertPool()
+	if !tc.RootCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", c.CaFile)
+	}
+
+	return tc, nil
+}
+
+// exchange sends q over UDP and repeats it over TCP when the answer comes back truncated,
+// so the cache always sees the complete RRset.
+func (u *plainUpstream) exchange(q *dns.Msg) (*dns.Msg, error) {
+	c := &dns.Client{Net: u.net}
+	a, _, e := c.Exchange(q, u.addr)
+	if e == nil && a.Truncated && u.net == config.SchemeUdp {
+		c.Net = config.SchemeTcp
+		a, _, e = c.Exchange(q, u.addr)
+	}
+	return a, e
+}
+
+func (u *plainUpstream) String() string {
+	if u.net == config.SchemeUdp {
+		return u.addr
+	}
+	return u.net + "://" + u.addr
+}
+
+func (u *tlsUpstream) exchange(q *dns.Msg) (*dns.Msg, error) {
+	a, _, e := u.client.Exchange(q, u.addr)
+	return a, e
+}
+
+func (u *tlsUpstream) String() string {
+	return "tls://" + u.addr
+}
+
+func (u *httpsUpstream) exchange(q *dns.Msg) (*dns.Msg, error) {
+	// RFC 8484 recommends ID 0 to make responses cacheable, the original ID is restored on the answer
+	m := q.Copy()
+	m.Id = 0
+	body, e := m.Pack()
+	if e != nil {
+		return nil, e
+	}
+
+	rq, e := http.NewRequest(http.MethodPost, u.url, bytes.NewReader(body))
+	if e != nil {
+		return nil, e
+	}
+	rq.Header.Set("Content-Type", dohMediaType)
+	rq.Header.Set("Accept", dohMediaType)
+
+	rsp, e := u.client.Do(rq)
+	if e != nil {
+		return nil, e
+	}
+	defer func() {
+		_ = rsp.Body.Close()
+	}()
+
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s responded with %s", u.url, rsp.Status)
+	}
+	if body, e = io.ReadAll(io.LimitReader(rsp.Body, dns.MaxMsgSize)); e != nil {
+		return nil, e
+	}
+
+	a := new(dns.Msg)
+	if e = a.Unpack(body); e != nil {
+		return nil, fmt.Errorf("invalid answer from %s: %w", u.url, e)
+	}
+	a.Id = q.Id
+
+	return a, nil
+}
+
+func (u *httpsUpstream) String() string {
+	return u.url
+}
@@ -0,0 +1,146 @@
+package fetcher
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// fetch downloads the list when it changed, verifies it and replaces File, then loads File.
+func (f *fetcher) fetch(ctx context.Context) error {
+	rq, e := http.NewRequestWithContext(ctx, http.MethodGet, f.cfg.Url, nil)
+	if e != nil {
+		return e
+	}
+	if f.etag != "" {
+		rq.Header.Set("If-None-Match", f.etag)
+	}
+	if f.modified != "" {
+		rq.Header.Set("If-Modified-Since", f.modified)
+	}
+
+	rs, e := f.client.Do(rq)
+	if e != nil {
+		return e
+	}
+	defer func() {
+		_ = rs.Body.Close()
+	}()
+
+	if rs.StatusCode == http.StatusNotModified {
+		f.L().Debug().Msgf("%s is not modified", f.cfg.Url)
+		f.metrics.ListFetches.WithLabelValues(f.cfg.Url, "unchanged").Inc()
+		return nil
+	}
+	if rs.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", rs.Status)
+	}
+
+	body, e := f.read(rs.Body)
+	if e != nil {
+		return e
+	}
+	if e = f.verify(ctx, body); e != nil {
+		return e
+	}
+	if e = f.save(body, rs.Header.Get("Last-Modified")); e != nil {
+		return e
+	}
+	f.etag = rs.Header.Get("ETag")
+	f.modified = rs.Header.Get("Last-Modified")
+	f.L().Info().Msgf("Fetched %s, %d bytes", f.cfg.Url, len(body))
+	f.metrics.ListFetches.WithLabelValues(f.cfg.Url, "updated").Inc()
+
+	return f.loader.Load(f.cfg.File)
+}
+
+// read reads at most MaxSize bytes of r and fails when there are more.
+func (f *fetcher) read(r io.Reader) ([]byte, error) {
+	body, e := io.ReadAll(io.LimitReader(r, f.maxSize()+1))
+	if e != nil {
+		return nil, e
+	}
+	if int64(len(body)) > f.maxSize() {
+		return nil, fmt.Errorf("%w (%d bytes)", ETooLarge, f.maxSize())
+	}
+	return body, nil
+}
+
+// verify compares the SHA-256 of body with Checksum, which is either the digest or the URL of a file holding it.
+func (f *fetcher) verify(ctx context.Context, body []byte) error {
+	want := strings.TrimSpace(f.cfg.Checksum)
+	if want == "" {
+		return nil
+	}
+	if strings.HasPrefix(want, "http://") || strings.HasPrefix(want, "https://") {
+		rq, e := http.NewRequestWithContext(ctx, http.MethodGet, want, nil)
+		if e != nil {
+			return e
+		}
+		rs, e := f.client.Do(rq)
+		if e != nil {
+			return fmt.Errorf("failed to fetch checksum: %w", e)
+		}
+		defer func() {
+			_ = rs.Body.Close()
+		}()
+		if rs.StatusCode != http.StatusOK {
+			return fmt.Errorf("failed to fetch checksum: unexpected status %s", rs.Status)
+		}
+		sum, e := io.ReadAll(io.LimitReader(rs.Body, 4096))
+		if e != nil {
+			return fmt.Errorf("failed to fetch checksum: %w", e)
+		}
+		// sha256sum writes "<digest>  <file name>"
+		fields := strings.Fields(string(sum))
+		if len(fields) == 0 {
+			return fmt.Errorf("%w: checksum file is empty", EChecksumMismatch)
+		}
+		want = fields[0]
+	}
+
+	got := sha256.Sum256(body)
+	if !strings.EqualFold(want, hex.EncodeToString(got[:])) {
+		return fmt.Errorf("%w: expected %s, got %x", EChecksumMismatch, want, got)
+	}
+	return nil
+}
+
+// save replaces File with body in one rename, so a crash never leaves a partial copy behind.
+func (f *fetcher) save(body []byte, lastModified string) error {
+	tmp, e := os.CreateTemp(filepath.Dir(f.cfg.File), filepath.Base(f.cfg.File)+".*")
+	if e != nil {
+		return e
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+
+	if e = tmp.Chmod(0o644); e != nil {
+		_ = tmp.Close()
+		return e
+	}
+	if _, e = tmp.Write(body); e != nil {
+		_ = tmp.Close()
+		return e
+	}
+	if e = tmp.Sync(); e != nil {
+		_ = tmp.Close()
+		return e
+	}
+	if e = tmp.Close(); e != nil {
+		return e
+	}
+	if t, e := http.ParseTime(lastModified); e == nil {
+		_ = os.Chtimes(tmp.Name(), time.Now(), t)
+	}
+
+	return os.Rename(tmp.Name(), f.cfg.File)
+}
@@ -0,0 +1,231 @@
+package fetcher
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/red55/bgp-dns/internal/config"
+	"github.com/red55/bgp-dns/internal/log"
+	"github.com/red55/bgp-dns/internal/metrics"
+)
+
+const (
+	testList = "example.com\nexample.org\n"
+	testEtag = `"v1"`
+)
+
+// loader counts the loads of the list file.
+type loader struct {
+	m     sync.Mutex
+	loads int
+}
+
+func (l *loader) Load(string) error {
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.loads++
+	return nil
+}
+
+func (l *loader) count() int {
+	l.m.Lock()
+	defer l.m.Unlock()
+	return l.loads
+}
+
+// origin serves testList at /list with testEtag and its digest at /sum, the requests to /list are counted by
+// the If-None-Match they carry.
+type origin struct {
+	*httptest.Server
+	m           sync.Mutex
+	conditional int
+	status      int
+}
+
+func newOrigin(t *testing.T) *origin {
+	o := &origin{status: http.StatusOK}
+	o.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/list":
+			o.m.Lock()
+			status := o.status
+			if r.Header.Get("If-None-Match") == testEtag {
+				o.conditional++
+				if status == http.StatusOK {
+					status = http.StatusNotModified
+				}
+			}
+			o.m.Unlock()
+			if status != http.StatusOK {
+				w.WriteHeader(status)
+				return
+			}
+			w.Header().Set("ETag", testEtag)
+			_, _ = w.Write([]byte(testList))
+		case "/sum":
+			_, _ = w.Write([]byte(digest(testList) + "  list\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(o.Close)
+	return o
+}
+
+// conditionals returns the number of requests for /list which carried testEtag.
+func (o *origin) conditionals() int {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return o.conditional
+}
+
+// fail makes /list answer with status from now on.
+func (o *origin) fail(status int) {
+	o.m.Lock()
+	defer o.m.Unlock()
+	o.status = status
+}
+
+func digest(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// newTestFetcher returns a fetcher of cfg, with no copy on disk, downloading through the client of o.
+func newTestFetcher(t *testing.T, o *origin, cfg *config.ListCfg) (*fetcher, *loader) {
+	l := &loader{}
+	cfg.Url = o.URL + "/list"
+	cfg.File = filepath.Join(t.TempDir(), "remote.lst")
+	f := New(cfg, l, metrics.New(), log.NewLogs(nil)).(*fetcher)
+	f.client = o.Client()
+	return f, l
+}
+
+// content returns what File holds, "" when there is no copy.
+func content(t *testing.T, f *fetcher) string {
+	b, e := os.ReadFile(f.cfg.File)
+	if errors.Is(e, os.ErrNotExist) {
+		return ""
+	}
+	if e != nil {
+		t.Fatal(e)
+	}
+	return string(b)
+}
+
+func TestFetchNotModified(t *testing.T) {
+	o := newOrigin(t)
+	f, l := newTestFetcher(t, o, &config.ListCfg{})
+
+	if e := f.fetch(context.Background()); e != nil {
+		t.Fatal(e)
+	}
+	if got := content(t, f); got != testList {
+		t.Fatalf("saved %q, want %q", got, testList)
+	}
+	if f.etag != testEtag {
+		t.Errorf("ETag %q, want %q", f.etag, testEtag)
+	}
+
+	if e := f.fetch(context.Background()); e != nil {
+		t.Fatal(e)
+	}
+	if got := o.conditionals(); got != 1 {
+		t.Errorf("%d conditional requests, want 1", got)
+	}
+	if got := l.count(); got != 1 {
+		t.Errorf("loaded %d times, an unchanged list must not be loaded again", got)
+	}
+}
+
+func TestFetchRejected(t *testing.T) {
+	o := newOrigin(t)
+	tests := []struct {
+		name string
+		cfg  config.ListCfg
+		want error
+	}{
+		{"larger than MaxSize", config.ListCfg{MaxSize: int64(len(testList)) - 1}, ETooLarge},
+		{"inline checksum mismatch", config.ListCfg{Checksum: digest("other")}, EChecksumMismatch},
+		{"checksum URL mismatch", config.ListCfg{Checksum: o.URL + "/list"}, EChecksumMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, l := newTestFetcher(t, o, &tt.cfg)
+			if e := f.fetch(context.Background()); !errors.Is(e, tt.want) {
+				t.Fatalf("fetch: %v, want %v", e, tt.want)
+			}
+			if got := content(t, f); got != "" {
+				t.Errorf("saved %q of a rejected list", got)
+			}
+			if l.count() != 0 || f.etag != "" {
+				t.Errorf("rejected list loaded %d times, ETag %q", l.count(), f.etag)
+			}
+		})
+	}
+}
+
+func TestFetchAccepted(t *testing.T) {
+	o := newOrigin(t)
+	tests := []struct {
+		name string
+		cfg  config.ListCfg
+	}{
+		{"exactly MaxSize", config.ListCfg{MaxSize: int64(len(testList))}},
+		{"inline checksum", config.ListCfg{Checksum: strings.ToUpper(digest(testList))}},
+		{"checksum URL", config.ListCfg{Checksum: o.URL + "/sum"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, l := newTestFetcher(t, o, &tt.cfg)
+			if e := f.fetch(context.Background()); e != nil {
+				t.Fatal(e)
+			}
+			if got := content(t, f); got != testList || l.count() != 1 {
+				t.Errorf("saved %q, loaded %d times", got, l.count())
+			}
+		})
+	}
+}
+
+func TestFetchKeepsLastGoodCopy(t *testing.T) {
+	o := newOrigin(t)
+	f, l := newTestFetcher(t, o, &config.ListCfg{})
+	if e := f.fetch(context.Background()); e != nil {
+		t.Fatal(e)
+	}
+
+	o.fail(http.StatusInternalServerError)
+	if e := f.fetch(context.Background()); e == nil {
+		t.Fatal("fetch of a failing origin succeeded")
+	}
+	if got := content(t, f); got != testList {
+		t.Errorf("%q left after a failed fetch, want the last good copy", got)
+	}
+	if f.etag != testEtag || l.count() != 1 {
+		t.Errorf("failed fetch changed ETag to %q, loaded %d times", f.etag, l.count())
+	}
+
+	// a copy which fails verification does not replace the good one either
+	o.fail(http.StatusOK)
+	f.etag, f.cfg.Checksum = "", digest("other")
+	if e := f.fetch(context.Background()); !errors.Is(e, EChecksumMismatch) {
+		t.Fatalf("fetch: %v, want %v", e, EChecksumMismatch)
+	}
+	if got := content(t, f); got != testList {
+		t.Errorf("%q left after a rejected fetch, want the last good copy", got)
+	}
+	matches, _ := filepath.Glob(f.cfg.File + ".*")
+	if len(matches) > 0 {
+		t.Errorf("temporary copies left behind: %v", matches)
+	}
+}
@@ -0,0 +1,27 @@
+package fetcher
+
+import (
+	"context"
+	"time"
+)
+
+func (f *fetcher) loop(ctx context.Context) {
+	defer f.wg.Done()
+
+	t := time.NewTimer(0)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			f.L().Debug().Msgf("shutdown received")
+			return
+		case <-t.C:
+			if e := f.fetch(ctx); e != nil {
+				f.metrics.ListFetches.WithLabelValues(f.cfg.Url, "failed").Inc()
+				f.L().Error().Err(e).Msgf("Failed to fetch %s, keeping %s", f.cfg.Url, f.cfg.File)
+			}
+			t.Reset(f.refresh())
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package fetcher
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/red55/bgp-dns/internal/config"
+	"github.com/red55/bgp-dns/internal/log"
+	"github.com/red55/bgp-dns/internal/metrics"
+)
+
+const (
+	defaultRefresh = time.Hour
+	defaultMaxSize = 10 << 20
+	fetchTimeout   = time.Minute
+)
+
+var (
+	ETooLarge         = errors.New("list exceeds MaxSize")
+	EChecksumMismatch = errors.New("list checksum mismatch")
+)
+
+// Loader loads a domain list file after it has been downloaded.
+type Loader interface {
+	Load(fn string) error
+}
+
+// Fetcher downloads a remote domain list into its File every Refresh and feeds the file to a Loader. A failed
+// download leaves the last good copy and the domains loaded from it in place.
+type Fetcher interface {
+	Serve(ctx context.Context) error
+	Shutdown(ctx context.Context) error
+}
+
+type fetcher struct {
+	log.Log
+	cfg     *config.ListCfg
+	loader  Loader
+	metrics *metrics.Metrics
+	client  *http.Client
+	// etag and modified are the validators of the last good copy
+	etag     string
+	modified string
+	wg       sync.WaitGroup
+	cancel   context.CancelFunc
+}
+
+func New(cfg *config.ListCfg, loader Loader, m *metrics.Metrics, logs *log.Logs) Fetcher {
+	return &fetcher{
+		Log:     logs.NewLog("fetcher"),
+		cfg:     cfg,
+		loader:  loader,
+		metrics: m,
+	}
+}
+
+func (f *fetcher) Serve(ctx context.Context) error {
+	if f.cfg.File == "" {
+		return fmt.Errorf("list %s has no File to keep the downloaded copy in", f.cfg.Url)
+	}
+	tc := &tls.Config{MinVersion: tls.VersionTLS12}
+	if f.cfg.CaFile != "" {
+		pem, e := os.ReadFile(f.cfg.CaFile)
+		if e != nil {
+			return fmt.Errorf("failed to read CA of %s: %w", f.cfg.Url, e)
+		}
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates in %s", f.cfg.CaFile)
+		}
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = tc
+	f.client = &http.Client{Transport: t, Timeout: fetchTimeout}
+
+	if inf, e := os.Stat(f.cfg.File); e == nil {
+		// the copy on disk is as good as its modification time, a restart does not download it again
+		f.modified = inf.ModTime().UTC().Format(http.TimeFormat)
+	}
+
+	if nil != f.cancel {
+		f.cancel()
+	}
+	ctx, f.cancel = context.WithCancel(ctx)
+	f.wg.Add(1)
+	go f.loop(ctx)
+
+	return nil
+}
+
+func (f *fetcher) Shutdown(ctx context.Context) error {
+	if f.cancel != nil {
+		f.cancel()
+		f.cancel = nil
+	}
+	f.wg.Wait()
+	return nil
+}
+
+func (f *fetcher) refresh() time.Duration {
+	if f.cfg.Refresh > 0 {
+		return f.cfg.Refresh * time.Second
+	}
+	return defaultRefresh
+}
+
+func (f *fetcher) maxSize() int64 {
+	if f.cfg.MaxSize > 0 {
+		return f.cfg.MaxSize
+	}
+	return defaultMaxSize
+}
@@ -2,13 +2,9 @@ package fswatcher
 
 import (
 	"context"
-	"github.com/fsnotify/fsnotify"
-	"github.com/red55/bgp-dns/internal/config"
-	"github.com/red55/bgp-dns/internal/dns"
 )
 
 func (w *fsWatcher) loop(ctx context.Context) {
-	var cfg = ctx.Value("cfg").(*config.AppCfg)
 	w.wg.Add(1)
 	defer w.wg.Done()
 
@@ -23,9 +19,9 @@ func (w *fsWatcher) loop(ctx context.Context) {
 				return
 			}
 			w.L().Trace().Msgf("Event: %s for %s", ev.Op.String(), ev.Name )
-			if ev.Has(fsnotify.Create) || ev.Has(fsnotify.Write) {
-				if e := dns.Load(cfg.Dns.List.File); e !=nil {
-					w.L().Error().Err(e)
+			if w.isList(ev) {
+				if e := w.loader.Load(ev.Name); e !=nil {
+					w.L().Error().Err(e).Msgf("Failed to load %s", ev.Name)
 				}
 			}
 		case e, ok := <- w.w.Errors:
@@ -4,68 +4,106 @@ import (
 	"context"
 	"fmt"
 	"github.com/fsnotify/fsnotify"
-	"github.com/red55/bgp-dns/internal/config"
 	"github.com/red55/bgp-dns/internal/log"
 	"github.com/red55/bgp-dns/internal/loop"
 	"os"
+	"path/filepath"
+	"slices"
 	"sync"
 )
 
+// Loader reloads a domain list, or the configuration, after its file has changed.
+type Loader interface {
+	Load(fn string) error
+}
+
+// Watcher watches domain list files and directories and feeds every changed list file to a Loader. In a
+// directory only *.lst files are lists, a removed one is fed as well so the Loader can drop its domains.
+// A file is watched through its directory, so replacing it by a rename is noticed too.
+type Watcher interface {
+	Serve(ctx context.Context) error
+	Shutdown(ctx context.Context) error
+}
+
 type fsWatcher struct {
 	loop.Loop
 	log.Log
+	files []string
+	dirs []string
+	loader Loader
 	w *fsnotify.Watcher
 	wg sync.WaitGroup
 	cancel context.CancelFunc
 }
 
-
-var (
-	_watcher *fsWatcher
-)
-
-
-func Serve(ctx context.Context) (e error) {
-	var cfg = ctx.Value("cfg").(*config.AppCfg)
-
-	_watcher = &fsWatcher{
-		Loop:   loop.NewLoop(1),
-		Log:    log.NewLog(log.L(), "fswatcher"),
+func New(paths []string, loader Loader, logs *log.Logs) Watcher {
+	return &fsWatcher{
+		Loop:   loop.NewLoop(1, logs),
+		Log:    logs.NewLog("fswatcher"),
+		files:  paths,
+		loader: loader,
 		w:      nil,
 		wg:     sync.WaitGroup{},
 		cancel: nil,
 	}
-	if _watcher.w, e = fsnotify.NewWatcher(); e != nil {
-		return
-	}
+}
 
-	var inf os.FileInfo
-	if inf, e = os.Stat(cfg.Dns.List.File); e != nil || inf.IsDir() {
-		e = fmt.Errorf("%s is not a file", cfg.Dns.List.File)
+func (w *fsWatcher) Serve(ctx context.Context) (e error) {
+	if w.w, e = fsnotify.NewWatcher(); e != nil {
 		return
 	}
 
-	if e = _watcher.w.Add(cfg.Dns.List.File); e != nil {
-		return
+	var files []string
+	w.dirs = nil
+	for _, p := range w.files {
+		p = filepath.Clean(p)
+		var inf os.FileInfo
+		if inf, e = os.Stat(p); e != nil {
+			_ = w.w.Close()
+			e = fmt.Errorf("%s is not a file or directory", p)
+			return
+		}
+		watched := p
+		if !inf.IsDir() {
+			watched = filepath.Dir(p)
+		}
+		if e = w.w.Add(watched); e != nil {
+			_ = w.w.Close()
+			return
+		}
+		if inf.IsDir() {
+			w.dirs = append(w.dirs, p)
+		} else {
+			files = append(files, p)
+		}
 	}
+	w.files = files
 
-	ctx, _watcher.cancel = context.WithCancel(ctx)
-	go _watcher.loop(ctx)
+	ctx, w.cancel = context.WithCancel(ctx)
+	go w.loop(ctx)
 
 	return
 }
 
-func Shutdown(ctx context.Context) (e error) {
-	if _watcher.w == nil {
+func (w *fsWatcher) Shutdown(ctx context.Context) (e error) {
+	if w.w == nil {
 		return
 	}
 
-	if _watcher.cancel != nil {
-		_watcher.cancel()
-		_watcher.cancel = nil
+	if w.cancel != nil {
+		w.cancel()
+		w.cancel = nil
 	}
-	e = _watcher.w.Close()
-	_watcher.wg.Wait()
-	_watcher.w = nil
+	e = w.w.Close()
+	w.wg.Wait()
+	w.w = nil
 	return
-}
\ No newline at end of file
+}
+
+// isList reports whether the event is about a watched list file or a list file of a watched directory.
+func (w *fsWatcher) isList(ev fsnotify.Event) bool {
+	if slices.Contains(w.files, ev.Name) {
+		return ev.Has(fsnotify.Create) || ev.Has(fsnotify.Write)
+	}
+	return filepath.Ext(ev.Name) == ".lst" && slices.Contains(w.dirs, filepath.Dir(ev.Name))
+}
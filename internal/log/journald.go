@@ -0,0 +1,129 @@
+package log
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+const journalSocket = "/run/systemd/journal/socket"
+
+// journald sends the events to the journal over its native protocol, every field of an event becomes a field
+// of the journal entry: module is MODULE.
+type journald struct {
+	conn net.Conn
+	tag  string
+}
+
+func newJournald(addr, tag string) (*journald, error) {
+	if addr == "" {
+		addr = journalSocket
+	}
+	c, e := net.Dial("unixgram", addr)
+	if e != nil {
+		return nil, e
+	}
+	return &journald{conn: c, tag: tag}, nil
+}
+
+func (j *journald) Write(p []byte) (int, error) {
+	return j.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (j *journald) WriteLevel(l zerolog.Level, p []byte) (int, error) {
+	var evt map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(p))
+	d.UseNumber()
+	if e := d.Decode(&evt); e != nil {
+		return 0, fmt.Errorf("cannot decode event: %w", e)
+	}
+
+	var b bytes.Buffer
+	field(&b, "PRIORITY", fmt.Sprint(priority(l)))
+	field(&b, "SYSLOG_IDENTIFIER", j.tag)
+	if m, ok := evt[zerolog.MessageFieldName].(string); ok {
+		field(&b, "MESSAGE", m)
+	}
+	keys := make([]string, 0, len(evt))
+	for k := range evt {
+		switch k {
+		case zerolog.MessageFieldName, zerolog.LevelFieldName, zerolog.TimestampFieldName:
+			// the journal has these already
+		default:
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v, ok := evt[k].(string)
+		if !ok {
+			bs, _ := json.Marshal(evt[k])
+			v = string(bs)
+		}
+		field(&b, journalKey(k), v)
+	}
+
+	if _, e := j.conn.Write(b.Bytes()); e != nil {
+		return 0, e
+	}
+	return len(p), nil
+}
+
+func (j *journald) Close() error {
+	return j.conn.Close()
+}
+
+// field appends a field of the entry, in the binary form when the value spans lines.
+func field(b *bytes.Buffer, k, v string) {
+	b.WriteString(k)
+	if !strings.Contains(v, "\n") {
+		b.WriteByte('=')
+		b.WriteString(v)
+		b.WriteByte('\n')
+		return
+	}
+	b.WriteByte('\n')
+	_ = binary.Write(b, binary.LittleEndian, uint64(len(v)))
+	b.WriteString(v)
+	b.WriteByte('\n')
+}
+
+// journalKey makes k a valid journal field name: upper case letters, digits and underscores, not starting
+// with an underscore, which is kept for the fields of the journal itself.
+func journalKey(k string) string {
+	r := []byte(strings.ToUpper(k))
+	for i, c := range r {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			r[i] = '_'
+		}
+	}
+	s := strings.TrimLeft(string(r), "_")
+	if s == "" || s[0] >= '0' && s[0] <= '9' {
+		s = "F" + s
+	}
+	return s
+}
+
+// priority is the syslog severity of l.
+func priority(l zerolog.Level) int {
+	switch l {
+	case zerolog.TraceLevel, zerolog.DebugLevel:
+		return 7
+	case zerolog.WarnLevel:
+		return 4
+	case zerolog.ErrorLevel:
+		return 3
+	case zerolog.FatalLevel:
+		return 2
+	case zerolog.PanicLevel:
+		return 0
+	default:
+		return 6
+	}
+}
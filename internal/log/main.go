@@ -1,69 +1,93 @@
 package log
 
 import (
-	"fmt"
 	"github.com/red55/bgp-dns/internal/config"
 	"github.com/rs/zerolog"
-	"os"
+	"sync"
 	"sync/atomic"
-	"time"
 )
 
+// Log is the logger of a module. It logs through the logger of its Logs at the level of the module there, so
+// a new logger or level set on the Logs reaches every copy.
 type Log struct {
-	l atomic.Pointer[zerolog.Logger]
-	lvl atomic.Value
+	s *state
 }
 
-func (l *Log) setLogger(log *zerolog.Logger) {
-	l.l.Store(log)
+type state struct {
+	logs   *Logs
+	module string
+	// level is the level of the module in logs
+	level *atomic.Int32
+	// cached is the logger last made for the root and the level of the module
+	cached atomic.Pointer[cachedLogger]
+}
+
+type cachedLogger struct {
+	root *zerolog.Logger
+	lvl  zerolog.Level
+	l    zerolog.Logger
 }
 
 func (l *Log) L() *zerolog.Logger {
-	r := l.l.Load()
-	return r;
+	root, lvl := l.s.logs.root.Load(), zerolog.Level(l.s.level.Load())
+	if c := l.s.cached.Load(); c != nil && c.root == root && c.lvl == lvl {
+		return &c.l
+	}
+	c := &cachedLogger{root: root, lvl: lvl, l: root.With().Str(ModuleFieldName, l.s.module).Logger()}
+	if lvl != zerolog.NoLevel {
+		c.l = c.l.Level(lvl)
+	}
+	l.s.cached.Store(c)
+	return &c.l
 }
 
+// SetLevel makes the module of the logger log at lvl, zerolog.NoLevel gives it back its configured level.
 func (l *Log) SetLevel(lvl zerolog.Level) {
-	ll := l.L().Level(lvl)
-	l.l.Store(&ll)
-	l.lvl.Store(lvl)
+	if lvl == zerolog.NoLevel {
+		_ = l.s.logs.ResetModuleLevel(l.s.module)
+	} else {
+		_ = l.s.logs.SetModuleLevel(l.s.module, lvl)
+	}
 }
+
 func (l *Log) Level() zerolog.Level {
-	return l.lvl.Load().(zerolog.Level)
+	return l.L().GetLevel()
 }
+
 var (
-	_logger Log
+	// _logger is the process wide logger made by Init, _out is where it writes
+	_logger atomic.Pointer[zerolog.Logger]
+	_out    = new(switchWriter)
+	_root   sync.Once
 )
 
-func NewLog(l *zerolog.Logger, moduleName string) (r Log) {
-	if moduleName == "" {
-		moduleName = "main"
-	}
+func init() {
+	// stay silent until Init is called, e.g. when embedded via pkg/bgpdns
+	l := zerolog.Nop()
+	_logger.Store(&l)
+}
 
-	mn := fmt.Sprintf("%-10s", moduleName)
-	nl := l.With().Str("m", mn).Logger()
-	r.setLogger(&nl)
+// Init opens the outputs of cfg.Log and makes the process wide logger write to them. Called again, e.g. on
+// reload, it replaces the outputs of every logger made from it, a failure keeps the current ones. The levels
+// are up to the Logs of every daemon, Init lets through whatever they log.
+func Init(cfg *config.AppCfg) error {
+	s, e := newSink(&cfg.Log)
+	if e != nil {
+		return e
+	}
+	_root.Do(func() {
+		zerolog.SetGlobalLevel(zerolog.TraceLevel)
+		l := zerolog.New(_out).With().Timestamp().Logger()
+		_logger.Store(&l)
+	})
+	if old := _out.swap(s); old != nil {
+		_ = old.Close()
+	}
 
-	return
-}
-func Init(cfg *config.AppCfg) {
-	l := zerolog.New(zerolog.ConsoleWriter{
-		Out: os.Stdout,
-		TimeFormat: time.RFC3339,
-		PartsOrder: []string{
-			zerolog.TimestampFieldName,
-			"m",
-			zerolog.LevelFieldName,
-			zerolog.CallerFieldName,
-			zerolog.MessageFieldName,
-		},
-		FieldsExclude: []string {
-			"m",
-		},
-	}).Level(cfg.Log.Level).With().Timestamp().Logger()
-	_logger.setLogger(&l)
-}
-func L()* zerolog.Logger {
-	return _logger.L()
+	return nil
 }
 
+// L returns the process wide logger.
+func L() *zerolog.Logger {
+	return _logger.Load()
+}
@@ -0,0 +1,171 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/red55/bgp-dns/internal/config"
+	"github.com/rs/zerolog"
+)
+
+var EUnknownModule = errors.New("unknown module")
+
+// Logs makes the module loggers of a daemon and keeps the level of every module, so the levels can be changed
+// while the loggers run. def and levels are the configured levels, set the ones changed at runtime which take
+// precedence until the next Configure. Loggers read the level of their module when they log, nothing is kept
+// per logger.
+type Logs struct {
+	root    atomic.Pointer[zerolog.Logger]
+	m       sync.Mutex
+	def     *zerolog.Level
+	levels  map[string]zerolog.Level
+	set     map[string]zerolog.Level
+	modules map[string]*atomic.Int32
+}
+
+// NewLogs makes the loggers of a daemon log through l, nil stays silent.
+func NewLogs(l *zerolog.Logger) *Logs {
+	r := &Logs{
+		set:     make(map[string]zerolog.Level),
+		modules: make(map[string]*atomic.Int32),
+	}
+	r.SetLogger(l)
+	return r
+}
+
+// SetLogger makes every module logger log through l, nil stays silent.
+func (r *Logs) SetLogger(l *zerolog.Logger) {
+	if l == nil {
+		nop := zerolog.Nop()
+		l = &nop
+	}
+	r.root.Store(l)
+}
+
+// NewLog makes the logger of a module, it logs at the level of the module.
+func (r *Logs) NewLog(module string) Log {
+	if module == "" {
+		module = "main"
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return Log{s: &state{logs: r, module: module, level: r.cell(module)}}
+}
+
+// cell returns the level of module, made at the level levelOf gives it.
+func (r *Logs) cell(module string) *atomic.Int32 {
+	c, ok := r.modules[module]
+	if !ok {
+		c = new(atomic.Int32)
+		lvl, _ := r.levelOf(module)
+		c.Store(int32(lvl))
+		r.modules[module] = c
+	}
+	return c
+}
+
+// levelOf returns the level module logs at, nothing is configured before Configure unless set at runtime.
+func (r *Logs) levelOf(module string) (zerolog.Level, bool) {
+	if lvl, ok := r.set[module]; ok {
+		return lvl, true
+	}
+	if lvl, ok := r.levels[module]; ok {
+		return lvl, true
+	}
+	if r.def != nil {
+		return *r.def, true
+	}
+	return zerolog.NoLevel, false
+}
+
+// apply sets the level of every module.
+func (r *Logs) apply() {
+	for m, c := range r.modules {
+		lvl, _ := r.levelOf(m)
+		c.Store(int32(lvl))
+	}
+}
+
+// Configure applies the levels of c, dropping the ones changed at runtime.
+func (r *Logs) Configure(c *config.LogCfg) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	def := c.Level
+	r.def = &def
+	r.levels = make(map[string]zerolog.Level, len(c.Modules))
+	for m, lvl := range c.Modules {
+		r.levels[strings.ToLower(m)] = lvl
+	}
+	clear(r.set)
+	r.apply()
+}
+
+// SetModuleLevel makes module log at lvl until the configuration is applied again or the level is reset.
+func (r *Logs) SetModuleLevel(module string, lvl zerolog.Level) error {
+	module = strings.ToLower(module)
+	if !slices.Contains(config.LogModules, module) {
+		return fmt.Errorf("%w %s", EUnknownModule, module)
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.set[module] = lvl
+	r.apply()
+	return nil
+}
+
+// SetLevels makes every module log at lvl until the configuration is applied again or the levels are reset.
+func (r *Logs) SetLevels(lvl zerolog.Level) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	for _, m := range config.LogModules {
+		r.set[m] = lvl
+	}
+	r.apply()
+}
+
+// ResetModuleLevel gives module back its configured level.
+func (r *Logs) ResetModuleLevel(module string) error {
+	module = strings.ToLower(module)
+	if !slices.Contains(config.LogModules, module) {
+		return fmt.Errorf("%w %s", EUnknownModule, module)
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	delete(r.set, module)
+	r.apply()
+	return nil
+}
+
+// ResetLevels gives every module back its configured level.
+func (r *Logs) ResetLevels() {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	clear(r.set)
+	r.apply()
+}
+
+// Levels returns the level every module logs at.
+func (r *Logs) Levels() map[string]zerolog.Level {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	res := make(map[string]zerolog.Level, len(config.LogModules))
+	for _, m := range config.LogModules {
+		if lvl, ok := r.levelOf(m); ok {
+			res[m] = lvl
+		} else {
+			res[m] = r.root.Load().GetLevel()
+		}
+	}
+	return res
+}
@@ -0,0 +1,187 @@
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/red55/bgp-dns/internal/config"
+	"github.com/rs/zerolog"
+)
+
+// ModuleFieldName is the key of the module a line was logged by.
+const ModuleFieldName = "module"
+
+// sink writes the events to every configured output, closers are the outputs to close once it is replaced.
+type sink struct {
+	zerolog.LevelWriter
+	closers []io.Closer
+}
+
+func (s *sink) Close() (e error) {
+	for _, c := range s.closers {
+		if err := c.Close(); err != nil && e == nil {
+			e = err
+		}
+	}
+	return
+}
+
+// switchWriter is the writer of the process wide logger. Loggers derived from it keep it, so Init replaces
+// the outputs of every logger at once.
+type switchWriter struct {
+	m sync.RWMutex
+	s *sink
+}
+
+func (w *switchWriter) Write(p []byte) (int, error) {
+	return w.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (w *switchWriter) WriteLevel(l zerolog.Level, p []byte) (int, error) {
+	w.m.RLock()
+	defer w.m.RUnlock()
+
+	if w.s == nil {
+		return len(p), nil
+	}
+	return w.s.WriteLevel(l, p)
+}
+
+// swap installs s and returns the sink it replaced, nothing writes to that one any more.
+func (w *switchWriter) swap(s *sink) *sink {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	old := w.s
+	w.s = s
+	return old
+}
+
+// newSink opens the outputs of c, stdout when there are none.
+func newSink(c *config.LogCfg) (s *sink, e error) {
+	outputs := c.Outputs
+	if len(outputs) == 0 {
+		outputs = []*config.LogOutputCfg{{Type: config.LogOutputStdout}}
+	}
+
+	s = new(sink)
+	ws := make([]io.Writer, 0, len(outputs))
+	for _, o := range outputs {
+		format := c.Format
+		if o.Format != "" {
+			format = o.Format
+		}
+		w, closer, err := newOutput(o, format)
+		if err != nil {
+			_ = s.Close()
+			return nil, fmt.Errorf("log output %s: %w", o.Type, err)
+		}
+		if closer != nil {
+			s.closers = append(s.closers, closer)
+		}
+		if o.Level != nil {
+			w = &zerolog.FilteredLevelWriter{Writer: w, Level: *o.Level}
+		}
+		ws = append(ws, w)
+	}
+	s.LevelWriter = zerolog.MultiLevelWriter(ws...)
+
+	return s, nil
+}
+
+// newOutput opens the output o writing in format, closer is nil for the standard streams.
+func newOutput(o *config.LogOutputCfg, format string) (w zerolog.LevelWriter, closer io.Closer, e error) {
+	color := false
+	switch o.Type {
+	case config.LogOutputStdout, "":
+		w, color = zerolog.LevelWriterAdapter{Writer: os.Stdout}, true
+	case config.LogOutputStderr:
+		w, color = zerolog.LevelWriterAdapter{Writer: os.Stderr}, true
+	case config.LogOutputFile:
+		f := newRotatingFile(o.Path, o.MaxSize, o.MaxAge*time.Second, o.MaxBackups)
+		w, closer = zerolog.LevelWriterAdapter{Writer: f}, f
+	case config.LogOutputSyslog:
+		if w, e = dialSyslog(o.Network, o.Address, tag(o), o.Facility); e != nil {
+			return nil, nil, e
+		}
+		closer = w.(io.Closer)
+	case config.LogOutputJournald:
+		// the journal keeps the fields of the events, there is no format to choose
+		j, err := newJournald(o.Address, tag(o))
+		if err != nil {
+			return nil, nil, err
+		}
+		return j, j, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported output %s", o.Type)
+	}
+
+	if format != config.LogFormatJson {
+		w = &console{cw: consoleWriter(color, o.Type != config.LogOutputSyslog), out: w}
+	}
+	return w, closer, nil
+}
+
+// tag is the name syslog and the journal know the process by.
+func tag(o *config.LogOutputCfg) string {
+	if o.Tag != "" {
+		return o.Tag
+	}
+	return filepath.Base(os.Args[0])
+}
+
+func consoleWriter(color, timestamp bool) zerolog.ConsoleWriter {
+	parts := []string{
+		zerolog.TimestampFieldName,
+		ModuleFieldName,
+		zerolog.LevelFieldName,
+		zerolog.CallerFieldName,
+		zerolog.MessageFieldName,
+	}
+	if !timestamp {
+		// syslog stamps the lines itself
+		parts = parts[1:]
+	}
+	return zerolog.ConsoleWriter{
+		NoColor:    !color,
+		TimeFormat: time.RFC3339,
+		PartsOrder: parts,
+		FieldsExclude: []string{
+			ModuleFieldName,
+		},
+		FormatPrepare: func(evt map[string]interface{}) error {
+			if m, ok := evt[ModuleFieldName]; ok {
+				evt[ModuleFieldName] = fmt.Sprintf("%-10s", m)
+			}
+			return nil
+		},
+	}
+}
+
+// console formats the JSON events for people and passes them on along with their level.
+type console struct {
+	cw  zerolog.ConsoleWriter
+	out zerolog.LevelWriter
+}
+
+func (c *console) Write(p []byte) (int, error) {
+	return c.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (c *console) WriteLevel(l zerolog.Level, p []byte) (int, error) {
+	var b bytes.Buffer
+	cw := c.cw
+	cw.Out = &b
+	if _, e := cw.Write(p); e != nil {
+		return 0, e
+	}
+	if _, e := c.out.WriteLevel(l, b.Bytes()); e != nil {
+		return 0, e
+	}
+	return len(p), nil
+}
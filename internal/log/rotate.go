@@ -0,0 +1,105 @@
+package log
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// rotatingFile is a log file renamed aside once it grows beyond maxSize bytes or has been written to for
+// maxAge, zero disables either. maxBackups of the renamed files are kept, all of them when zero.
+type rotatingFile struct {
+	m          sync.Mutex
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	f          *os.File
+	size       int64
+	opened     time.Time
+}
+
+const backupTimeFormat = "20060102T150405.000"
+
+func newRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) *rotatingFile {
+	return &rotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxAge:     maxAge,
+		maxBackups: maxBackups,
+	}
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.f == nil {
+		if e := r.open(); e != nil {
+			return 0, e
+		}
+	}
+	if r.size > 0 && (r.maxSize > 0 && r.size+int64(len(p)) > r.maxSize ||
+		r.maxAge > 0 && time.Since(r.opened) >= r.maxAge) {
+		if e := r.rotate(); e != nil {
+			return 0, e
+		}
+	}
+	n, e := r.f.Write(p)
+	r.size += int64(n)
+	return n, e
+}
+
+func (r *rotatingFile) Close() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.f == nil {
+		return nil
+	}
+	e := r.f.Close()
+	r.f = nil
+	return e
+}
+
+// open appends to the file, a file left by a previous run counts towards maxSize.
+func (r *rotatingFile) open() error {
+	f, e := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if e != nil {
+		return e
+	}
+	inf, e := f.Stat()
+	if e != nil {
+		_ = f.Close()
+		return e
+	}
+	r.f, r.size, r.opened = f, inf.Size(), time.Now()
+	return nil
+}
+
+// rotate renames the file to path.<time> and starts a new one, dropping the oldest backups.
+func (r *rotatingFile) rotate() error {
+	if e := r.f.Close(); e != nil {
+		return e
+	}
+	r.f = nil
+	if e := os.Rename(r.path, r.path+"."+time.Now().Format(backupTimeFormat)); e != nil {
+		return e
+	}
+	if e := r.open(); e != nil {
+		return e
+	}
+
+	if r.maxBackups > 0 {
+		backups, _ := filepath.Glob(r.path + ".*")
+		// the time format sorts in time order
+		slices.Sort(backups)
+		for len(backups) > r.maxBackups {
+			_ = os.Remove(backups[0])
+			backups = backups[1:]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package log
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// write writes the lines to r, a few milliseconds apart so the backups they end up in are named apart.
+func write(t *testing.T, r *rotatingFile, lines ...string) {
+	for _, l := range lines {
+		time.Sleep(2 * time.Millisecond)
+		if _, e := r.Write([]byte(l + "\n")); e != nil {
+			t.Fatal(e)
+		}
+	}
+}
+
+// contents returns the content of the backups of fn, oldest first, followed by the content of fn.
+func contents(t *testing.T, fn string) []string {
+	backups, e := filepath.Glob(fn + ".*")
+	if e != nil {
+		t.Fatal(e)
+	}
+	slices.Sort(backups)
+	var r []string
+	for _, b := range append(backups, fn) {
+		c, e := os.ReadFile(b)
+		if e != nil {
+			t.Fatal(e)
+		}
+		r = append(r, strings.TrimSuffix(string(c), "\n"))
+	}
+	return r
+}
+
+func TestRotateSize(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "bgp-dns.log")
+	// a file left by a previous run counts towards the size
+	if e := os.WriteFile(fn, []byte("old\n"), 0o644); e != nil {
+		t.Fatal(e)
+	}
+	r := newRotatingFile(fn, 10, 0, 0)
+	defer func() {
+		_ = r.Close()
+	}()
+
+	write(t, r, "one", "two", "three")
+	// a line longer than the limit is not split, it starts a file of its own
+	write(t, r, "a line longer than ten bytes", "four")
+	// two and three fill up the ten bytes exactly
+	want := []string{"old\none", "two\nthree", "a line longer than ten bytes", "four"}
+	if got := contents(t, fn); !slices.Equal(got, want) {
+		t.Errorf("files hold %q, want %q", got, want)
+	}
+}
+
+func TestRotateAge(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "bgp-dns.log")
+	r := newRotatingFile(fn, 0, time.Hour, 0)
+	defer func() {
+		_ = r.Close()
+	}()
+
+	write(t, r, "one", "two")
+	r.opened = r.opened.Add(-time.Hour)
+	write(t, r, "three", "four")
+	want := []string{"one\ntwo", "three\nfour"}
+	if got := contents(t, fn); !slices.Equal(got, want) {
+		t.Errorf("files hold %q, want %q", got, want)
+	}
+}
+
+func TestRotateMaxBackups(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		maxBackups int
+		want       []string
+	}{
+		{"pruned", 2, []string{"three", "four", "five"}},
+		{"all kept", 0, []string{"one", "two", "three", "four", "five"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fn := filepath.Join(t.TempDir(), "bgp-dns.log")
+			r := newRotatingFile(fn, 1, 0, tt.maxBackups)
+			defer func() {
+				_ = r.Close()
+			}()
+
+			write(t, r, "one", "two", "three", "four", "five")
+			if got := contents(t, fn); !slices.Equal(got, tt.want) {
+				t.Errorf("files hold %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !windows && !plan9
+
+package log
+
+import (
+	"log/syslog"
+
+	"github.com/red55/bgp-dns/internal/config"
+	"github.com/rs/zerolog"
+)
+
+// dialSyslog connects to the syslog at addr over network, the local one when network is empty.
+func dialSyslog(network, addr, tag, facility string) (zerolog.LevelWriter, error) {
+	f, ok := config.SyslogFacilities[facility]
+	if !ok {
+		f = config.SyslogFacilities["daemon"]
+	}
+	w, e := syslog.Dial(network, addr, syslog.Priority(f<<3)|syslog.LOG_INFO, tag)
+	if e != nil {
+		return nil, e
+	}
+	return zerolog.SyslogLevelWriter(w), nil
+}
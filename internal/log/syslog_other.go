@@ -0,0 +1,13 @@
+//go:build windows || plan9
+
+package log
+
+import (
+	"errors"
+
+	"github.com/rs/zerolog"
+)
+
+func dialSyslog(network, addr, tag, facility string) (zerolog.LevelWriter, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
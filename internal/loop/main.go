@@ -14,8 +14,8 @@ type Loop struct {
 	l *log.Log
 }
 
-func NewLoop(bufSize int) Loop {
-	l := log.NewLog(log.L(), "loop")
+func NewLoop(bufSize int, logs *log.Logs) Loop {
+	l := logs.NewLog("loop")
 	return Loop{
 		opCh: make(chan *loopOp, bufSize), //, bufSize
 		l: &l,
@@ -0,0 +1,162 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"sync/atomic"
+)
+
+const namespace = "bgpdns"
+
+// Metrics holds the collectors of one daemon instance in a registry of its own.
+type Metrics struct {
+	registry  *prometheus.Registry
+	cacheSize atomic.Pointer[func() int]
+
+	Queries          *prometheus.CounterVec
+	UpstreamLatency  *prometheus.HistogramVec
+	UpstreamFailures *prometheus.CounterVec
+	UpstreamUp       *prometheus.GaugeVec
+	CacheEvictions   prometheus.Counter
+	CacheRefreshes   prometheus.Counter
+	CacheCycles      prometheus.Counter
+	Announced        prometheus.Counter
+	Withdrawn        prometheus.Counter
+	Prefixes         prometheus.Gauge
+	PeerState        *prometheus.GaugeVec
+	ListFetches      *prometheus.CounterVec
+	Reconciliations  *prometheus.CounterVec
+	Corrections      *prometheus.CounterVec
+	AnnounceFailures *prometheus.CounterVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		Queries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "dns",
+			Name:      "queries_total",
+			Help:      "Client queries handled, by handler (proxy or tracked) and query type.",
+		}, []string{"handler", "qtype"}),
+		UpstreamLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "resolver",
+			Name:      "latency_seconds",
+			Help:      "Upstream resolver round trip time.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+		}, []string{"resolver"}),
+		UpstreamFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "resolver",
+			Name:      "failures_total",
+			Help:      "Failed upstream exchanges.",
+		}, []string{"resolver"}),
+		UpstreamUp: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "resolver",
+			Name:      "up",
+			Help:      "Whether the last exchange with the upstream resolver succeeded.",
+		}, []string{"resolver"}),
+		CacheEvictions: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "cache",
+			Name:      "evictions_total",
+			Help:      "Cache entries evicted or removed.",
+		}),
+		CacheRefreshes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "cache",
+			Name:      "refreshes_total",
+			Help:      "Expired cache entries resolved again.",
+		}),
+		CacheCycles: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "cache",
+			Name:      "refresh_cycles_total",
+			Help:      "Passes of the cache refresh loop.",
+		}),
+		Announced: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "bgp",
+			Name:      "announced_total",
+			Help:      "Prefixes announced to the RIB.",
+		}),
+		Withdrawn: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "bgp",
+			Name:      "withdrawn_total",
+			Help:      "Prefixes withdrawn from the RIB.",
+		}),
+		Prefixes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "bgp",
+			Name:      "prefixes",
+			Help:      "Prefixes currently announced.",
+		}),
+		PeerState: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "bgp",
+			Name:      "peer_state",
+			Help:      "BGP session state of the peer: 0 unknown, 1 idle, 2 connect, 3 active, 4 opensent, 5 openconfirm, 6 established.",
+		}, []string{"peer"}),
+		ListFetches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "list",
+			Name:      "fetches_total",
+			Help:      "Downloads of remote lists, by list URL and result (updated, unchanged or failed).",
+		}, []string{"list", "result"}),
+		Reconciliations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "bgp",
+			Name:      "reconciliations_total",
+			Help:      "Reconciliations of the held prefixes and the RIB with the cache, by result (clean, corrected or failed).",
+		}, []string{"result"}),
+		Corrections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "bgp",
+			Name:      "corrections_total",
+			Help:      "Prefixes corrected by reconciliations, by kind (reindexed, announced, updated or removed).",
+		}, []string{"kind"}),
+		AnnounceFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "cache",
+			Name:      "announce_failures_total",
+			Help:      "Changes of the cache the speaker failed to apply, by operation (advance or withdraw).",
+		}, []string{"op"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.Queries, m.UpstreamLatency, m.UpstreamFailures, m.UpstreamUp,
+		m.CacheEvictions, m.CacheRefreshes, m.CacheCycles,
+		m.Announced, m.Withdrawn, m.Prefixes, m.PeerState, m.ListFetches,
+		m.Reconciliations, m.Corrections, m.AnnounceFailures,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "cache",
+			Name:      "entries",
+			Help:      "Entries in the DNS cache.",
+		}, func() float64 {
+			if f := m.cacheSize.Load(); f != nil {
+				return float64((*f)())
+			}
+			return 0
+		}),
+	)
+
+	return m
+}
+
+// SetCacheSize sets the function reporting the current number of cache entries.
+func (m *Metrics) SetCacheSize(f func() int) {
+	m.cacheSize.Store(&f)
+}
+
+// Handler serves the registry in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
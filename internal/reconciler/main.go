@@ -0,0 +1,181 @@
+// Package reconciler repairs what a lost Advance or Withdraw leaves behind: it compares the holders of every
+// prefix with what the cache holds, and the RIB with the held prefixes, and corrects the difference.
+package reconciler
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/red55/bgp-dns/internal/bgp"
+	"github.com/red55/bgp-dns/internal/config"
+	"github.com/red55/bgp-dns/internal/dns"
+	"github.com/red55/bgp-dns/internal/log"
+	"github.com/red55/bgp-dns/internal/metrics"
+)
+
+const (
+	// defaultInterval is seconds
+	defaultInterval = 60
+	// confirmDelay is how long a drifting prefix is given to settle before it is corrected, an Advance or a
+	// Withdraw on its way makes it drift for a moment
+	confirmDelay = 2 * time.Second
+)
+
+// Cache tells what every holder announces, and signals on Failed when the speaker failed to apply a change of
+// it.
+type Cache interface {
+	Holds() (map[string]dns.Hold, error)
+	Failed() <-chan struct{}
+	TakeFailed() []string
+}
+
+// Speaker holds the prefixes and puts them into the RIB.
+type Speaker interface {
+	Drift(holds map[string]dns.Hold) (map[string]string, error)
+	Reconcile(ctx context.Context, holds map[string]dns.Hold, prefixes []string) (bgp.Corrections, error)
+}
+
+// Reconciler reconciles the held prefixes and the RIB with the cache every Bgp.ReconcileInterval, whenever
+// Reconcile is called and as soon as the speaker failed to apply a change of the cache.
+type Reconciler interface {
+	Serve(ctx context.Context) error
+	Shutdown(ctx context.Context) error
+	Reconcile(ctx context.Context) (bgp.Corrections, error)
+}
+
+type reconciler struct {
+	log.Log
+	// m lets one reconciliation run at a time
+	m        sync.Mutex
+	interval time.Duration
+	cache    Cache
+	bgp      Speaker
+	metrics  *metrics.Metrics
+	wg       sync.WaitGroup
+	cancel   context.CancelFunc
+}
+
+func New(cfg *config.BgpCfg, c Cache, b Speaker, m *metrics.Metrics, logs *log.Logs) Reconciler {
+	r := &reconciler{
+		Log:      logs.NewLog("reconciler"),
+		interval: cfg.ReconcileInterval * time.Second,
+		cache:    c,
+		bgp:      b,
+		metrics:  m,
+	}
+	if r.interval <= 0 {
+		r.interval = defaultInterval * time.Second
+	}
+	return r
+}
+
+func (r *reconciler) Serve(ctx context.Context) error {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	ctx, r.cancel = context.WithCancel(ctx)
+	r.wg.Add(1)
+	go r.loop(ctx)
+
+	return nil
+}
+
+func (r *reconciler) Shutdown(ctx context.Context) error {
+	if r.cancel != nil {
+		r.cancel()
+		r.cancel = nil
+	}
+	r.wg.Wait()
+	return nil
+}
+
+func (r *reconciler) loop(ctx context.Context) {
+	defer r.wg.Done()
+
+	t := time.NewTicker(r.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.L().Debug().Msgf("shutdown received")
+			return
+		case <-t.C:
+		case <-r.cache.Failed():
+			if failed := r.cache.TakeFailed(); len(failed) > 0 {
+				r.L().Info().Msgf("Reconciling now, the speaker failed to apply the changes of %s",
+					strings.Join(failed, ", "))
+			}
+		}
+		if _, e := r.Reconcile(ctx); e != nil && ctx.Err() == nil {
+			r.L().Error().Err(e).Msg("Failed to reconcile the RIB with the cache")
+		}
+	}
+}
+
+// Reconcile corrects the holders of the prefixes which drift from the cache, and keep drifting confirmDelay
+// later, then the RIB, and returns what it corrected.
+func (r *reconciler) Reconcile(ctx context.Context) (c bgp.Corrections, e error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	defer func() {
+		switch {
+		case e != nil:
+			r.metrics.Reconciliations.WithLabelValues("failed").Inc()
+		case c.Empty():
+			r.metrics.Reconciliations.WithLabelValues("clean").Inc()
+		default:
+			r.metrics.Reconciliations.WithLabelValues("corrected").Inc()
+		}
+	}()
+
+	holds, drifts, e := r.drift()
+	if e != nil {
+		return
+	}
+	var prefixes []string
+	if len(drifts) > 0 {
+		r.L().Debug().Msgf("%d prefixes drift from the cache, checking them again in %s", len(drifts), confirmDelay)
+		select {
+		case <-ctx.Done():
+			return c, ctx.Err()
+		case <-time.After(confirmDelay):
+		}
+		var again map[string]string
+		if holds, again, e = r.drift(); e != nil {
+			return
+		}
+		for k, d := range again {
+			if drifts[k] == d {
+				prefixes = append(prefixes, k)
+			}
+		}
+	}
+
+	c, e = r.bgp.Reconcile(ctx, holds, prefixes)
+	for kind, l := range map[string][]string{
+		"reindexed": c.Reindexed, "announced": c.Announced, "updated": c.Updated, "removed": c.Removed,
+	} {
+		r.metrics.Corrections.WithLabelValues(kind).Add(float64(len(l)))
+	}
+	if !c.Empty() {
+		r.L().Info().Msgf("Reconciled with the cache: %d prefixes reindexed, %d announced again, %d updated, %d removed",
+			len(c.Reindexed), len(c.Announced), len(c.Updated), len(c.Removed))
+	}
+
+	return
+}
+
+// drift returns what the cache holds and how the held prefixes differ from it.
+func (r *reconciler) drift() (map[string]dns.Hold, map[string]string, error) {
+	holds, e := r.cache.Holds()
+	if e != nil {
+		return nil, nil, e
+	}
+	d, e := r.bgp.Drift(holds)
+
+	return holds, d, e
+}
@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type CommunityKind int
+
+const (
+	// CommunityStandard is an RFC 1997 community: 65000:100 or a well-known name such as no-export
+	CommunityStandard CommunityKind = iota
+	// CommunityExtended is an RFC 4360 route target or site of origin: rt:65000:100, soo:192.0.2.1:7
+	CommunityExtended
+	// CommunityLarge is an RFC 8092 large community: 65000:1:2
+	CommunityLarge
+)
+
+// Extended community sub types
+const (
+	ExtendedRouteTarget = 0x02
+	ExtendedRouteOrigin = 0x03
+)
+
+var wellKnownCommunities = map[string]uint32{
+	"graceful-shutdown":   0xFFFF0000,
+	"blackhole":           0xFFFF029A,
+	"no-export":           0xFFFFFF01,
+	"no-advertise":        0xFFFFFF02,
+	"no-export-subconfed": 0xFFFFFF03,
+	"no-peer":             0xFFFFFF04,
+}
+
+// Community is a parsed BGP community. Standard ones use Value, large ones Global, Local1 and Local2,
+// extended ones SubType, Local and either Global (AS) or Ip.
+type Community struct {
+	Kind    CommunityKind
+	Value   uint32
+	SubType uint32
+	Global  uint32
+	Ip      netip.Addr
+	Local1  uint32
+	Local2  uint32
+}
+
+// ParseCommunity parses a standard, extended or large community in its text form.
+func ParseCommunity(s string) (c Community, e error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if v, ok := wellKnownCommunities[s]; ok {
+		return Community{Kind: CommunityStandard, Value: v}, nil
+	}
+
+	parts := strings.Split(s, ":")
+	switch {
+	case len(parts) == 3 && (parts[0] == "rt" || parts[0] == "soo"):
+		c.Kind = CommunityExtended
+		c.SubType = ExtendedRouteTarget
+		if parts[0] == "soo" {
+			c.SubType = ExtendedRouteOrigin
+		}
+		if a, err := netip.ParseAddr(parts[1]); err == nil && a.Is4() {
+			c.Ip = a
+			c.Local1, e = parseUint(parts[2], 16)
+		} else if c.Global, e = parseUint(parts[1], 32); e == nil {
+			bits := 32
+			if c.Global > 0xFFFF {
+				bits = 16
+			}
+			c.Local1, e = parseUint(parts[2], bits)
+		}
+	case len(parts) == 2:
+		c.Kind = CommunityStandard
+		var hi, lo uint32
+		if hi, e = parseUint(parts[0], 16); e == nil {
+			lo, e = parseUint(parts[1], 16)
+		}
+		c.Value = hi<<16 | lo
+	case len(parts) == 3:
+		c.Kind = CommunityLarge
+		if c.Global, e = parseUint(parts[0], 32); e == nil {
+			if c.Local1, e = parseUint(parts[1], 32); e == nil {
+				c.Local2, e = parseUint(parts[2], 32)
+			}
+		}
+	default:
+		e = fmt.Errorf("unknown format")
+	}
+	if e != nil {
+		return Community{}, fmt.Errorf("invalid community %s: %w", s, e)
+	}
+	return c, nil
+}
+
+// String returns the canonical text form ParseCommunity accepts.
+func (c Community) String() string {
+	switch c.Kind {
+	case CommunityExtended:
+		t := "rt"
+		if c.SubType == ExtendedRouteOrigin {
+			t = "soo"
+		}
+		if c.Ip.IsValid() {
+			return fmt.Sprintf("%s:%s:%d", t, c.Ip, c.Local1)
+		}
+		return fmt.Sprintf("%s:%d:%d", t, c.Global, c.Local1)
+	case CommunityLarge:
+		return fmt.Sprintf("%d:%d:%d", c.Global, c.Local1, c.Local2)
+	default:
+		for n, v := range wellKnownCommunities {
+			if v == c.Value {
+				return n
+			}
+		}
+		return fmt.Sprintf("%d:%d", c.Value>>16, c.Value&0xFFFF)
+	}
+}
+
+// NormalizeCommunities parses communities and returns their canonical forms sorted and without duplicates.
+func NormalizeCommunities(cs []string) ([]string, error) {
+	r := make([]string, 0, len(cs))
+	for _, s := range cs {
+		c, e := ParseCommunity(s)
+		if e != nil {
+			return nil, e
+		}
+		r = append(r, c.String())
+	}
+	slices.Sort(r)
+	return slices.Compact(r), nil
+}
+
+func parseUint(s string, bits int) (uint32, error) {
+	v, e := strconv.ParseUint(s, 10, bits)
+	return uint32(v), e
+}
@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestParseCommunity(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Community
+		text string
+	}{
+		{"65000:100", Community{Kind: CommunityStandard, Value: 65000<<16 | 100}, "65000:100"},
+		{" 0:0 ", Community{Kind: CommunityStandard}, "0:0"},
+		{"65535:65535", Community{Kind: CommunityStandard, Value: 0xFFFFFFFF}, "65535:65535"},
+		{"No-Export", Community{Kind: CommunityStandard, Value: 0xFFFFFF01}, "no-export"},
+		{"65535:65281", Community{Kind: CommunityStandard, Value: 0xFFFFFF01}, "no-export"},
+		{"65000:1:2", Community{Kind: CommunityLarge, Global: 65000, Local1: 1, Local2: 2}, "65000:1:2"},
+		{"4294967295:4294967295:4294967295",
+			Community{Kind: CommunityLarge, Global: 0xFFFFFFFF, Local1: 0xFFFFFFFF, Local2: 0xFFFFFFFF},
+			"4294967295:4294967295:4294967295"},
+		{"rt:65000:100", Community{Kind: CommunityExtended, SubType: ExtendedRouteTarget, Global: 65000, Local1: 100},
+			"rt:65000:100"},
+		{"RT:65000:4294967295",
+			Community{Kind: CommunityExtended, SubType: ExtendedRouteTarget, Global: 65000, Local1: 0xFFFFFFFF},
+			"rt:65000:4294967295"},
+		{"soo:4200000000:7",
+			Community{Kind: CommunityExtended, SubType: ExtendedRouteOrigin, Global: 4200000000, Local1: 7},
+			"soo:4200000000:7"},
+		{"soo:192.0.2.1:7", Community{Kind: CommunityExtended, SubType: ExtendedRouteOrigin,
+			Ip: netip.MustParseAddr("192.0.2.1"), Local1: 7}, "soo:192.0.2.1:7"},
+	}
+	for _, tt := range tests {
+		c, e := ParseCommunity(tt.s)
+		if e != nil {
+			t.Errorf("%q: %v", tt.s, e)
+			continue
+		}
+		if c != tt.want {
+			t.Errorf("%q parsed as %+v, want %+v", tt.s, c, tt.want)
+		}
+		if c.String() != tt.text {
+			t.Errorf("%q printed as %q, want %q", tt.s, c.String(), tt.text)
+		}
+	}
+}
+
+func TestParseCommunityInvalid(t *testing.T) {
+	for _, s := range []string{
+		// out of range
+		"65536:1", "1:65536", "4294967296:1:1", "1:4294967296:1", "1:1:4294967296", "rt:4200000000:65536",
+		"rt:65000:4294967296", "soo:192.0.2.1:65536", "rt:4294967296:1",
+		// malformed
+		"", "65000", "65000:", ":100", "-1:100", "65000:0x10", "a:b", "1:2:3:4", "65000 100", "rt:65000",
+		"rt:2001:db8::1:1", "soo:192.0.2:1", "no-such-community",
+	} {
+		if c, e := ParseCommunity(s); e == nil {
+			t.Errorf("%q parsed as %+v", s, c)
+		}
+	}
+}
+
+func TestNormalizeCommunities(t *testing.T) {
+	got, e := NormalizeCommunities([]string{"65000:1:2", "RT:65000:100", "no-export", "65535:65281", " 65000:100"})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if want := []string{"65000:100", "65000:1:2", "no-export", "rt:65000:100"}; !slices.Equal(got, want) {
+		t.Errorf("normalized into %v, want %v", got, want)
+	}
+	if _, e = NormalizeCommunities([]string{"65000:100", "65000:65536"}); e == nil {
+		t.Error("an invalid community was normalized")
+	}
+}
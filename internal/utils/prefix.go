@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"net/netip"
+	"strings"
+)
+
+// ParsePrefix parses an IP address or a CIDR prefix. Host bits of a prefix are cleared and IPv4-mapped
+// addresses are unmapped. The key is the address alone for a host prefix and the CIDR notation otherwise,
+// so 10.0.0.1, 10.0.0.1/32 and ::ffff:10.0.0.1 share one key.
+func ParsePrefix(s string) (p netip.Prefix, key string, e error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		if p, e = netip.ParsePrefix(s); e != nil {
+			return
+		}
+		a := p.Addr()
+		bits := p.Bits()
+		if a.Is4In6() {
+			a = a.Unmap()
+			bits -= 96
+		}
+		if p, e = a.Prefix(bits); e != nil {
+			return
+		}
+	} else {
+		var a netip.Addr
+		if a, e = netip.ParseAddr(s); e != nil {
+			return
+		}
+		a = a.Unmap()
+		p = netip.PrefixFrom(a, a.BitLen())
+	}
+	if p.IsSingleIP() {
+		return p, p.Addr().String(), nil
+	}
+	return p, p.String(), nil
+}
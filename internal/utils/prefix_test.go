@@ -0,0 +1,48 @@
+package utils
+
+import "testing"
+
+func TestParsePrefix(t *testing.T) {
+	tests := []struct {
+		s      string
+		prefix string
+		key    string
+	}{
+		{"192.0.2.1", "192.0.2.1/32", "192.0.2.1"},
+		{" 192.0.2.1 ", "192.0.2.1/32", "192.0.2.1"},
+		{"2001:db8::1", "2001:db8::1/128", "2001:db8::1"},
+		{"192.0.2.1/32", "192.0.2.1/32", "192.0.2.1"},
+		{"2001:db8::1/128", "2001:db8::1/128", "2001:db8::1"},
+		{"192.0.2.0/24", "192.0.2.0/24", "192.0.2.0/24"},
+		{"2001:db8::/32", "2001:db8::/32", "2001:db8::/32"},
+		{"0.0.0.0/0", "0.0.0.0/0", "0.0.0.0/0"},
+		// host bits are cleared
+		{"192.0.2.77/24", "192.0.2.0/24", "192.0.2.0/24"},
+		{"2001:db8::1/32", "2001:db8::/32", "2001:db8::/32"},
+		// IPv4-mapped addresses are unmapped
+		{"::ffff:192.0.2.1", "192.0.2.1/32", "192.0.2.1"},
+		{"::ffff:192.0.2.1/128", "192.0.2.1/32", "192.0.2.1"},
+		{"::ffff:192.0.2.77/120", "192.0.2.0/24", "192.0.2.0/24"},
+	}
+	for _, tt := range tests {
+		p, key, e := ParsePrefix(tt.s)
+		if e != nil {
+			t.Errorf("%q: %v", tt.s, e)
+			continue
+		}
+		if p.String() != tt.prefix || key != tt.key {
+			t.Errorf("%q parsed as %s, key %q, want %s, key %q", tt.s, p, key, tt.prefix, tt.key)
+		}
+	}
+}
+
+func TestParsePrefixInvalid(t *testing.T) {
+	for _, s := range []string{
+		"", "example.com", "192.0.2", "192.0.2.256", "192.0.2.0/", "192.0.2.0/33", "192.0.2.0/-1",
+		"2001:db8::/129", "::ffff:192.0.2.0/95", "192.0.2.0/24/8",
+	} {
+		if p, _, e := ParsePrefix(s); e == nil {
+			t.Errorf("%q parsed as %s", s, p)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package bgpdns
+
+import (
+	"github.com/red55/bgp-dns/internal/config"
+)
+
+// Configuration types of the daemon, the same ones appsettings.yml is decoded into.
+type (
+	Config                = config.AppCfg
+	LogConfig             = config.LogCfg
+	BgpConfig             = config.BgpCfg
+	BgpNeighbor           = config.BgpNeighbor
+	GracefulRestartConfig = config.GracefulRestartCfg
+	DnsConfig             = config.DnsCfg
+	ListConfig            = config.ListCfg
+	CacheConfig           = config.CacheCfg
+	AdminConfig           = config.AdminCfg
+	ResolverConfig        = config.ResolverCfg
+	EncryptedListenConfig = config.EncryptedListenCfg
+)
+
+// LoadConfig reads a YAML configuration file in the appsettings.yml format, with the settings overridden by
+// BGPDNS_* environment variables.
+func LoadConfig(path string) (*Config, error) {
+	return config.Init(path)
+}
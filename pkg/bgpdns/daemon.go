@@ -0,0 +1,366 @@
+// Package bgpdns embeds the bgp-dns daemon: a caching DNS proxy which announces the addresses of listed
+// domains to BGP peers. Every Daemon owns its BGP speaker, DNS server, cache and list watcher, so several
+// isolated instances may run in one process.
+package bgpdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/red55/bgp-dns/internal/admin"
+	"github.com/red55/bgp-dns/internal/bgp"
+	"github.com/red55/bgp-dns/internal/dns"
+	"github.com/red55/bgp-dns/internal/fetcher"
+	"github.com/red55/bgp-dns/internal/fswatcher"
+	"github.com/red55/bgp-dns/internal/log"
+	"github.com/red55/bgp-dns/internal/metrics"
+	"github.com/red55/bgp-dns/internal/reconciler"
+	"github.com/rs/zerolog"
+	"reflect"
+	"slices"
+	"sync"
+)
+
+type Daemon struct {
+	log.Log
+	m       sync.Mutex
+	cfg     *Config
+	metrics *metrics.Metrics
+	// logs are the loggers of the modules of the daemon, at the levels of cfg.Log
+	logs    *log.Logs
+	bgp     bgp.Speaker
+	dns     dns.Service
+	watcher  fswatcher.Watcher
+	fetchers []fetcher.Fetcher
+	reconciler reconciler.Reconciler
+	admin    admin.Server
+	// ctx is the context the daemon was started with, the watcher and the fetchers Reload starts live in it
+	ctx     context.Context
+	running bool
+}
+
+var (
+	ENilConfig      = errors.New("configuration is nil")
+	EAlreadyStarted = errors.New("daemon is already started")
+	ENotStarted     = errors.New("daemon is not started")
+	EInvalidConfig  = errors.New("invalid configuration")
+)
+
+// New creates a daemon from cfg. Nothing is bound or announced until Start is called.
+func New(cfg *Config) (*Daemon, error) {
+	if cfg == nil {
+		return nil, ENilConfig
+	}
+	if e := cfg.Validate(); e != nil {
+		return nil, fmt.Errorf("%w: %w", EInvalidConfig, e)
+	}
+
+	logs := log.NewLogs(nil)
+	logs.Configure(&cfg.Log)
+	d := &Daemon{
+		Log:     logs.NewLog("daemon"),
+		cfg:     cfg,
+		metrics: metrics.New(),
+		logs:    logs,
+	}
+	d.bgp = bgp.New(cfg, d.metrics, logs)
+	d.dns = dns.New(cfg, d.bgp, d.metrics, logs)
+	d.watcher = d.newWatcher(cfg)
+	d.fetchers = d.newFetchers(cfg)
+	d.reconciler = reconciler.New(&cfg.Bgp, d.dns, d.bgp, d.metrics, logs)
+	if cfg.Admin.Listen != nil || cfg.Admin.Socket != "" {
+		d.admin = admin.New(&cfg.Admin, d.dns, d.bgp, d.reconciler, d.metrics, logs)
+	}
+
+	return d, nil
+}
+
+// SetLogger makes the daemon log through l, at the levels of its Log configuration. The daemon is silent
+// until a logger is set.
+func (d *Daemon) SetLogger(l zerolog.Logger) {
+	d.logs.SetLogger(&l)
+}
+
+// SetLogLevels makes every module of the daemon log at lvl until ResetLogLevels or the next Reload.
+func (d *Daemon) SetLogLevels(lvl zerolog.Level) {
+	d.logs.SetLevels(lvl)
+}
+
+// ResetLogLevels gives every module of the daemon back its configured level.
+func (d *Daemon) ResetLogLevels() {
+	d.logs.ResetLevels()
+}
+
+// Start brings up the BGP speaker and the DNS server, loads the configured lists, connects the peers, starts
+// watching the local lists, downloading the remote ones and reconciling the RIB with the cache, and opens the
+// admin API when configured. A remote list starts from the last good copy in its File.
+// On failure everything already started is shut down again.
+func (d *Daemon) Start(ctx context.Context) (e error) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if d.running {
+		return EAlreadyStarted
+	}
+	d.ctx = ctx
+
+	var stops []func(context.Context) error
+	defer func() {
+		if e != nil {
+			for i := len(stops) - 1; i >= 0; i-- {
+				_ = stops[i](ctx)
+			}
+		}
+	}()
+
+	if e = d.bgp.Serve(ctx); e != nil {
+		return
+	}
+	stops = append(stops, d.bgp.Shutdown)
+
+	if e = d.dns.Serve(ctx); e != nil {
+		return
+	}
+	stops = append(stops, d.dns.Shutdown)
+
+	for _, l := range d.cfg.Dns.AllLists() {
+		if e = d.dns.Load(l.File); e != nil {
+			return
+		}
+	}
+	if e = d.dns.Settle(); e != nil {
+		return
+	}
+	if e = d.bgp.Connect(ctx); e != nil {
+		return
+	}
+	if d.watcher != nil {
+		if e = d.watcher.Serve(ctx); e != nil {
+			return
+		}
+		stops = append(stops, d.watcher.Shutdown)
+	}
+	for _, f := range d.fetchers {
+		if e = f.Serve(ctx); e != nil {
+			return
+		}
+		stops = append(stops, f.Shutdown)
+	}
+	if e = d.reconciler.Serve(ctx); e != nil {
+		return
+	}
+	stops = append(stops, d.reconciler.Shutdown)
+
+	if d.admin != nil {
+		if e = d.admin.Serve(ctx); e != nil {
+			return
+		}
+	}
+
+	d.running = true
+	return nil
+}
+
+// Stop withdraws every announced prefix and shuts down the reconciler, the watcher, the DNS server and the BGP
+// speaker.
+// With graceful restart configured nothing is withdrawn and the BGP sessions are closed with an Administrative
+// Reset, which peers supporting RFC 8538 take for a restart: they keep forwarding to the announced prefixes
+// while the daemon restarts, in the same process or another one.
+func (d *Daemon) Stop(ctx context.Context) error {
+	d.m.Lock()
+	if !d.running {
+		d.m.Unlock()
+		return ENotStarted
+	}
+	d.running = false
+	d.m.Unlock()
+
+	// a reload requested through the admin API takes the lock, the API waits for its requests when shut down
+	var errs []error
+	if d.admin != nil {
+		if e := d.admin.Shutdown(ctx); e != nil {
+			d.L().Error().Err(e).Msg("Admin API Shutdown failed")
+			errs = append(errs, e)
+		}
+	}
+
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	_ = d.reconciler.Shutdown(ctx)
+	for _, f := range d.fetchers {
+		_ = f.Shutdown(ctx)
+	}
+	if d.watcher != nil {
+		if e := d.watcher.Shutdown(ctx); e != nil {
+			d.L().Error().Err(e).Msg("FSWatcher Shutdown failed")
+			errs = append(errs, e)
+		}
+	}
+	if e := d.dns.Shutdown(ctx); e != nil {
+		d.L().Error().Err(e).Msg("DNS Shutdown failed")
+		errs = append(errs, e)
+	}
+	if e := d.bgp.Shutdown(ctx); e != nil {
+		d.L().Error().Err(e).Msg("BGP Shutdown failed")
+		errs = append(errs, e)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Reload applies cfg to the running daemon. Peers are added, removed or reset when changed, resolvers are
+// swapped, cache limits changed and lists loaded or dropped in place. Settings bound at start, such as the
+// listen addresses, the ASN or the router ID, keep their running values until a restart. An invalid cfg is
+// rejected and the running configuration kept.
+func (d *Daemon) Reload(cfg *Config) error {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if cfg == nil {
+		return ENilConfig
+	}
+	if !d.running {
+		return ENotStarted
+	}
+	if e := cfg.Validate(); e != nil {
+		return fmt.Errorf("%w: %w", EInvalidConfig, e)
+	}
+	for _, n := range keepStatic(d.cfg, cfg) {
+		d.L().Warn().Msgf("%s changed, restart to apply it", n)
+	}
+
+	d.logs.Configure(&cfg.Log)
+
+	var errs []error
+	if e := d.bgp.Reload(d.ctx, cfg); e != nil {
+		d.L().Error().Err(e).Msg("BGP Reload failed")
+		errs = append(errs, e)
+	}
+	if e := d.dns.Reload(cfg); e != nil {
+		d.L().Error().Err(e).Msg("DNS Reload failed")
+		errs = append(errs, e)
+	}
+
+	if !slices.Equal(listPaths(d.cfg), listPaths(cfg)) {
+		if d.watcher != nil {
+			_ = d.watcher.Shutdown(d.ctx)
+		}
+		if d.watcher = d.newWatcher(cfg); d.watcher != nil {
+			if e := d.watcher.Serve(d.ctx); e != nil {
+				d.L().Error().Err(e).Msg("FSWatcher Serve failed")
+				errs = append(errs, e)
+				d.watcher = nil
+			}
+		}
+	}
+	if !reflect.DeepEqual(remoteLists(d.cfg), remoteLists(cfg)) {
+		for _, f := range d.fetchers {
+			_ = f.Shutdown(d.ctx)
+		}
+		d.fetchers = nil
+		for _, f := range d.newFetchers(cfg) {
+			if e := f.Serve(d.ctx); e != nil {
+				d.L().Error().Err(e).Msg("Fetcher Serve failed")
+				errs = append(errs, e)
+				continue
+			}
+			d.fetchers = append(d.fetchers, f)
+		}
+	}
+
+	d.cfg = cfg
+	return errors.Join(errs...)
+}
+
+// keepStatic copies the settings of old which are bound at start into cfg and returns the names of the ones
+// cfg changes.
+func keepStatic(old, cfg *Config) (changed []string) {
+	for _, f := range []struct {
+		name     string
+		old, cfg any
+	}{
+		{"Bgp.Asn", &old.Bgp.Asn, &cfg.Bgp.Asn},
+		{"Bgp.Id", &old.Bgp.Id, &cfg.Bgp.Id},
+		{"Bgp.Listen", &old.Bgp.Listen, &cfg.Bgp.Listen},
+		{"Bgp.NextHop6", &old.Bgp.NextHop6, &cfg.Bgp.NextHop6},
+		{"Bgp.Communities", &old.Bgp.Communities, &cfg.Bgp.Communities},
+		{"Bgp.Aggregation", &old.Bgp.Aggregation, &cfg.Bgp.Aggregation},
+		{"Bgp.GracefulRestart", &old.Bgp.GracefulRestart, &cfg.Bgp.GracefulRestart},
+		{"Bgp.ReconcileInterval", &old.Bgp.ReconcileInterval, &cfg.Bgp.ReconcileInterval},
+		{"Dns.Listen", &old.Dns.Listen, &cfg.Dns.Listen},
+		{"Dns.Tls", &old.Dns.Tls, &cfg.Dns.Tls},
+		{"Dns.Https", &old.Dns.Https, &cfg.Dns.Https},
+		{"Dns.Cache.StateFile", &old.Dns.Cache.StateFile, &cfg.Dns.Cache.StateFile},
+		{"Dns.Cache.StateInterval", &old.Dns.Cache.StateInterval, &cfg.Dns.Cache.StateInterval},
+		{"Admin", &old.Admin, &cfg.Admin},
+	} {
+		if !reflect.DeepEqual(f.old, f.cfg) {
+			changed = append(changed, f.name)
+			reflect.ValueOf(f.cfg).Elem().Set(reflect.ValueOf(f.old).Elem())
+		}
+	}
+	return
+}
+
+// OnReload sets what POST /reload of the admin API runs, typically reading the configuration again and
+// passing it to Reload. Without it the API refuses to reload.
+func (d *Daemon) OnReload(f func() error) {
+	if d.admin != nil {
+		d.admin.SetReload(f)
+	}
+}
+
+// Register starts tracking fqdn, its addresses are announced once resolved.
+func (d *Daemon) Register(fqdn string) error {
+	return d.dns.Register(fqdn)
+}
+
+// Unregister stops tracking fqdn and withdraws its addresses.
+func (d *Daemon) Unregister(fqdn string) error {
+	return d.dns.Unregister(fqdn)
+}
+
+// listPaths returns the files and directories of the local lists of cfg, remote ones are written by their
+// fetcher, which loads them itself.
+func listPaths(cfg *Config) []string {
+	var r []string
+	for _, l := range cfg.Dns.AllLists() {
+		if l.Url == "" {
+			r = append(r, l.File)
+		}
+	}
+	return r
+}
+
+// remoteLists returns the lists of cfg which are downloaded.
+func remoteLists(cfg *Config) []*ListConfig {
+	var r []*ListConfig
+	for _, l := range cfg.Dns.AllLists() {
+		if l.Url != "" {
+			r = append(r, l)
+		}
+	}
+	return r
+}
+
+// newWatcher returns the watcher of the local lists of cfg, nil when there are none.
+func (d *Daemon) newWatcher(cfg *Config) fswatcher.Watcher {
+	if paths := listPaths(cfg); len(paths) > 0 {
+		return fswatcher.New(paths, d.dns, d.logs)
+	}
+	return nil
+}
+
+func (d *Daemon) newFetchers(cfg *Config) []fetcher.Fetcher {
+	var r []fetcher.Fetcher
+	for _, l := range remoteLists(cfg) {
+		r = append(r, fetcher.New(l, d.dns, d.metrics, d.logs))
+	}
+	return r
+}
+
+// Load (re)loads a domain list file or directory, domains no list holds anymore are unregistered.
+func (d *Daemon) Load(fn string) error {
+	return d.dns.Load(fn)
+}
@@ -0,0 +1,198 @@
+package bgpdns
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/rs/zerolog"
+)
+
+const testAnswer = "192.0.2.1"
+
+// syncBuffer is a log output written by the goroutines of a daemon.
+type syncBuffer struct {
+	m sync.Mutex
+	b bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.b.String()
+}
+
+// newUpstream starts a DNS server answering every query with an A record of testAnswer.
+func newUpstream(t *testing.T) string {
+	pc, e := net.ListenPacket("udp", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	srv := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, q *dns.Msg) {
+		a := new(dns.Msg)
+		a.SetReply(q)
+		a.Answer = append(a.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP(testAnswer),
+		})
+		_ = w.WriteMsg(a)
+	})}
+	go func() {
+		_ = srv.ActivateAndServe()
+	}()
+	t.Cleanup(func() {
+		_ = srv.Shutdown()
+	})
+	return pc.LocalAddr().String()
+}
+
+// freePort returns a port nothing listens on over TCP and UDP on 127.0.0.1.
+func freePort(t *testing.T) int {
+	for i := 0; i < 10; i++ {
+		l, e := net.Listen("tcp", "127.0.0.1:0")
+		if e != nil {
+			t.Fatal(e)
+		}
+		port := l.Addr().(*net.TCPAddr).Port
+		_ = l.Close()
+		if pc, e := net.ListenPacket("udp", l.Addr().String()); e == nil {
+			_ = pc.Close()
+			return port
+		}
+	}
+	t.Fatal("no free port")
+	return 0
+}
+
+// newConfig returns the configuration of a daemon with a passive peer, which never connects, logging at lvl.
+func newConfig(t *testing.T, upstream string, lvl zerolog.Level) *Config {
+	cfg := &Config{}
+	cfg.Log.Level = lvl
+	cfg.Bgp.Asn = 65530
+	cfg.Bgp.Id = net.ParseIP("127.0.0.1")
+	cfg.Bgp.Listen = net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: freePort(t)}
+	cfg.Bgp.Peers = []*BgpNeighbor{{
+		Asn:         65531,
+		Addr:        net.TCPAddr{IP: net.ParseIP("192.0.2.10")},
+		PassiveMode: true,
+	}}
+	cfg.Dns.Listen = &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: freePort(t)}
+	cfg.Dns.Resolvers = []*ResolverConfig{{Url: upstream}}
+	cfg.Dns.List.Resolvers = []*ResolverConfig{{Url: upstream}}
+	cfg.Dns.Cache.MaxEntries = 100
+	return cfg
+}
+
+// resolve queries the DNS server of cfg for name.
+func resolve(t *testing.T, cfg *Config, name string) {
+	q := new(dns.Msg)
+	q.SetQuestion(dns.Fqdn(name), dns.TypeA)
+	a, _, e := new(dns.Client).Exchange(q, cfg.Dns.Listen.String())
+	if e != nil {
+		t.Fatalf("%s: %v", cfg.Dns.Listen, e)
+	}
+	if len(a.Answer) != 1 || a.Answer[0].(*dns.A).A.String() != testAnswer {
+		t.Fatalf("%s answered %v", cfg.Dns.Listen, a.Answer)
+	}
+}
+
+func TestTwoDaemons(t *testing.T) {
+	upstream := newUpstream(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cfgs := []*Config{newConfig(t, upstream, zerolog.InfoLevel), newConfig(t, upstream, zerolog.DebugLevel)}
+	var ds []*Daemon
+	var logs []*syncBuffer
+	for _, cfg := range cfgs {
+		d, e := New(cfg)
+		if e != nil {
+			t.Fatal(e)
+		}
+		b := new(syncBuffer)
+		d.SetLogger(zerolog.New(b))
+		if e = d.Start(ctx); e != nil {
+			t.Fatalf("%s: %v", cfg.Dns.Listen, e)
+		}
+		ds = append(ds, d)
+		logs = append(logs, b)
+	}
+	defer func() {
+		for _, d := range ds {
+			_ = d.Stop(ctx)
+		}
+	}()
+
+	for _, cfg := range cfgs {
+		resolve(t, cfg, "example.org")
+	}
+
+	// each daemon logs at the level of its own configuration
+	if strings.Contains(logs[0].String(), `"level":"debug"`) {
+		t.Error("a daemon configured at info logged at debug")
+	}
+	if !strings.Contains(logs[1].String(), "for example.org.") {
+		t.Error("a daemon configured at debug did not log the query at debug")
+	}
+
+	for i, d := range ds {
+		if e := d.Stop(ctx); e != nil {
+			t.Fatalf("%s: %v", cfgs[i].Dns.Listen, e)
+		}
+	}
+	if e := ds[0].Stop(ctx); e != ENotStarted {
+		t.Errorf("stopping a stopped daemon returned %v", e)
+	}
+	for _, cfg := range cfgs {
+		released(t, cfg)
+	}
+	ds = nil
+}
+
+// released fails unless the DNS and BGP ports of cfg are free again.
+func released(t *testing.T, cfg *Config) {
+	pc, e := net.ListenPacket("udp", cfg.Dns.Listen.String())
+	if e != nil {
+		t.Fatalf("DNS port still bound: %v", e)
+	}
+	_ = pc.Close()
+	l, e := net.Listen("tcp", cfg.Bgp.Listen.String())
+	if e != nil {
+		t.Fatalf("BGP port still bound: %v", e)
+	}
+	_ = l.Close()
+}
+
+func TestRestartWithGracefulRestart(t *testing.T) {
+	upstream := newUpstream(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cfg := newConfig(t, upstream, zerolog.InfoLevel)
+	cfg.Bgp.GracefulRestart = &GracefulRestartConfig{RestartTime: 30}
+	d, e := New(cfg)
+	if e != nil {
+		t.Fatal(e)
+	}
+	for i := 0; i < 2; i++ {
+		if e = d.Start(ctx); e != nil {
+			t.Fatalf("start %d: %v", i+1, e)
+		}
+		resolve(t, cfg, "example.org")
+		if e = d.Stop(ctx); e != nil {
+			t.Fatalf("stop %d: %v", i+1, e)
+		}
+		released(t, cfg)
+	}
+}